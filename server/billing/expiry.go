@@ -0,0 +1,156 @@
+package billing
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+
+	"diplom/mail"
+	"diplom/models"
+)
+
+// За сколько дней до окончания подписки без автопродления напоминаем владельцу семьи
+var expiryNoticeDays = []int{7, 1}
+
+// ProcessExpiry переводит подписки на план начавшегося периода, предупреждает
+// об окончании подписок, отмечает начало льготного срока и отключает подписки, у которых
+// он прошёл. Вызывается фоновой задачей.
+func ProcessExpiry(db *gorm.DB) {
+	now := time.Now()
+	advancePlans(db, now)
+	sendExpiryNotices(db, now)
+	startGracePeriods(db, now)
+	deactivateExpired(db, now)
+}
+
+// advancePlans — начался период, купленный заранее по другому плану: подписка переходит на него.
+func advancePlans(db *gorm.DB, now time.Time) {
+	var subs []models.FamilySubscription
+	if err := db.Where("is_active = ? AND end_date > ?", true, now).Find(&subs).Error; err != nil {
+		log.Printf("Смена плана подписок: ошибка выборки: %v\n", err)
+		return
+	}
+	for _, sub := range subs {
+		changed, err := AdvancePlan(db, &sub, now)
+		if err != nil {
+			log.Printf("Смена плана подписки семьи %d: %v\n", sub.FamilyID, err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		summary := "Подписка перешла на новый план"
+		var plan models.Plan
+		if sub.PlanID != nil && db.First(&plan, *sub.PlanID).Error == nil {
+			summary = "Подписка перешла на план «" + plan.Name + "»"
+		}
+		notifier.SubscriptionChanged(sub, EventPlanChanged, summary)
+	}
+}
+
+// sendExpiryNotices — письма владельцу за 7 дней и за 1 день до окончания.
+// Подписки с автопродлением пропускаем: о них напоминает ProcessRenewals.
+func sendExpiryNotices(db *gorm.DB, now time.Time) {
+	var subs []models.FamilySubscription
+	if err := db.
+		Where("is_active = ? AND auto_renew = ?", true, false).
+		Where("end_date > ? AND end_date <= ?", now, now.AddDate(0, 0, expiryNoticeDays[0])).
+		Find(&subs).Error; err != nil {
+		log.Printf("Окончание подписок: ошибка выборки: %v\n", err)
+		return
+	}
+
+	mailer := mail.NewMailService()
+	for _, sub := range subs {
+		left := sub.EndDate.Sub(now)
+		days := expiryNoticeDays[0]
+		for _, d := range expiryNoticeDays {
+			if left <= time.Duration(d)*24*time.Hour {
+				days = d
+			}
+		}
+		if !claimExpiryNotice(db, sub, days) {
+			continue
+		}
+		if to := familyOwnerEmail(db, sub.FamilyID); to != "" {
+			daysLeft := int(math.Ceil(left.Hours() / 24))
+			if err := mailer.SendSubscriptionExpiringMail(to, sub.EndDate, daysLeft); err != nil {
+				log.Printf("Не удалось отправить письмо об окончании подписки на %s: %v\n", to, err)
+			}
+		}
+	}
+}
+
+// startGracePeriods — оплаченный период закончился, начинается льготный срок.
+func startGracePeriods(db *gorm.DB, now time.Time) {
+	var subs []models.FamilySubscription
+	if err := db.
+		Where("is_active = ? AND end_date <= ? AND end_date > ?", true, now, now.Add(-GracePeriod)).
+		Find(&subs).Error; err != nil {
+		log.Printf("Окончание подписок: ошибка выборки: %v\n", err)
+		return
+	}
+	for _, sub := range subs {
+		if !claimExpiryNotice(db, sub, 0) {
+			continue
+		}
+		notifier.SubscriptionChanged(sub, EventGrace,
+			"Оплаченный период закончился, подписка действует до "+GraceUntil(sub).Format("02.01.2006"))
+	}
+}
+
+// deactivateExpired отключает подписки, у которых прошёл льготный срок.
+func deactivateExpired(db *gorm.DB, now time.Time) {
+	var subs []models.FamilySubscription
+	if err := db.
+		Where("is_active = ? AND end_date <= ?", true, now.Add(-GracePeriod)).
+		Find(&subs).Error; err != nil {
+		log.Printf("Окончание подписок: ошибка выборки: %v\n", err)
+		return
+	}
+
+	mailer := mail.NewMailService()
+	for _, sub := range subs {
+		// Условие is_active = true — чтобы параллельный запуск не отключил подписку дважды
+		res := db.Model(&models.FamilySubscription{}).
+			Where("id = ? AND is_active = ?", sub.ID, true).
+			Update("is_active", false)
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		sub.IsActive = false
+		log.Printf("Подписка семьи %d закончилась\n", sub.FamilyID)
+
+		notifier.SubscriptionChanged(sub, EventExpired, "Подписка закончилась")
+		if to := familyOwnerEmail(db, sub.FamilyID); to != "" {
+			if err := mailer.SendSubscriptionExpiredMail(to); err != nil {
+				log.Printf("Не удалось отправить письмо об окончании подписки на %s: %v\n", to, err)
+			}
+		}
+	}
+}
+
+// claimExpiryNotice отмечает, что уведомление за days дней до EndDate отправлено, и возвращает false,
+// если его (или более позднее) уже отправили — в том числе другой экземпляр сервера.
+func claimExpiryNotice(db *gorm.DB, sub models.FamilySubscription, days int) bool {
+	res := db.Model(&models.FamilySubscription{}).
+		Where("id = ? AND end_date = ?", sub.ID, sub.EndDate).
+		Where("expiry_notice_for IS NULL OR expiry_notice_for <> end_date OR expiry_notice_days > ?", days).
+		Updates(map[string]interface{}{"expiry_notice_for": sub.EndDate, "expiry_notice_days": days})
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// familyOwnerEmail — адрес владельца семьи или "", если его не найти.
+func familyOwnerEmail(db *gorm.DB, familyID uint) string {
+	var family models.Family
+	if err := db.First(&family, familyID).Error; err != nil {
+		return ""
+	}
+	var owner models.User
+	if err := db.First(&owner, family.OwnerID).Error; err != nil {
+		return ""
+	}
+	return owner.Email
+}
@@ -0,0 +1,101 @@
+package billing
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/models"
+	"diplom/payments"
+)
+
+// Статусы подарочных кодов
+const (
+	GiftPending  = "pending"  // ждёт оплаты
+	GiftPaid     = "paid"     // оплачен, можно активировать
+	GiftRedeemed = "redeemed" // активирован семьёй
+	GiftCanceled = "canceled" // оплата отменена или возвращена до активации
+)
+
+var (
+	ErrGiftNotFound = errors.New("подарочный код не найден")
+	ErrGiftRedeemed = errors.New("подарочный код уже активирован")
+)
+
+// Без похожих символов (0/O, 1/I), чтобы код было удобно продиктовать
+const giftAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewGiftCode генерирует код вида GIFT-XXXX-XXXX-XXXX.
+func NewGiftCode() (string, error) {
+	b := []byte("GIFT-")
+	for i := 0; i < 12; i++ {
+		if i > 0 && i%4 == 0 {
+			b = append(b, '-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b = append(b, giftAlphabet[n.Int64()])
+	}
+	return string(b), nil
+}
+
+// RedeemGift активирует оплаченный подарочный код для семьи: добавляет ей период по плану подарка.
+// Период привязан к оплате подарка — возврат этой оплаты сократит подписку семьи.
+// Вызывается внутри транзакции.
+func RedeemGift(tx *gorm.DB, code string, familyID, userID uint, now time.Time) (models.GiftCode, models.FamilySubscription, error) {
+	var gift models.GiftCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", NormalizeCode(code)).First(&gift).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return gift, models.FamilySubscription{}, ErrGiftNotFound
+	}
+	if err != nil {
+		return gift, models.FamilySubscription{}, err
+	}
+	switch gift.Status {
+	case GiftPaid:
+	case GiftRedeemed:
+		return gift, models.FamilySubscription{}, ErrGiftRedeemed
+	default:
+		return gift, models.FamilySubscription{}, ErrGiftNotFound // не оплачен или аннулирован
+	}
+
+	var payment models.Payment
+	if err := tx.Where("gift_code_id = ? AND status = ?", gift.ID, payments.StatusSucceeded).First(&payment).Error; err != nil {
+		return gift, models.FamilySubscription{}, err
+	}
+	sub, _, err := extendByPlan(tx, familyID, &gift.PlanID, &payment.ID, SourceGift, now)
+	if err != nil {
+		return gift, sub, err
+	}
+
+	gift.Status = GiftRedeemed
+	gift.RedeemedFamilyID = &familyID
+	gift.RedeemedByID = &userID
+	gift.RedeemedAt = &now
+	return gift, sub, tx.Save(&gift).Error
+}
+
+// revokeGift — оплата подарка возвращена (full — полностью) или отменена. Неактивированный код
+// аннулируется только при полном возврате; в обоих случаях подписку менять не нужно
+// (gorm.ErrRecordNotFound). Для активированного кода возвращается семья, которая его активировала.
+func revokeGift(tx *gorm.DB, giftID uint, full bool) (uint, error) {
+	var gift models.GiftCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&gift, giftID).Error; err != nil {
+		return 0, err
+	}
+	if gift.RedeemedFamilyID == nil {
+		if full {
+			if err := tx.Model(&gift).Update("status", GiftCanceled).Error; err != nil {
+				return 0, err
+			}
+		}
+		return 0, gorm.ErrRecordNotFound
+	}
+	return *gift.RedeemedFamilyID, nil
+}
@@ -0,0 +1,41 @@
+package billing
+
+import (
+	"context"
+
+	"diplom/models"
+	"diplom/payments"
+)
+
+// События подписки, о которых сообщают фоновые задачи
+const (
+	EventPlanChanged = "plan_changed" // начался период, купленный по другому плану
+	EventGrace       = "grace"        // оплаченный период закончился, идёт льготный срок
+	EventExpired     = "expired"      // льготный срок прошёл, подписка отключена
+)
+
+// Notifier получает результаты фоновых задач подписок. Лента семьи, WebSocket и обработка
+// платежей живут в пакете controllers, поэтому billing вызывает их через этот интерфейс.
+type Notifier interface {
+	// SubscriptionChanged — подписка изменилась без участия пользователя
+	SubscriptionChanged(sub models.FamilySubscription, event, summary string)
+	// PaymentFinished — провайдер сразу вернул итоговый статус автосписания;
+	// применяется тем же путём, что и уведомление от провайдера
+	PaymentFinished(ctx context.Context, provider payments.Provider, n *payments.Notification) error
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) SubscriptionChanged(models.FamilySubscription, string, string) {}
+
+func (noopNotifier) PaymentFinished(context.Context, payments.Provider, *payments.Notification) error {
+	return nil
+}
+
+var notifier Notifier = noopNotifier{}
+
+// SetNotifier задаёт получателя событий фоновых задач. Без него автосписание, завершившееся
+// сразу, применится только по уведомлению провайдера.
+func SetNotifier(n Notifier) {
+	notifier = n
+}
@@ -0,0 +1,82 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"diplom/models"
+)
+
+// Возможности, которые открывает подписка
+const (
+	FeatureExtraCalendars = "extra_calendars" // дополнительные календари семьи
+	FeatureChatMedia      = "chat_media"      // изображения в семейном чате
+)
+
+// DefaultPlanCode — план, который покупается, если plan_id не указан.
+const DefaultPlanCode = "monthly"
+
+// defaultPlans — планы, создаваемые при первом запуске. Цену месячного плана можно задать
+// через PAYMENT_AMOUNT (как было до появления каталога).
+func defaultPlans() []models.Plan {
+	monthly := "300.00"
+	if v, err := strconv.ParseFloat(os.Getenv("PAYMENT_AMOUNT"), 64); err == nil && v > 0 {
+		monthly = fmt.Sprintf("%.2f", v)
+	}
+	base := []string{FeatureExtraCalendars, FeatureChatMedia}
+	return []models.Plan{
+		{Code: DefaultPlanCode, Name: "Месяц", Description: "Подписка на 1 месяц",
+			Price: monthly, Currency: "RUB", DurationMonths: 1, Features: base, IsActive: true, SortOrder: 10},
+		{Code: "yearly", Name: "Год", Description: "Подписка на 12 месяцев со скидкой",
+			Price: "3000.00", Currency: "RUB", DurationMonths: 12, Features: base, IsActive: true, SortOrder: 20},
+	}
+}
+
+// retiredPlanCodes — планы, которые больше не продаются: «Семья+» обещал возможности,
+// которых в приложении нет. Оплаченные периоды по ним действуют до конца.
+var retiredPlanCodes = []string{"family-plus"}
+
+// SeedPlans создаёт недостающие планы и снимает с продажи выведенные.
+// Изменённые в базе цены и описания не перезаписываются.
+func SeedPlans(db *gorm.DB) error {
+	for _, p := range defaultPlans() {
+		plan := p
+		if err := db.Where(models.Plan{Code: p.Code}).Attrs(p).FirstOrCreate(&plan).Error; err != nil {
+			return err
+		}
+	}
+	return db.Model(&models.Plan{}).Where("code IN ?", retiredPlanCodes).Update("is_active", false).Error
+}
+
+// HasFeature сообщает, открыта ли семье возможность feature: подписка действует
+// (в том числе в льготный срок) и её план включает feature.
+func HasFeature(db *gorm.DB, familyID uint, feature string) (bool, error) {
+	var sub models.FamilySubscription
+	err := db.Where("family_id = ?", familyID).Limit(1).Find(&sub).Error
+	if err != nil || sub.ID == 0 || State(sub, time.Now()) == StateExpired {
+		return false, err
+	}
+	var plan models.Plan
+	if sub.PlanID != nil {
+		err = db.First(&plan, *sub.PlanID).Error
+	} else {
+		err = db.Where("code = ?", DefaultPlanCode).First(&plan).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, f := range plan.Features {
+		if f == feature {
+			return true, nil
+		}
+	}
+	return false, nil
+}
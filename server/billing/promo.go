@@ -0,0 +1,116 @@
+package billing
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/models"
+	"diplom/payments"
+)
+
+var (
+	ErrPromoNotFound      = errors.New("промокод не найден")
+	ErrPromoInactive      = errors.New("промокод не действует")
+	ErrPromoExhausted     = errors.New("промокод больше не действует: исчерпан лимит использований")
+	ErrPromoUsed          = errors.New("семья уже использовала этот промокод")
+	ErrPromoNotApplicable = errors.New("промокод не применим к этому плану")
+)
+
+// NormalizeCode приводит введённый пользователем код к виду, в котором он хранится.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// FindPromo ищет промокод и проверяет, что он включён и действует на момент now.
+func FindPromo(db *gorm.DB, code string, now time.Time) (models.PromoCode, error) {
+	var promo models.PromoCode
+	err := db.Where("code = ?", NormalizeCode(code)).First(&promo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return promo, ErrPromoNotFound
+	}
+	if err != nil {
+		return promo, err
+	}
+	if !promo.IsActive ||
+		(promo.ValidFrom != nil && now.Before(*promo.ValidFrom)) ||
+		(promo.ValidUntil != nil && !now.Before(*promo.ValidUntil)) {
+		return promo, ErrPromoInactive
+	}
+	if promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions {
+		return promo, ErrPromoExhausted
+	}
+	return promo, nil
+}
+
+// PromoPrice считает цену плана со скидкой. Возвращает цену и размер скидки ("250.00", "50.00").
+// Скидка, после которой платить нечего, не применяется: провайдер не принимает нулевые платежи.
+func PromoPrice(promo models.PromoCode, plan models.Plan) (price, discount string, err error) {
+	full, err := payments.ParseAmount(plan.Price)
+	if err != nil {
+		return "", "", err
+	}
+	var off int64
+	if promo.DiscountPercent > 0 {
+		off = full * int64(promo.DiscountPercent) / 100
+	} else if off, err = payments.ParseAmount(promo.DiscountAmount); err != nil {
+		return "", "", err
+	}
+	if off <= 0 || off >= full {
+		return "", "", ErrPromoNotApplicable
+	}
+	return payments.FormatAmount(full - off), payments.FormatAmount(off), nil
+}
+
+// ReservePromo проверяет промокод для семьи и занимает одно использование. Если платёж
+// так и не пройдёт, использование возвращается через ReleasePromo.
+// Вызывается внутри транзакции, в которой сохраняется Payment: строка семьи блокируется
+// до её конца, чтобы параллельные покупки не прошли проверку «один раз на семью» обе.
+func ReservePromo(tx *gorm.DB, code string, plan models.Plan, familyID uint, now time.Time) (promo models.PromoCode, price, discount string, err error) {
+	var family models.Family
+	if err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&family, familyID).Error; err != nil {
+		return
+	}
+	if promo, err = FindPromo(tx, code, now); err != nil {
+		return
+	}
+	if price, discount, err = PromoPrice(promo, plan); err != nil {
+		return
+	}
+
+	// Один промокод — одна покупка на семью (возвращённые и отменённые платежи не считаются)
+	var used int64
+	if err = tx.Model(&models.Payment{}).
+		Where("promo_code_id = ? AND family_id = ? AND status IN ?", promo.ID, familyID,
+			[]string{payments.StatusPending, payments.StatusWaitingForCapture, payments.StatusSucceeded}).
+		Count(&used).Error; err != nil {
+		return
+	}
+	if used > 0 {
+		err = ErrPromoUsed
+		return
+	}
+
+	// Условие на счётчике — чтобы параллельные покупки не превысили лимит
+	res := tx.Model(&models.PromoCode{}).
+		Where("id = ? AND (max_redemptions = 0 OR redemptions < max_redemptions)", promo.ID).
+		Update("redemptions", gorm.Expr("redemptions + 1"))
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	if res.RowsAffected == 0 {
+		err = ErrPromoExhausted
+	}
+	return
+}
+
+// ReleasePromo возвращает использование промокода, занятое неоплаченной покупкой.
+func ReleasePromo(db *gorm.DB, promoID uint) error {
+	return db.Model(&models.PromoCode{}).
+		Where("id = ? AND redemptions > 0", promoID).
+		Update("redemptions", gorm.Expr("redemptions - 1")).Error
+}
@@ -0,0 +1,94 @@
+package billing
+
+import (
+	"os"
+	"strconv"
+
+	"diplom/models"
+	"diplom/payments"
+)
+
+// ReceiptsEnabled — передавать ли провайдеру данные для чеков (54-ФЗ). Включено по умолчанию;
+// RECEIPTS_ENABLED=0 — если чеки пробивает собственная касса магазина.
+func ReceiptsEnabled() bool {
+	return os.Getenv("RECEIPTS_ENABLED") != "0"
+}
+
+// receiptVATCode — код ставки НДС из RECEIPT_VAT_CODE, по умолчанию 1 (без НДС).
+func receiptVATCode() int {
+	if v, err := strconv.Atoi(os.Getenv("RECEIPT_VAT_CODE")); err == nil && v > 0 {
+		return v
+	}
+	return 1
+}
+
+// receiptTaxSystemCode — система налогообложения из RECEIPT_TAX_SYSTEM_CODE; 0 — не передаётся.
+func receiptTaxSystemCode() int {
+	v, _ := strconv.Atoi(os.Getenv("RECEIPT_TAX_SYSTEM_CODE"))
+	return v
+}
+
+// NewReceipt — чек на подписку (одна позиция-услуга) с контактом покупателя. nil, если чеки
+// отключены, платёж не в рублях или у покупателя нет email.
+func NewReceipt(email, description, amount, currency string) *payments.Receipt {
+	if !ReceiptsEnabled() || currency != "RUB" || email == "" {
+		return nil
+	}
+	return &payments.Receipt{
+		CustomerEmail: email,
+		TaxSystemCode: receiptTaxSystemCode(),
+		Items: []payments.ReceiptItem{{
+			Description:    truncateRunes(description, 128),
+			Quantity:       "1.00",
+			Amount:         payments.Amount{Value: amount, Currency: currency},
+			VATCode:        receiptVATCode(),
+			PaymentSubject: "service",
+			PaymentMode:    "full_payment",
+		}},
+	}
+}
+
+// RefundReceipt — чек возврата суммы amount по платежу. nil, если у платежа не было чека.
+func RefundReceipt(payment models.Payment, amount string) *payments.Receipt {
+	if len(payment.ReceiptItems) == 0 || payment.ReceiptEmail == "" {
+		return nil
+	}
+	item := payment.ReceiptItems[0]
+	return &payments.Receipt{
+		CustomerEmail: payment.ReceiptEmail,
+		TaxSystemCode: receiptTaxSystemCode(),
+		Items: []payments.ReceiptItem{{
+			Description:    item.Description,
+			Quantity:       "1.00",
+			Amount:         payments.Amount{Value: amount, Currency: payment.Currency},
+			VATCode:        item.VATCode,
+			PaymentSubject: "service",
+			PaymentMode:    "full_payment",
+		}},
+	}
+}
+
+// AttachReceipt сохраняет в платеже контакт покупателя и позиции переданного чека.
+func AttachReceipt(payment *models.Payment, r *payments.Receipt) {
+	if r == nil {
+		return
+	}
+	payment.ReceiptEmail = r.CustomerEmail
+	payment.ReceiptItems = nil
+	for _, item := range r.Items {
+		payment.ReceiptItems = append(payment.ReceiptItems, models.ReceiptItem{
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Amount:      item.Amount.Value,
+			VATCode:     item.VATCode,
+		})
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
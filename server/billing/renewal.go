@@ -0,0 +1,220 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/mail"
+	"diplom/models"
+	"diplom/payments"
+)
+
+const (
+	renewalChargeLead    = 24 * time.Hour     // списываем за сутки до окончания подписки
+	renewalReminderLead  = 3 * 24 * time.Hour // напоминаем за трое суток до окончания
+	renewalRetryInterval = 24 * time.Hour     // повтор после отказа
+	renewalMaxAttempts   = 4                  // после стольких отказов подряд автопродление отключается
+	renewalPendingDelay  = time.Hour          // платёж ещё обрабатывается — ждём уведомления
+)
+
+// ProcessRenewals напоминает о предстоящих списаниях и продлевает подписки
+// с автопродлением по сохранённому способу оплаты. Вызывается фоновой задачей.
+func ProcessRenewals(db *gorm.DB) {
+	now := time.Now()
+	sendRenewalReminders(db, now)
+
+	var due []models.FamilySubscription
+	if err := db.
+		Where("auto_renew = ? AND payment_method_id <> ''", true).
+		Where("end_date <= ?", now.Add(renewalChargeLead)).
+		Where("next_renewal_at IS NULL OR next_renewal_at <= ?", now).
+		Find(&due).Error; err != nil {
+		log.Printf("Автопродление: ошибка выборки подписок: %v\n", err)
+		return
+	}
+	for _, sub := range due {
+		chargeSubscription(db, sub)
+	}
+}
+
+// sendRenewalReminders — письмо плательщику перед каждым автосписанием (один раз на период).
+func sendRenewalReminders(db *gorm.DB, now time.Time) {
+	var subs []models.FamilySubscription
+	if err := db.
+		Where("auto_renew = ? AND payment_method_id <> ''", true).
+		Where("end_date > ? AND end_date <= ?", now, now.Add(renewalReminderLead)).
+		Where("reminder_sent_for IS NULL OR reminder_sent_for <> end_date").
+		Find(&subs).Error; err != nil {
+		log.Printf("Автопродление: ошибка выборки напоминаний: %v\n", err)
+		return
+	}
+
+	mailer := mail.NewMailService()
+	for _, sub := range subs {
+		plan, err := renewalPlan(db, sub)
+		if err != nil {
+			log.Printf("Автопродление: план подписки %d не найден: %v\n", sub.ID, err)
+			continue
+		}
+		if !claimRenewalReminder(db, sub) {
+			continue
+		}
+		if to := renewalPayerEmail(db, sub); to != "" {
+			if err := mailer.SendRenewalReminderMail(to, plan.Name, plan.Price, sub.EndDate.Add(-renewalChargeLead)); err != nil {
+				log.Printf("Автопродление: не удалось отправить напоминание для семьи %d: %v\n", sub.FamilyID, err)
+				// Письмо не ушло — снимаем отметку, чтобы следующий запуск попробовал снова
+				db.Model(&models.FamilySubscription{}).
+					Where("id = ? AND reminder_sent_for = ?", sub.ID, sub.EndDate).
+					Update("reminder_sent_for", sub.ReminderSentFor)
+			}
+		}
+	}
+}
+
+// claimRenewalReminder отмечает, что напоминание о списании перед EndDate отправлено, и возвращает
+// false, если его уже отправили — в том числе другой экземпляр сервера.
+func claimRenewalReminder(db *gorm.DB, sub models.FamilySubscription) bool {
+	res := db.Model(&models.FamilySubscription{}).
+		Where("id = ? AND end_date = ?", sub.ID, sub.EndDate).
+		Where("reminder_sent_for IS NULL OR reminder_sent_for <> end_date").
+		Update("reminder_sent_for", sub.EndDate)
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// chargeSubscription списывает оплату следующего периода по сохранённому способу.
+// Ключ идемпотентности зависит от периода и номера попытки: повторный запуск не спишет дважды.
+// Попытка засчитывается, только когда провайдер отклонил платёж (canceled).
+func chargeSubscription(db *gorm.DB, sub models.FamilySubscription) {
+	// Продлевается план последнего периода, даже если задача смены плана ещё не успела
+	if _, err := AdvancePlan(db, &sub, time.Now()); err != nil {
+		log.Printf("Автопродление: план подписки %d не обновлён: %v\n", sub.ID, err)
+	}
+	plan, err := renewalPlan(db, sub)
+	if err != nil {
+		log.Printf("Автопродление: план подписки %d не найден: %v\n", sub.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	description := fmt.Sprintf("Подписка «%s»", plan.Name)
+	receipt := NewReceipt(renewalPayerEmail(db, sub), description, plan.Price, plan.Currency)
+
+	provider := payments.Default()
+	p, err := provider.CreatePayment(ctx, payments.CreatePaymentRequest{
+		Amount:          payments.Amount{Value: plan.Price, Currency: plan.Currency},
+		Description:     fmt.Sprintf("Автопродление подписки «%s» для семьи #%d", plan.Name, sub.FamilyID),
+		Metadata:        map[string]string{"family_id": strconv.Itoa(int(sub.FamilyID)), "plan": plan.Code},
+		IdempotenceKey:  fmt.Sprintf("renewal-%d-%d-%d", sub.ID, sub.EndDate.Unix(), sub.RenewalAttempts),
+		PaymentMethodID: sub.PaymentMethodID,
+		Receipt:         receipt,
+	})
+	if err != nil {
+		// Сбой связи или ответа провайдера — не отказ банка: платёж мог быть создан.
+		// Попытку не засчитываем и повторяем с тем же ключом, чтобы провайдер вернул тот же платёж
+		log.Printf("Автопродление семьи %d: ошибка запроса к провайдеру: %v\n", sub.FamilyID, err)
+		db.Model(&sub).Update("next_renewal_at", time.Now().Add(renewalPendingDelay))
+		return
+	}
+
+	payment := models.Payment{
+		PaymentID: p.ID,
+		Provider:  provider.Name(),
+		FamilyID:  sub.FamilyID,
+		PlanID:    &plan.ID,
+		Amount:    plan.Price,
+		Currency:  plan.Currency,
+		Status:    payments.StatusPending, // итоговый статус применит обработка уведомления
+		Recurring: true,
+
+		ReceiptStatus: p.ReceiptStatus,
+	}
+	AttachReceipt(&payment, receipt)
+	if sub.AutoRenewUserID != nil {
+		payment.UserID = *sub.AutoRenewUserID
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&payment).Error; err != nil {
+		log.Printf("Автопродление семьи %d: ошибка сохранения платежа %s: %v\n", sub.FamilyID, p.ID, err)
+		return
+	}
+
+	switch p.Status {
+	case payments.StatusSucceeded, payments.StatusCanceled:
+		// Тот же путь, что у уведомления: продление или учёт отказа
+		notifier.PaymentFinished(ctx, provider, &payments.Notification{
+			Event:     "payment." + p.Status,
+			PaymentID: p.ID,
+			SourceIP:  "renewal",
+		})
+	default:
+		// Ждём уведомления от провайдера; до него повторно не списываем
+		db.Model(&sub).Update("next_renewal_at", time.Now().Add(renewalPendingDelay))
+	}
+}
+
+// RegisterRenewalFailure учитывает отказ автосписания: назначает повтор или, если попытки
+// закончились, отключает автопродление. Плательщику уходит письмо.
+func RegisterRenewalFailure(db *gorm.DB, familyID uint, amount, reason string) {
+	var sub models.FamilySubscription
+	if err := db.Where("family_id = ?", familyID).First(&sub).Error; err != nil {
+		log.Printf("Автопродление: подписка семьи %d не найдена: %v\n", familyID, err)
+		return
+	}
+
+	attempts := sub.RenewalAttempts + 1
+	updates := map[string]interface{}{
+		"renewal_attempts":   attempts,
+		"last_renewal_error": reason,
+	}
+	var next *time.Time
+	if attempts >= renewalMaxAttempts {
+		updates["auto_renew"] = false
+		updates["next_renewal_at"] = nil
+		updates["payment_method_id"] = ""
+	} else {
+		t := time.Now().Add(renewalRetryInterval)
+		next = &t
+		updates["next_renewal_at"] = t
+	}
+	if err := db.Model(&sub).Updates(updates).Error; err != nil {
+		log.Printf("Автопродление: ошибка сохранения попытки для семьи %d: %v\n", familyID, err)
+		return
+	}
+	log.Printf("Автопродление семьи %d не удалось (%s), попытка %d из %d\n", familyID, reason, attempts, renewalMaxAttempts)
+
+	if to := renewalPayerEmail(db, sub); to != "" {
+		go func() {
+			if err := mail.NewMailService().SendRenewalFailedMail(to, amount, next); err != nil {
+				log.Printf("Не удалось отправить письмо о неудачном продлении на %s: %v\n", to, err)
+			}
+		}()
+	}
+}
+
+// renewalPlan — план, по которому продлевается подписка. Старые подписки и подписки
+// на снятый с продажи план продлеваются по плану по умолчанию.
+func renewalPlan(db *gorm.DB, sub models.FamilySubscription) (models.Plan, error) {
+	var plan models.Plan
+	if sub.PlanID != nil {
+		if err := db.First(&plan, *sub.PlanID).Error; err != nil || plan.IsActive {
+			return plan, err
+		}
+	}
+	return plan, db.Where("code = ?", DefaultPlanCode).First(&plan).Error
+}
+
+// renewalPayerEmail — адрес того, чей способ оплаты сохранён, иначе владельца семьи.
+func renewalPayerEmail(db *gorm.DB, sub models.FamilySubscription) string {
+	var user models.User
+	if sub.AutoRenewUserID != nil && db.First(&user, *sub.AutoRenewUserID).Error == nil {
+		return user.Email
+	}
+	return familyOwnerEmail(db, sub.FamilyID)
+}
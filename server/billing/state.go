@@ -0,0 +1,37 @@
+package billing
+
+import (
+	"time"
+
+	"diplom/models"
+)
+
+// GracePeriod — сколько после EndDate подписка ещё действует: время на повторные
+// попытки автосписания и ручную оплату.
+const GracePeriod = 3 * 24 * time.Hour
+
+// Состояния подписки
+const (
+	StateActive  = "active"  // оплаченный период идёт
+	StateGrace   = "grace"   // период закончился, действует льготный срок
+	StateExpired = "expired" // подписки нет или она отключена
+)
+
+// State возвращает состояние подписки на момент now.
+func State(sub models.FamilySubscription, now time.Time) string {
+	switch {
+	case !sub.IsActive:
+		return StateExpired
+	case now.Before(sub.EndDate):
+		return StateActive
+	case now.Before(GraceUntil(sub)):
+		return StateGrace
+	default:
+		return StateExpired
+	}
+}
+
+// GraceUntil — момент окончания льготного срока.
+func GraceUntil(sub models.FamilySubscription) time.Time {
+	return sub.EndDate.Add(GracePeriod)
+}
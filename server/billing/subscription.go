@@ -0,0 +1,192 @@
+package billing
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/models"
+	"diplom/payments"
+)
+
+// ExtendSubscription добавляет семье оплаченный период по плану planID (nil — месяц, как у платежей
+// до появления планов). Если подписка ещё действует, новый период начинается с её EndDate,
+// иначе — с now. Вызывается внутри транзакции; строка подписки блокируется до её конца.
+func ExtendSubscription(tx *gorm.DB, familyID uint, planID, paymentID *uint, now time.Time) (models.FamilySubscription, models.SubscriptionPeriod, error) {
+	return extendByPlan(tx, familyID, planID, paymentID, SourcePayment, now)
+}
+
+// extendByPlan добавляет период длиной в срок плана.
+func extendByPlan(tx *gorm.DB, familyID uint, planID, paymentID *uint, source string, now time.Time) (models.FamilySubscription, models.SubscriptionPeriod, error) {
+	months, err := planMonths(tx, planID)
+	if err != nil {
+		return models.FamilySubscription{}, models.SubscriptionPeriod{}, err
+	}
+	return extendSubscription(tx, familyID, planID, paymentID, source, now, func(start time.Time) time.Time {
+		return start.AddDate(0, months, 0)
+	})
+}
+
+// Источники периодов подписки (models.SubscriptionPeriod.Source)
+const (
+	SourcePayment = "payment"
+	SourceTrial   = "trial"
+	SourceGift    = "gift"
+)
+
+// extendSubscription добавляет период, который заканчивается в endOf(start).
+func extendSubscription(tx *gorm.DB, familyID uint, planID, paymentID *uint, source string, now time.Time, endOf func(time.Time) time.Time) (models.FamilySubscription, models.SubscriptionPeriod, error) {
+	var sub models.FamilySubscription
+	var period models.SubscriptionPeriod
+
+	// Блокировка строки подписки не действует, пока подписки ещё нет: первые активации
+	// (два платежа, платёж и подарок, платёж и пробный период) создали бы каждая свою
+	// подписку. Поэтому, как и в StartTrial, сначала блокируется строка семьи
+	var family models.Family
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", familyID).Find(&family).Error; err != nil {
+		return sub, period, err
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("family_id = ?", familyID).First(&sub).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return sub, period, err
+	}
+
+	start := now
+	if err == nil && sub.IsActive && sub.EndDate.After(now) {
+		// Продление: оставшиеся оплаченные дни сохраняются. План подписки — план идущего
+		// периода; новый план вступит в силу с начала своего периода (AdvancePlan)
+		start = sub.EndDate
+	} else {
+		sub.StartDate = now
+		sub.PlanID = planID
+	}
+	end := endOf(start)
+
+	sub.FamilyID = familyID
+	sub.IsActive = true
+	sub.EndDate = end
+	if err := tx.Save(&sub).Error; err != nil {
+		return sub, period, err
+	}
+
+	period = models.SubscriptionPeriod{
+		FamilyID:       familyID,
+		SubscriptionID: sub.ID,
+		PaymentID:      paymentID,
+		Source:         source,
+		PlanID:         planID,
+		StartDate:      start,
+		EndDate:        end,
+	}
+	if err := tx.Create(&period).Error; err != nil {
+		return sub, period, err
+	}
+	return sub, period, nil
+}
+
+// AdvancePlan переводит подписку на план периода, который идёт в момент now, и возвращает true,
+// если план сменился. Нужен, когда начинается период, купленный заранее по другому плану.
+func AdvancePlan(tx *gorm.DB, sub *models.FamilySubscription, now time.Time) (bool, error) {
+	var period models.SubscriptionPeriod
+	err := tx.Where("family_id = ? AND start_date <= ? AND end_date > ?", sub.FamilyID, now, now).
+		Order("start_date DESC").
+		First(&period).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if samePlan(sub.PlanID, period.PlanID) {
+		return false, nil
+	}
+	if err := tx.Model(&models.FamilySubscription{}).Where("id = ?", sub.ID).Update("plan_id", period.PlanID).Error; err != nil {
+		return false, err
+	}
+	sub.PlanID = period.PlanID
+	return true, nil
+}
+
+func samePlan(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// RevokePaidTime убирает из подписки семьи часть времени, оплаченного платежом payment, —
+// долю refunded/total от его периода (суммы в копейках). Период платежа укорачивается с конца,
+// следующие за ним периоды сдвигаются назад. Если оплаченное время закончилось, подписка
+// деактивируется. Для оплаты подарка время забирается у семьи, активировавшей код; код, который
+// ещё не активирован, аннулируется, только если платёж возвращён или отменён полностью,
+// и в любом случае возвращается gorm.ErrRecordNotFound.
+// Вызывается внутри транзакции.
+func RevokePaidTime(tx *gorm.DB, payment models.Payment, refunded, total int64, now time.Time) (models.FamilySubscription, error) {
+	var sub models.FamilySubscription
+	if payment.GiftCodeID != nil {
+		full := refunded >= total || payment.Status == payments.StatusRefunded
+		familyID, err := revokeGift(tx, *payment.GiftCodeID, full)
+		if err != nil {
+			return sub, err
+		}
+		payment.FamilyID = familyID
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("family_id = ?", payment.FamilyID).First(&sub).Error; err != nil {
+		return sub, err
+	}
+
+	var period models.SubscriptionPeriod
+	err := tx.Where("payment_id = ?", payment.ID).First(&period).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return sub, err
+	}
+	// Длина оплаченного периода — по плану (а не по текущим датам периода, которые могли
+	// сократить прошлые частичные возвраты). Платёж до появления периодов — месяц от now.
+	months, perr := planMonths(tx, payment.PlanID)
+	if perr != nil {
+		return sub, perr
+	}
+	from := now
+	if err == nil {
+		from = period.StartDate
+	}
+	length := from.AddDate(0, months, 0).Sub(from)
+	if total <= 0 || refunded > total {
+		refunded, total = 1, 1
+	}
+	cut := time.Duration(float64(length) * float64(refunded) / float64(total))
+
+	if err == nil {
+		if err := tx.Model(&models.SubscriptionPeriod{}).
+			Where("family_id = ? AND id <> ? AND start_date >= ?", payment.FamilyID, period.ID, period.EndDate).
+			Updates(map[string]interface{}{
+				"start_date": gorm.Expr("start_date - make_interval(secs => ?)", cut.Seconds()),
+				"end_date":   gorm.Expr("end_date - make_interval(secs => ?)", cut.Seconds()),
+			}).Error; err != nil {
+			return sub, err
+		}
+		if err := tx.Model(&period).Update("end_date", period.EndDate.Add(-cut)).Error; err != nil {
+			return sub, err
+		}
+	}
+
+	sub.EndDate = sub.EndDate.Add(-cut)
+	if !sub.EndDate.After(now) {
+		sub.IsActive = false
+	}
+	return sub, tx.Save(&sub).Error
+}
+
+// planMonths — срок плана в месяцах; nil — месяц (платежи до появления планов).
+func planMonths(tx *gorm.DB, planID *uint) (int, error) {
+	if planID == nil {
+		return 1, nil
+	}
+	var plan models.Plan
+	if err := tx.First(&plan, *planID).Error; err != nil {
+		return 0, err
+	}
+	return plan.DurationMonths, nil
+}
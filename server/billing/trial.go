@@ -0,0 +1,50 @@
+package billing
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/models"
+)
+
+// ErrTrialUnavailable — пробный период уже был или у семьи уже есть подписка.
+var ErrTrialUnavailable = errors.New("пробный период доступен один раз и только семьям без подписки")
+
+// TrialDays — длина пробного периода в днях: TRIAL_DAYS, по умолчанию 14.
+func TrialDays() int {
+	if v, err := strconv.Atoi(os.Getenv("TRIAL_DAYS")); err == nil && v > 0 {
+		return v
+	}
+	return 14
+}
+
+// StartTrial включает семье бесплатный пробный период по плану по умолчанию. Пробный период
+// даётся один раз — семье, у которой ещё не было подписки. Вызывается внутри транзакции.
+func StartTrial(tx *gorm.DB, familyID uint, now time.Time) (models.FamilySubscription, models.SubscriptionPeriod, error) {
+	// Строка семьи блокируется, чтобы два параллельных запроса не выдали пробный период дважды
+	var family models.Family
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&family, familyID).Error; err != nil {
+		return models.FamilySubscription{}, models.SubscriptionPeriod{}, err
+	}
+	var existing int64
+	if err := tx.Unscoped().Model(&models.FamilySubscription{}).Where("family_id = ?", familyID).Count(&existing).Error; err != nil {
+		return models.FamilySubscription{}, models.SubscriptionPeriod{}, err
+	}
+	if existing > 0 {
+		return models.FamilySubscription{}, models.SubscriptionPeriod{}, ErrTrialUnavailable
+	}
+
+	var plan models.Plan
+	if err := tx.Where("code = ?", DefaultPlanCode).First(&plan).Error; err != nil {
+		return models.FamilySubscription{}, models.SubscriptionPeriod{}, err
+	}
+	days := TrialDays()
+	return extendSubscription(tx, familyID, &plan.ID, nil, SourceTrial, now, func(start time.Time) time.Time {
+		return start.AddDate(0, 0, days)
+	})
+}
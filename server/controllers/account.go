@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"diplom/config"
+	"diplom/mail"
+	"diplom/models"
+	"diplom/rbac"
+	"diplom/utils"
+)
+
+// accountDeletionTTL — срок действия ссылки подтверждения удаления из письма.
+const accountDeletionTTL = time.Hour
+
+// DeleteAccountInput — удаление аккаунта подтверждается паролем или токеном из письма
+// (для входящих через внешнего провайдера, у которых пароля нет).
+type DeleteAccountInput struct {
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// ExportMyData собирает данные пользователя в ZIP-архив из JSON-файлов.
+func ExportMyData(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var family *models.Family
+	var members []models.User
+	if user.FamilyID != 0 {
+		var f models.Family
+		if err := config.DB.First(&f, user.FamilyID).Error; err == nil {
+			family = &f
+			config.DB.Select("id, name").Where("family_id = ?", user.FamilyID).Find(&members)
+		}
+	}
+
+	var events []models.Event
+	var chatMessages []models.ChatMessage
+	var tickets []models.Ticket
+	var ticketMessages []models.TicketMessage
+	var payments []models.Payment
+	if err := config.DB.Where("created_by = ?", user.ID).Order("start_time ASC").Find(&events).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки событий"})
+	}
+	if err := config.DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&chatMessages).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки сообщений чата"})
+	}
+	if err := config.DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&tickets).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки тикетов"})
+	}
+	if err := config.DB.Where("sender_id = ?", user.ID).Order("created_at ASC").Find(&ticketMessages).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки сообщений поддержки"})
+	}
+	if err := config.DB.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&payments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки платежей"})
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"family.json", fiber.Map{"family": family, "members": members, "is_owner": family != nil && family.OwnerID == user.ID}},
+		{"events.json", events},
+		{"chat_messages.json", chatMessages},
+		{"support_tickets.json", fiber.Map{"tickets": tickets, "messages": ticketMessages}},
+		{"payments.json", payments},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка формирования архива"})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка формирования архива"})
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка формирования архива"})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="fp-export-%d-%s.zip"`, user.ID, time.Now().Format("20060102")))
+	return c.Send(buf.Bytes())
+}
+
+// RequestAccountDeletion отправляет на email пользователя ссылку подтверждения удаления аккаунта.
+// Так удаление подтверждают те, кто входит через внешнего провайдера и не знает пароля.
+func RequestAccountDeletion(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации токена"})
+	}
+	// Действует только последняя ссылка
+	config.DB.Where("user_id = ?", user.ID).Delete(&models.AccountDeletionToken{})
+	entry := models.AccountDeletionToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(accountDeletionTTL),
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения токена"})
+	}
+
+	confirmURL := os.Getenv("CLIENT_URL") + "/account/delete/confirm/" + token
+	if err := mail.NewMailService().SendAccountDeletionMail(user.Email, confirmURL); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отправки письма с подтверждением"})
+	}
+	return c.JSON(fiber.Map{"message": "Ссылка для подтверждения удаления отправлена на ваш email"})
+}
+
+// DeleteAccount удаляет аккаунт: обезличивает авторство в чате и поддержке,
+// передаёт или распускает семью, отзывает все refresh-токены.
+// Последнего пользователя с правом управления ролями удалить нельзя.
+func DeleteAccount(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input DeleteAccountInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	if input.Token != "" {
+		var entry models.AccountDeletionToken
+		err := config.DB.Where("user_id = ? AND token_hash = ?", user.ID, utils.HashToken(input.Token)).First(&entry).Error
+		if err != nil || time.Now().After(entry.ExpiresAt) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверная или просроченная ссылка подтверждения"})
+		}
+	} else if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный пароль"})
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		permissions, err := rbac.UserPermissions(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if user.FamilyID != 0 {
+			if err := leaveFamily(tx, user); err != nil {
+				return err
+			}
+		}
+
+		// Авторство сообщений и событий обезличиваем, сами данные остаются семье и поддержке
+		if err := tx.Model(&models.ChatMessage{}).Where("user_id = ?", user.ID).Update("user_id", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.TicketMessage{}).Where("sender_id = ?", user.ID).Update("sender_id", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Ticket{}).Where("user_id = ?", user.ID).Update("user_id", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Event{}).Where("created_by = ?", user.ID).Update("created_by", 0).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Token{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.ExternalIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.AccountDeletionToken{}).Error; err != nil {
+			return err
+		}
+		// Второй фактор, начатые входы, ссылки сброса пароля и неотправленные письма
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.LoginChallenge{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("LOWER(\"to\") = LOWER(?)", user.Email).Delete(&models.QueuedMail{}).Error; err != nil {
+			return err
+		}
+
+		// Персональные данные затираем, чтобы email можно было использовать повторно
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"name":                    "Удалённый пользователь",
+			"email":                   fmt.Sprintf("deleted-%d@deleted.local", user.ID),
+			"password":                "",
+			"family_id":               0,
+			"avatar_url":              nil,
+			"activation_link":         "",
+			"pending_email":           "",
+			"email_change_token":      "",
+			"email_change_expires_at": nil,
+			"two_factor_enabled":      false,
+			"totp_secret":             "",
+			"totp_last_step":          0,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		// Без пользователя с правом roles:manage роли больше некому выдавать
+		if rbac.Has(permissions, rbac.RolesManage) {
+			return rbac.EnsureRoleManagerExists(tx)
+		}
+		return nil
+	})
+	if errors.Is(err, rbac.ErrLastRoleManager) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Вы последний, кто управляет ролями: передайте это право другому пользователю перед удалением аккаунта"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка удаления аккаунта"})
+	}
+	disconnectUser(user.ID, "")
+	// Если семья не распущена — оставшиеся участники увидят уход в ленте (без персональных данных)
+	if user.FamilyID != 0 {
+		var family models.Family
+		if err := config.DB.First(&family, user.FamilyID).Error; err == nil {
+			recordActivity(family.ID, 0, ActivityMemberLeft, nil, "Участник удалил аккаунт")
+		}
+	}
+
+	clearRefreshCookie(c)
+
+	return c.JSON(fiber.Map{"message": "Аккаунт удалён"})
+}
+
+// leaveFamily выводит пользователя из семьи и отключает автопродление, если платил он.
+// Если он владелец — семья переходит к участнику, вступившему раньше всех,
+// а если участников нет — распускается.
+func leaveFamily(tx *gorm.DB, user models.User) error {
+	var family models.Family
+	if err := tx.First(&family, user.FamilyID).Error; err != nil {
+		return nil // семьи уже нет
+	}
+
+	if err := tx.Model(&user).Update("family_id", 0).Error; err != nil {
+		return err
+	}
+	// Ушедший больше не платит за семью: его сохранённая карта не должна списываться
+	if err := tx.Model(&models.FamilySubscription{}).
+		Where("family_id = ? AND auto_renew_user_id = ?", family.ID, user.ID).
+		Updates(map[string]interface{}{
+			"auto_renew":           false,
+			"auto_renew_user_id":   nil,
+			"payment_method_id":    "",
+			"payment_method_title": "",
+			"renewal_attempts":     0,
+			"next_renewal_at":      nil,
+		}).Error; err != nil {
+		return err
+	}
+	if family.OwnerID != user.ID {
+		return nil
+	}
+
+	var heir models.User
+	err := tx.Where("family_id = ? AND id <> ?", family.ID, user.ID).Order("id ASC").First(&heir).Error
+	if err == nil {
+		return tx.Model(&family).Update("owner_id", heir.ID).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	// Участников не осталось — распускаем семью вместе с её данными
+	if err := tx.Where("family_id = ?", family.ID).Delete(&models.Event{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("family_id = ?", family.ID).Delete(&models.Calendar{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("family_id = ?", family.ID).Delete(&models.ChatMessage{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("family_id = ?", family.ID).Delete(&models.FamilyInvitation{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("family_id = ?", family.ID).Delete(&models.FamilyJoinRequest{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("family_id = ?", family.ID).Delete(&models.FamilySubscription{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&family).Error
+}
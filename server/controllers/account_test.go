@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/rbac"
+	"diplom/utils"
+)
+
+func deleteAccountRequest(t *testing.T, user models.User, body string) int {
+	t.Helper()
+	app := fiber.New()
+	app.Delete("/api/me", middleware.JWTProtected(), DeleteAccount)
+	req := httptest.NewRequest(http.MethodDelete, "/api/me", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, user))
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+// Пользователь внешнего провайдера не знает пароля и подтверждает удаление ссылкой из письма.
+func TestDeleteAccountWithEmailedToken(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "sso@example.com", 0)
+	config.DB.Create(&models.ExternalIdentity{UserID: user.ID, Provider: "mock", Subject: "sub-1", Email: user.Email})
+
+	token := "deletion-token"
+	config.DB.Create(&models.AccountDeletionToken{
+		UserID: user.ID, TokenHash: utils.HashToken(token), ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	if status := deleteAccountRequest(t, user, `{"token":"wrong"}`); status != fiber.StatusUnauthorized {
+		t.Fatalf("чужой токен: статус %d, ожидался 401", status)
+	}
+	if status := deleteAccountRequest(t, user, `{"token":"`+token+`"}`); status != fiber.StatusOK {
+		t.Fatalf("токен из письма: статус %d", status)
+	}
+	if err := config.DB.First(&models.User{}, user.ID).Error; err == nil {
+		t.Error("аккаунт не удалён")
+	}
+	var tokens int64
+	config.DB.Model(&models.AccountDeletionToken{}).Where("user_id = ?", user.ID).Count(&tokens)
+	if tokens != 0 {
+		t.Error("токен удаления остался")
+	}
+}
+
+// Последний пользователь с правом roles:manage не может удалить аккаунт.
+func TestDeleteAccountKeepsLastRoleManager(t *testing.T) {
+	setupTestDB(t)
+	perm := models.Permission{Code: rbac.RolesManage}
+	config.DB.Create(&perm)
+	role := models.Role{Name: "admin", Permissions: []models.Permission{perm}}
+	config.DB.Create(&role)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	admin := createTestUser(t, "admin@example.com", 0)
+	config.DB.Model(&admin).Update("password", string(hash))
+	config.DB.Create(&models.UserRole{UserID: admin.ID, RoleID: role.ID})
+
+	if status := deleteAccountRequest(t, admin, `{"password":"secret"}`); status != fiber.StatusConflict {
+		t.Fatalf("последний управляющий ролями: статус %d, ожидался 409", status)
+	}
+	if err := config.DB.First(&models.User{}, admin.ID).Error; err != nil {
+		t.Fatal("аккаунт удалён вопреки проверке")
+	}
+
+	other := createTestUser(t, "admin2@example.com", 0)
+	config.DB.Create(&models.UserRole{UserID: other.ID, RoleID: role.ID})
+	if status := deleteAccountRequest(t, admin, `{"password":"secret"}`); status != fiber.StatusOK {
+		t.Errorf("при втором управляющем: статус %d", status)
+	}
+}
+
+// После удаления аккаунта не остаётся секрета 2FA, кодов восстановления, начатых входов,
+// ссылок сброса пароля и писем на его адрес.
+func TestDeleteAccountRemovesCredentials(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "user@example.com", 0)
+	password, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	config.DB.Model(&user).Updates(map[string]interface{}{
+		"password": string(password), "two_factor_enabled": true, "totp_secret": "JBSWY3DPEHPK3PXP", "totp_last_step": 42,
+	})
+	config.DB.Create(&models.RecoveryCode{UserID: user.ID, CodeHash: "code"})
+	config.DB.Create(&models.LoginChallenge{UserID: user.ID, TokenHash: "challenge", ExpiresAt: time.Now().Add(time.Minute)})
+	config.DB.Create(&models.PasswordResetToken{UserID: user.ID, TokenHash: "reset", ExpiresAt: time.Now().Add(time.Hour)})
+	config.DB.Create(&models.QueuedMail{To: "User@Example.com", Kind: "activation", Link: "link", NextAttemptAt: time.Now()})
+
+	if status := deleteAccountRequest(t, user, `{"password":"secret"}`); status != fiber.StatusOK {
+		t.Fatalf("статус %d", status)
+	}
+
+	var deleted models.User
+	config.DB.Unscoped().First(&deleted, user.ID)
+	if deleted.TOTPSecret != "" || deleted.TOTPLastStep != 0 || deleted.TwoFactorEnabled {
+		t.Error("секрет 2FA остался у удалённого пользователя")
+	}
+	for name, model := range map[string]interface{}{
+		"коды восстановления": &models.RecoveryCode{},
+		"челленджи входа":     &models.LoginChallenge{},
+		"ссылки сброса":       &models.PasswordResetToken{},
+	} {
+		var count int64
+		config.DB.Model(model).Where("user_id = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%s не удалены", name)
+		}
+	}
+	var mails int64
+	config.DB.Model(&models.QueuedMail{}).Count(&mails)
+	if mails != 0 {
+		t.Error("письма на адрес пользователя остались в очереди")
+	}
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/models"
+)
+
+// Типы записей ленты активности семьи
+const (
+	ActivityEventCreated          = "event_created"
+	ActivityEventCompleted        = "event_completed"
+	ActivityMemberJoined          = "member_joined"
+	ActivityMemberLeft            = "member_left"
+	ActivityCalendarCreated       = "calendar_created"
+	ActivitySubscriptionActivated = "subscription_activated"
+	ActivitySubscriptionRefunded  = "subscription_refunded"
+	ActivitySubscriptionGrace     = "subscription_grace"
+	ActivitySubscriptionExpired   = "subscription_expired"
+	ActivitySubscriptionTrial     = "subscription_trial"
+	ActivitySubscriptionGift      = "subscription_gift"
+	ActivitySubscriptionPlan      = "subscription_plan_changed"
+)
+
+// recordActivity сохраняет запись в ленту семьи и рассылает её по семейному WebSocket.
+// Ошибки только логируются: лента не должна ломать основное действие.
+func recordActivity(familyID, actorID uint, typ string, entityID *uint, summary string) {
+	if familyID == 0 {
+		return
+	}
+	activity := models.FamilyActivity{
+		FamilyID:  familyID,
+		ActorID:   actorID,
+		Type:      typ,
+		EntityID:  entityID,
+		Summary:   summary,
+		CreatedAt: time.Now(),
+	}
+	if err := config.DB.Create(&activity).Error; err != nil {
+		log.Printf("Ошибка записи активности семьи %d: %v\n", familyID, err)
+		return
+	}
+	broadcastActivity(familyID, activity)
+}
+
+// GetFamilyActivity — /family/activity?cursor=<id>&limit=<n>
+// Лента от новых к старым; next_cursor передаётся в следующий запрос, null — записей больше нет.
+func GetFamilyActivity(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	if user.FamilyID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Вы не состоите в семье"})
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	cursor := c.QueryInt("cursor", 0)
+
+	query := config.DB.Where("family_id = ?", user.FamilyID)
+	if cursor > 0 {
+		query = query.Where("id < ?", cursor)
+	}
+
+	// Берём на одну запись больше, чтобы понять, есть ли следующая страница
+	var items []models.FamilyActivity
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&items).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки ленты"})
+	}
+
+	var nextCursor *uint
+	if len(items) > limit {
+		items = items[:limit]
+		nextCursor = &items[limit-1].ID
+	}
+
+	return c.JSON(fiber.Map{
+		"items":       items,
+		"next_cursor": nextCursor,
+	})
+}
@@ -1,14 +1,17 @@
 package controllers
 
 import (
+	"errors"
+	"log"
 	"os"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 	"diplom/config"
+	"diplom/limiter"
 	"diplom/mail"
 	"diplom/models"
 	"diplom/utils"
@@ -28,32 +31,37 @@ func Register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
 	}
 
+	if res := checkLimits(func() (limiter.Result, error) { return registerIPLimiter.Hit(c.IP()) }); res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка при хэшировании пароля"})
 	}
 
 	activationLink := uuid.New().String()
+	activationExpiresAt := time.Now().Add(activationTTL)
 
 	user := models.User{
-		Name:           input.Name,
-		Email:          input.Email,
-		Password:       string(hashedPassword),
-		IsActivated:    false,
-		ActivationLink: activationLink,
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
+		Name:                input.Name,
+		Email:               input.Email,
+		Password:            string(hashedPassword),
+		IsActivated:         false,
+		ActivationLink:      activationLink,
+		ActivationExpiresAt: &activationExpiresAt,
+		CreatedAt:           time.Now(),
+		UpdatedAt:           time.Now(),
 	}
 
 	if err := config.DB.Create(&user).Error; err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Не удалось создать пользователя"})
 	}
 
-	// Отправляем письмо с активацией
-	mailService := mail.NewMailService()
-	activationURL := os.Getenv("CLIENT_URL") + "/auth/activate/" + activationLink
-	if err := mailService.SendActivationMail(user.Email, activationURL); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отправки письма с активацией"})
+	// Пользователь уже создан, поэтому сбой SMTP не должен превращаться в ошибку регистрации:
+	// письмо уйдёт из очереди, а в крайнем случае его можно запросить повторно
+	if err := mail.SendOrEnqueue(mail.KindActivation, user.Email, activationURL(activationLink)); err != nil {
+		log.Printf("Не удалось отправить письмо активации %s: %v\n", user.Email, err)
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Пользователь зарегистрирован. Проверьте вашу почту для активации аккаунта."})
@@ -72,58 +80,143 @@ func Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
 	}
 
+	ip := c.IP()
+	emailKey := normalizeEmailKey(input.Email)
+	res, lockedNow := reserveLoginAttempt(ip, emailKey)
+	if res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+
 	var user models.User
 	if err := config.DB.Where("email = ?", input.Email).First(&user).Error; err != nil {
-		if err == fiber.ErrNotFound || err == (config.DB.Error) {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Несуществующий email считаем так же, как неверный пароль, чтобы не выдавать наличие аккаунта:
+			// попытка уже засчитана
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверные учетные данные"})
 		}
+		releaseLoginAttempt(ip, emailKey)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сервера"})
 	}
 
-	// Если аккаунт не активирован
+	// Если аккаунт не активирован — клиент может предложить отправить письмо повторно
 	if !user.IsActivated {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Аккаунт не активирован. Проверьте вашу почту."})
+		releaseLoginAttempt(ip, emailKey)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error":         "Аккаунт не активирован. Проверьте вашу почту.",
+			"not_activated": true,
+		})
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		if lockedNow {
+			notifyAccountLocked(user.Email)
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверные учетные данные"})
 	}
+	loginSucceeded(ip, emailKey)
 
-	accessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.Role)
+	// С включённой (или обязательной) 2FA токены выдаются только после второго шага
+	if user.TwoFactorEnabled || twoFactorRequired(user) {
+		return startTwoFactorChallenge(c, user)
+	}
+
+	accessToken, err := issueLoginTokens(c, user)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации access токена"})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации токенов"})
 	}
 
-	refreshToken, err := utils.GenerateRefreshToken(user.ID)
+	return c.JSON(fiber.Map{
+		"access_token": accessToken,
+		"user":         user,
+	})
+}
+
+// issueLoginTokens начинает новую сессию — цепочку refresh-токенов: ставит refresh-cookie
+// и возвращает access-токен.
+func issueLoginTokens(c *fiber.Ctx, user models.User) (string, error) {
+	sessionID := uuid.New().String()
+	accessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.Role, sessionID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации refresh токена"})
+		return "", err
 	}
 
-	// Сохраняем refresh-токен в БД (опционально)
+	refreshToken, err := createRefreshToken(config.DB, c, user.ID, sessionID, time.Now())
+	if err != nil {
+		return "", err
+	}
+	setRefreshCookie(c, refreshToken)
+	return accessToken, nil
+}
+
+// refreshTokenTTL — срок жизни refresh-токена.
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// refreshReuseGrace — сколько после ротации старый токен ещё принимается от параллельных запросов.
+const refreshReuseGrace = 10 * time.Second
+
+// createRefreshToken выдаёт и сохраняет новый refresh-токен в цепочке sessionID
+// вместе с данными устройства из запроса.
+func createRefreshToken(db *gorm.DB, c *fiber.Ctx, userID uint, sessionID string, startedAt time.Time) (string, error) {
+	refreshToken, err := utils.GenerateRefreshToken(userID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	userAgent := c.Get(fiber.HeaderUserAgent)
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
 	tokenEntry := models.Token{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		UserID:     userID,
+		Token:      refreshToken,
+		SessionID:  sessionID,
+		UserAgent:  userAgent,
+		IP:         c.IP(),
+		StartedAt:  startedAt,
+		LastUsedAt: &now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := db.Create(&tokenEntry).Error; err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// revokeRefreshSession удаляет все токены цепочки, к которой относится token,
+// и закрывает WebSocket-соединения, открытые с access-токенами этой сессии.
+func revokeRefreshSession(token models.Token) error {
+	if token.SessionID == "" {
+		return config.DB.Unscoped().Delete(&token).Error
 	}
-	if err := config.DB.Create(&tokenEntry).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения refresh токена"})
+	if err := config.DB.Unscoped().Where("session_id = ?", token.SessionID).Delete(&models.Token{}).Error; err != nil {
+		return err
 	}
+	disconnectSession(token.SessionID)
+	return nil
+}
 
-	// Устанавливаем httpOnly cookie для refresh токена
+// setRefreshCookie устанавливает httpOnly cookie для refresh токена
+func setRefreshCookie(c *fiber.Ctx, refreshToken string) {
 	c.Cookie(&fiber.Cookie{
 		Name:     "refresh_token",
 		Value:    refreshToken,
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
+		Expires:  time.Now().Add(refreshTokenTTL),
 		HTTPOnly: true,
 		SameSite: "Lax",
 		Domain:   "localhost", // настройка для разработки; в production корректируйте
 	})
+}
 
-	return c.JSON(fiber.Map{
-		"access_token": accessToken,
-		"user":         user,
+func clearRefreshCookie(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
+		SameSite: "Lax",
+		Domain:   "localhost",
 	})
 }
 
@@ -138,13 +231,82 @@ func Activate(c *fiber.Ctx) error {
 	if user.IsActivated {
 		return c.JSON(fiber.Map{"message": "Ваш аккаунт уже активирован"})
 	}
+	if activationExpired(user) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{"error": "Срок действия ссылки активации истёк. Запросите новое письмо."})
+	}
 
 	user.IsActivated = true
 	user.ActivationLink = ""
+	user.ActivationExpiresAt = nil
 	config.DB.Save(&user)
 	return c.JSON(fiber.Map{"message": "Ваш аккаунт успешно активирован"})
 }
 
+// activationTTL — срок действия ссылки активации.
+const activationTTL = 24 * time.Hour
+
+func activationURL(link string) string {
+	return os.Getenv("CLIENT_URL") + "/auth/activate/" + link
+}
+
+// activationExpired проверяет срок ссылки; для записей без срока он отсчитывается от регистрации.
+func activationExpired(user models.User) bool {
+	expiresAt := user.CreatedAt.Add(activationTTL)
+	if user.ActivationExpiresAt != nil {
+		expiresAt = *user.ActivationExpiresAt
+	}
+	return time.Now().After(expiresAt)
+}
+
+// ResendActivationInput — email, на который нужно повторно отправить письмо активации.
+type ResendActivationInput struct {
+	Email string `json:"email"`
+}
+
+// ResendActivation выдаёт новую ссылку активации и отправляет письмо повторно.
+// Ответ одинаковый независимо от того, есть ли такой аккаунт; частота запросов ограничена по IP и email.
+func ResendActivation(c *fiber.Ctx) error {
+	var input ResendActivationInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	ip := c.IP()
+	emailKey := normalizeEmailKey(input.Email)
+	if res := checkLimits(
+		func() (limiter.Result, error) { return resendIPLimiter.Check(ip) },
+		func() (limiter.Result, error) { return resendEmailLimiter.Check(emailKey) },
+	); res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+	if _, err := resendIPLimiter.Fail(ip); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+	if _, err := resendEmailLimiter.Fail(emailKey); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+
+	response := fiber.Map{"message": "Если аккаунт с таким email ожидает активации, мы отправили на него новое письмо."}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", input.Email).First(&user).Error; err != nil || user.IsActivated {
+		return c.JSON(response)
+	}
+
+	// Новая ссылка заменяет старую, чтобы в ходу была только последняя
+	expiresAt := time.Now().Add(activationTTL)
+	user.ActivationLink = uuid.New().String()
+	user.ActivationExpiresAt = &expiresAt
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения ссылки активации"})
+	}
+
+	if err := mail.SendOrEnqueue(mail.KindActivation, user.Email, activationURL(user.ActivationLink)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отправки письма с активацией"})
+	}
+	return c.JSON(response)
+}
+
 // Refresh обрабатывает обновление access-токена, читая refresh-токен из httpOnly cookie.
 func Refresh(c *fiber.Ctx) error {
 	refreshToken := c.Cookies("refresh_token")
@@ -152,19 +314,11 @@ func Refresh(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Refresh токен не найден"})
 	}
 
-	refreshSecret := os.Getenv("JWT_REFRESH_SECRET")
-	token, err := jwt.Parse(refreshToken, func(t *jwt.Token) (interface{}, error) {
-		return []byte(refreshSecret), nil
-	})
-	if err != nil || !token.Valid {
+	claims, err := utils.ParseRefreshToken(refreshToken)
+	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный или просроченный refresh токен"})
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	userIDFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверные данные в токене"})
-	}
-	userID := uint(userIDFloat)
+	userID := claims.UserID
 
 	var storedToken models.Token
 	if err := config.DB.Where("user_id = ? AND token = ?", userID, refreshToken).First(&storedToken).Error; err != nil {
@@ -175,15 +329,62 @@ func Refresh(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Refresh токен истек"})
 	}
 
+	// Токен уже заменён новым. Сразу после ротации это параллельный запрос той же вкладки:
+	// выдаём только access-токен. Позже — повторное использование украденного токена.
+	rotate := true
+	if storedToken.RotatedAt != nil {
+		if time.Since(*storedToken.RotatedAt) > refreshReuseGrace {
+			revokeRefreshSession(storedToken)
+			clearRefreshCookie(c)
+			log.Printf("Повторное использование refresh токена: user_id=%d, session_id=%s — сессия отозвана\n", userID, storedToken.SessionID)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Refresh токен уже использован, войдите заново"})
+		}
+		rotate = false
+	}
+
 	var user models.User
 	if err := config.DB.First(&user, userID).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Пользователь не найден"})
 	}
-	newAccessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.Role)
+
+	sessionID := storedToken.SessionID
+	if sessionID == "" {
+		sessionID = uuid.New().String() // токены, выданные до появления цепочек
+	}
+	startedAt := storedToken.StartedAt
+	if startedAt.IsZero() {
+		startedAt = storedToken.CreatedAt
+	}
+
+	newAccessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.Role, sessionID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации нового access токена"})
 	}
 
+	if rotate {
+		var newRefreshToken string
+		err := config.DB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&models.Token{}).
+				Where("id = ? AND rotated_at IS NULL", storedToken.ID).
+				Update("rotated_at", time.Now())
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return nil // параллельный запрос успел обновить токен первым
+			}
+			var err error
+			newRefreshToken, err = createRefreshToken(tx, c, user.ID, sessionID, startedAt)
+			return err
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка обновления refresh токена"})
+		}
+		if newRefreshToken != "" {
+			setRefreshCookie(c, newRefreshToken)
+		}
+	}
+
 	return c.JSON(fiber.Map{
 		"access_token": newAccessToken,
 		"user":         user,
@@ -197,18 +398,140 @@ func Logout(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Refresh токен не найден"})
 	}
 
-	if err := config.DB.Where("token = ?", refreshToken).Delete(&models.Token{}).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка при выходе из системы"})
+	// Отзываем всю цепочку токенов этого входа, включая уже заменённые
+	var storedToken models.Token
+	if err := config.DB.Where("token = ?", refreshToken).First(&storedToken).Error; err == nil {
+		if err := revokeRefreshSession(storedToken); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка при выходе из системы"})
+		}
 	}
 
-	c.Cookie(&fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour),
-		HTTPOnly: true,
-		SameSite: "Lax",
-		Domain:   "localhost",
-	})
+	clearRefreshCookie(c)
 
 	return c.JSON(fiber.Map{"message": "Вы успешно вышли из системы"})
-}
\ No newline at end of file
+}
+
+// ForgotPasswordInput — запрос ссылки для сброса пароля.
+type ForgotPasswordInput struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordInput — установка нового пароля по токену из письма.
+type ResetPasswordInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword отправляет письмо со ссылкой для сброса пароля.
+// Ответ одинаковый независимо от того, существует ли email и ушло ли письмо, чтобы не раскрывать
+// список пользователей. Частота запросов ограничена по IP и по email.
+func ForgotPassword(c *fiber.Ctx) error {
+	var input ForgotPasswordInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	ip := c.IP()
+	emailKey := normalizeEmailKey(input.Email)
+	if res := checkLimits(
+		func() (limiter.Result, error) { return forgotIPLimiter.Check(ip) },
+		func() (limiter.Result, error) { return forgotEmailLimiter.Check(emailKey) },
+	); res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+	if _, err := forgotIPLimiter.Fail(ip); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+	if _, err := forgotEmailLimiter.Fail(emailKey); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+
+	response := fiber.Map{"message": "Если аккаунт с таким email существует, мы отправили на него ссылку для сброса пароля."}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", input.Email).First(&user).Error; err != nil {
+		return c.JSON(response)
+	}
+
+	resetToken, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации токена"})
+	}
+
+	// Предыдущие неиспользованные ссылки больше не действуют
+	config.DB.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{})
+
+	entry := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(resetToken),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения токена"})
+	}
+
+	mailService := mail.NewMailService()
+	resetURL := os.Getenv("CLIENT_URL") + "/auth/reset-password/" + resetToken
+	// В очередь писем ссылку сброса не ставим (она хранится там открытым текстом): при ошибке
+	// пользователь запросит её ещё раз, а ответ не должен отличаться от ответа для чужого email
+	if err := mailService.SendPasswordResetMail(user.Email, resetURL); err != nil {
+		log.Printf("Ошибка отправки письма для сброса пароля на %s: %v\n", user.Email, err)
+	}
+
+	return c.JSON(response)
+}
+
+// ResetPassword устанавливает новый пароль по одноразовому токену и отзывает все refresh-токены пользователя.
+func ResetPassword(c *fiber.Ctx) error {
+	var input ResetPasswordInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	if len(input.Password) < 6 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пароль должен содержать не менее 6 символов"})
+	}
+
+	var entry models.PasswordResetToken
+	if err := config.DB.Where("token_hash = ?", utils.HashToken(input.Token)).First(&entry).Error; err != nil || input.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверная ссылка для сброса пароля"})
+	}
+	if entry.UsedAt != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Ссылка для сброса пароля уже использована"})
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Срок действия ссылки истёк"})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка при хэшировании пароля"})
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// Помечаем токен использованным атомарно: повторный запрос с тем же токеном ничего не изменит
+		now := time.Now()
+		res := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", entry.ID).
+			Update("used_at", &now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Ссылка для сброса пароля уже использована")
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", entry.UserID).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", entry.UserID).Delete(&models.Token{}).Error
+	})
+	var ferr *fiber.Error
+	if errors.As(err, &ferr) {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сброса пароля"})
+	}
+	disconnectUser(entry.UserID, "")
+
+	return c.JSON(fiber.Map{"message": "Пароль изменён. Войдите с новым паролем."})
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/limiter"
+	"diplom/mail"
+	"diplom/models"
+)
+
+// Повторная отправка активации заменяет неотправленные письма со старой ссылкой:
+// очередь не дошлёт пользователю уже недействительную ссылку.
+func TestResendActivationSupersedesQueuedMail(t *testing.T) {
+	setupTestDB(t)
+	resendIPLimiter.SetStore(limiter.NewMemoryStore())
+	resendEmailLimiter.SetStore(limiter.NewMemoryStore())
+	t.Setenv("SMTP_HOST", "127.0.0.1") // порт 0 — отправка сразу не удаётся, письмо уходит в очередь
+
+	user := createTestUser(t, "new@example.com", 0)
+	config.DB.Model(&user).Updates(map[string]interface{}{"is_activated": false, "activation_link": "old-link"})
+	config.DB.Create(&models.QueuedMail{To: "New@Example.com", Kind: mail.KindActivation, Link: activationURL("old-link"), NextAttemptAt: time.Now()})
+
+	app := fiber.New()
+	app.Post("/api/auth/activation/resend", ResendActivation)
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/activation/resend", strings.NewReader(`{"email":"new@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("статус %d", resp.StatusCode)
+	}
+
+	var queued []models.QueuedMail
+	config.DB.Where("sent_at IS NULL").Find(&queued)
+	config.DB.First(&user, user.ID)
+	if len(queued) != 1 || queued[0].Link != activationURL(user.ActivationLink) {
+		t.Fatalf("в очереди %d писем, ожидалось одно с новой ссылкой", len(queued))
+	}
+}
@@ -14,9 +14,10 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
-	"github.com/golang-jwt/jwt/v5"
 
+	"diplom/billing"
 	"diplom/config"
+	"diplom/middleware"
 	"diplom/models"
 )
 
@@ -64,13 +65,7 @@ func saveBase64Image(dataURL string, userID uint) (*string, error) {
 /*────────────────────────── HTTP: история ───────────────────*/
 
 func ChatHistory(c *fiber.Ctx) error {
-	claims := c.Locals("user").(jwt.MapClaims)
-	userID := uint(claims["user_id"].(float64))
-
-	var u models.User
-	if err := config.DB.First(&u, userID).Error; err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not found"})
-	}
+	u := middleware.Auth(c).User
 
 	var msgs []models.ChatMessage
 	if err := config.DB.
@@ -82,30 +77,55 @@ func ChatHistory(c *fiber.Ctx) error {
 	return c.JSON(msgs)
 }
 
-/*────────────────────────── WebSocket ────────────────────────*/
+/*────────────────────────── HTTP: отправка ──────────────────*/
 
-func ChatWebSocket(c *websocket.Conn) {
-	/* 1. ─── валидация токена ───────────────────────────────*/
-	tokStr := c.Query("token")
-	if tokStr == "" {
-		c.Close(); return
+// SendChatMessageInput — текстовое сообщение в семейный чат (для скриптов и интеграций).
+type SendChatMessageInput struct {
+	Content string `json:"content"`
+	ReplyTo *uint  `json:"reply_to"`
+}
+
+// SendChatMessage отправляет сообщение в чат семьи без WebSocket; доступно и по персональному токену.
+func SendChatMessage(c *fiber.Ctx) error {
+	u := middleware.Auth(c).User
+	if u.FamilyID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Вы не состоите в семье"})
 	}
 
-	secret := os.Getenv("JWT_SECRET")
-	tok, err := jwt.Parse(tokStr, func(t *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-	if err != nil || !tok.Valid {
-		c.Close(); return
+	var input SendChatMessageInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	if strings.TrimSpace(input.Content) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пустое сообщение"})
+	}
+
+	msg := models.ChatMessage{
+		FamilyID:  u.FamilyID,
+		UserID:    u.ID,
+		Content:   input.Content,
+		ReplyToID: input.ReplyTo,
+		CreatedAt: time.Now(),
 	}
-	claims := tok.Claims.(jwt.MapClaims)
-	userID := uint(claims["user_id"].(float64))
+	if err := config.DB.Create(&msg).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "cannot save message"})
+	}
+	broadcastMessage(u.FamilyID, msg)
+	return c.Status(fiber.StatusCreated).JSON(msg)
+}
+
+/*────────────────────────── WebSocket ────────────────────────*/
 
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
+func ChatWebSocket(c *websocket.Conn) {
+	/* 1. ─── пользователь уже проверен в middleware.WSProtected ─*/
+	auth := middleware.SocketAuth(c)
+	if auth == nil {
 		c.Close(); return
 	}
-	familyID := user.FamilyID
+	userID := auth.UserID()
+	familyID := auth.User.FamilyID
+	trackSocket(c, userID, auth.SessionID())
+	defer untrackSocket(c)
 
 	/* 2. ─── регистрируем соединение ────────────────────────*/
 	roomsMu.Lock()
@@ -153,7 +173,15 @@ func ChatWebSocket(c *websocket.Conn) {
 
 		var mediaURL *string
 		if envelope.MediaB64 != nil {
-			if url, err := saveBase64Image(*envelope.MediaB64, userID); err == nil {
+			// Изображения в чате открывает подписка: без неё отправителю уходит ошибка, текст отправляется
+			if allowed, err := billing.HasFeature(config.DB, familyID, billing.FeatureChatMedia); err != nil || !allowed {
+				roomsMu.Lock()
+				c.WriteJSON(fiber.Map{"error": "Изображения в чате доступны по подписке"})
+				roomsMu.Unlock()
+				if envelope.Content == nil {
+					continue
+				}
+			} else if url, err := saveBase64Image(*envelope.MediaB64, userID); err == nil {
 				mediaURL = url
 			} else {
 				log.Println("save image:", err)
@@ -217,19 +245,69 @@ func broadcastDelete(fam uint, id uint) {
 	}
 }
 
-func broadcastPresence(fam uint) {
+func broadcastActivity(fam uint, activity models.FamilyActivity) {
+	roomsMu.Lock(); defer roomsMu.Unlock()
+
+	payload, _ := json.Marshal(struct {
+		Type string                `json:"type"`
+		Data models.FamilyActivity `json:"data"`
+	}{"activity", activity})
+
+	for conn := range rooms[fam] {
+		safeWrite(conn, websocket.TextMessage, payload)
+	}
+}
+
+// subscriptionState — состояние подписки семьи для клиентов чата
+type subscriptionState struct {
+	State      string    `json:"state"` // active, grace, expired (см. billing.State)
+	EndDate    time.Time `json:"end_date"`
+	GraceUntil time.Time `json:"grace_until"`
+	PlanID     *uint     `json:"plan_id"`
+	AutoRenew  bool      `json:"auto_renew"`
+}
+
+func broadcastSubscription(fam uint, state subscriptionState) {
 	roomsMu.Lock(); defer roomsMu.Unlock()
 
+	payload, _ := json.Marshal(struct {
+		Type string            `json:"type"`
+		Data subscriptionState `json:"data"`
+	}{"subscription", state})
+
+	for conn := range rooms[fam] {
+		safeWrite(conn, websocket.TextMessage, payload)
+	}
+}
+
+// presenceUser — кто в сети: имя и аватар для списка участников чата
+type presenceUser struct {
+	ID        uint    `json:"id"`
+	Name      string  `json:"name"`
+	AvatarURL *string `json:"avatar_url,omitempty"`
+}
+
+func broadcastPresence(fam uint) {
+	roomsMu.Lock()
 	online := make([]uint, 0, len(rooms[fam]))
 	for _, uid := range rooms[fam] {
 		online = append(online, uid)
 	}
+	roomsMu.Unlock()
+
+	// профиль грузим вне блокировки, чтобы не держать комнаты во время запроса к БД
+	users := make([]presenceUser, 0, len(online))
+	if len(online) > 0 {
+		config.DB.Model(&models.User{}).Select("id, name, avatar_url").Where("id IN ?", online).Scan(&users)
+	}
 
 	payload, _ := json.Marshal(struct {
-		Type string `json:"type"`
-		Data []uint `json:"data"`
-	}{"presence", online})
+		Type  string         `json:"type"`
+		Data  []uint         `json:"data"`
+		Users []presenceUser `json:"users"`
+	}{"presence", online, users})
 
+	roomsMu.Lock(); defer roomsMu.Unlock()
 	for conn := range rooms[fam] {
 		safeWrite(conn, websocket.TextMessage, payload)
 	}
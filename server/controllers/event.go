@@ -4,9 +4,10 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 
+	"diplom/billing"
 	"diplom/config"
+	"diplom/middleware"
 	"diplom/models"
 )
 
@@ -37,26 +38,49 @@ type MonthQuery struct {
 	Year  int `query:"year"`
 }
 
+/* ---------- Настройки семьи для запросов -------- */
+
+// calendarLocation — часовой пояс для границ периода: ?tz=..., иначе настройка семьи, иначе UTC.
+func calendarLocation(c *fiber.Ctx, familyID uint) *time.Location {
+	if tz := c.Query("tz"); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	var family models.Family
+	if err := config.DB.Select("time_zone").First(&family, familyID).Error; err == nil && family.TimeZone != "" {
+		if loc, err := time.LoadLocation(family.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// calendarWeekStart — первый день недели: ?week_start=..., иначе настройка семьи.
+func calendarWeekStart(c *fiber.Ctx, familyID uint) time.Weekday {
+	if ws := c.QueryInt("week_start", -1); ws >= 0 && ws <= 6 {
+		return time.Weekday(ws)
+	}
+	var family models.Family
+	if err := config.DB.Select("week_start").First(&family, familyID).Error; err == nil {
+		return time.Weekday(family.WeekStart)
+	}
+	return time.Monday
+}
+
 /* ---------- Handlers для Event ------------------ */
 
 // CreateEvent создает новое событие в семье
 func CreateEvent(c *fiber.Ctx) error {
 	// JWT
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userIDFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный user_id"})
-	}
-	userID := uint(userIDFloat)
+	userID := auth.UserID()
 
 	// Находим пользователя
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
 	}
@@ -104,17 +128,17 @@ func CreateEvent(c *fiber.Ctx) error {
 	if err := config.DB.Create(&event).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения события"})
 	}
+	recordActivity(user.FamilyID, userID, ActivityEventCreated, &event.ID, event.Title)
 
 	return c.JSON(fiber.Map{"event": event})
 }
 
 // UpdateEvent меняет существующее событие (например, период, цвет и т. д.)
 func UpdateEvent(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
 	eventID, err := c.ParamsInt("id")
 	if err != nil {
@@ -126,10 +150,7 @@ func UpdateEvent(c *fiber.Ctx) error {
 	}
 
 	// Проверка, что пользователь принадлежит к той же семье
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID != event.FamilyID {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Нет доступа к событию"})
 	}
@@ -168,16 +189,12 @@ func UpdateEvent(c *fiber.Ctx) error {
 
 // GetAllEvents возвращает все события семьи (если хочется «все» сразу)
 func GetAllEvents(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
 	}
@@ -195,16 +212,12 @@ func GetAllEvents(c *fiber.Ctx) error {
 
 // GetEventsForMonth — /events?month=X&year=Y
 func GetEventsForMonth(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
 	}
@@ -215,7 +228,7 @@ func GetEventsForMonth(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Нужны валидные month и year"})
 	}
 
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, calendarLocation(c, user.FamilyID))
 	endDate := startDate.AddDate(0, 1, 0) // +1 месяц
 
 	var events []models.Event
@@ -230,13 +243,53 @@ func GetEventsForMonth(c *fiber.Ctx) error {
 	return c.JSON(events)
 }
 
+// GetEventsForWeek — /events/week?date=YYYY-MM-DD — неделя, содержащая date,
+// с учётом часового пояса и первого дня недели семьи
+func GetEventsForWeek(c *fiber.Ctx) error {
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
+	}
+
+	user := auth.User
+	if user.FamilyID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
+	}
+
+	loc := calendarLocation(c, user.FamilyID)
+	day := time.Now().In(loc)
+	if dateStr := c.Query("date"); dateStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", dateStr, loc)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Некорректный формат date, нужен YYYY-MM-DD"})
+		}
+		day = parsed
+	}
+
+	weekStart := calendarWeekStart(c, user.FamilyID)
+	offset := (int(day.Weekday()) - int(weekStart) + 7) % 7
+	startDate := time.Date(day.Year(), day.Month(), day.Day()-offset, 0, 0, 0, 0, loc)
+	endDate := startDate.AddDate(0, 0, 7)
+
+	var events []models.Event
+	if err := config.DB.
+		Where("family_id = ? AND start_time >= ? AND start_time < ?",
+			user.FamilyID, startDate, endDate).
+		Order("start_time ASC").
+		Find(&events).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка при запросе"})
+	}
+
+	return c.JSON(events)
+}
+
 // CompleteEvent отмечает событие выполненным
 func CompleteEvent(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
+	userID := auth.UserID()
 
 	eventID, err := c.ParamsInt("id")
 	if err != nil {
@@ -248,18 +301,19 @@ func CompleteEvent(c *fiber.Ctx) error {
 	}
 
 	// Проверяем семью
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 || user.FamilyID != event.FamilyID {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Нет доступа к событию"})
 	}
 
+	wasCompleted := event.IsCompleted
 	event.IsCompleted = true
 	if err := config.DB.Save(&event).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка обновления события"})
 	}
+	if !wasCompleted {
+		recordActivity(event.FamilyID, userID, ActivityEventCompleted, &event.ID, event.Title)
+	}
 
 	return c.JSON(fiber.Map{"message": "Событие выполнено", "event": event})
 }
@@ -276,22 +330,26 @@ type CreateExtraCalendarInput struct {
 // CreateExtraCalendar создает новый календарь в семье
 // (требует JWT). При желании, можно проверить подписку.
 func CreateExtraCalendar(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
+	userID := auth.UserID()
 
 	// Ищем пользователя => familyID
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
 	}
 
-	// Если хотите проверять Premium — проверьте FamilySubscription
+	// Дополнительные календари открывает подписка
+	allowed, err := billing.HasFeature(config.DB, user.FamilyID, billing.FeatureExtraCalendars)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{"error": "Дополнительные календари доступны по подписке"})
+	}
 
 	var input CreateExtraCalendarInput
 	if err := c.BodyParser(&input); err != nil {
@@ -305,6 +363,7 @@ func CreateExtraCalendar(c *fiber.Ctx) error {
 	if err := config.DB.Create(&cal).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка создания календаря"})
 	}
+	recordActivity(user.FamilyID, userID, ActivityCalendarCreated, &cal.ID, cal.Title)
 
 	return c.JSON(fiber.Map{"calendar": cal})
 }
@@ -315,17 +374,13 @@ func CreateExtraCalendar(c *fiber.Ctx) error {
 	GetCalendarsList — возвращает все календари семьи
 */
 func GetCalendarsList(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
 	// Ищем пользователя
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
 	}
@@ -340,17 +395,13 @@ func GetCalendarsList(c *fiber.Ctx) error {
 }
 
 func GetEventsForCalendar(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
 	// Находим пользователя
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
 	}
@@ -373,7 +424,7 @@ func GetEventsForCalendar(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Нужны валидные month и year"})
 	}
 
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, calendarLocation(c, user.FamilyID))
 	endDate := startDate.AddDate(0, 1, 0)
 
 	var events []models.Event
@@ -1,16 +1,17 @@
 package controllers
 
 import (
-	"os"
+	"strings"
 	"time"
 
 	"diplom/config"
 	"diplom/mail"
+	"diplom/middleware"
 	"diplom/models"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"golang.org/x/text/language"
 )
 
 // CreateFamilyInput – структура для создания семьи.
@@ -21,20 +22,14 @@ type CreateFamilyInput struct {
 // CreateFamily создает новую семью, одновременно создавая запись в Calendar,
 // и обновляет данные пользователя, если он еще не состоит в семье.
 func CreateFamily(c *fiber.Ctx) error {
-	// Извлекаем данные пользователя из jwt.MapClaims
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
+	// Текущий пользователь из middleware.JWTProtected
+	auth := middleware.Auth(c)
+	if auth == nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Ошибка извлечения данных из токена",
 		})
 	}
-	userIDFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка извлечения user_id из токена",
-		})
-	}
-	userID := uint(userIDFloat)
+	userID := auth.UserID()
 
 	// Находим пользователя
 	var user models.User
@@ -108,20 +103,14 @@ func InviteMember(c *fiber.Ctx) error {
 		})
 	}
 
-	// Извлекаем данные отправителя из JWT-claims.
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
+	// Отправитель — текущий пользователь из middleware.JWTProtected.
+	auth := middleware.Auth(c)
+	if auth == nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Ошибка извлечения данных из токена",
 		})
 	}
-	senderIDFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка извлечения user_id из токена",
-		})
-	}
-	senderID := uint(senderIDFloat)
+	senderID := auth.UserID()
 
 	var inviter models.User
 	if err := config.DB.First(&inviter, senderID).Error; err != nil {
@@ -166,7 +155,7 @@ func InviteMember(c *fiber.Ctx) error {
 	}
 
 	// Формируем ссылку для приглашения.
-	inviteLink := os.Getenv("CLIENT_URL") + "/dashboard/family/invite/" + inviteToken
+	inviteLink := inviteURL(inviteToken)
 	mailService := mail.NewMailService()
 	if err := mailService.SendFamilyInviteMail(invitee.Email, inviteLink); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -180,6 +169,7 @@ func InviteMember(c *fiber.Ctx) error {
 }
 
 // AcceptInvitation принимает приглашение и обновляет FamilyID у приглашенного пользователя.
+// Для ссылки-приглашения вступает текущий пользователь из JWT.
 func AcceptInvitation(c *fiber.Ctx) error {
 	token := c.Params("token")
 	var invitation models.FamilyInvitation
@@ -188,6 +178,9 @@ func AcceptInvitation(c *fiber.Ctx) error {
 			"error": "Неверный токен приглашения",
 		})
 	}
+	if invitation.IsLink {
+		return acceptJoinLink(c, invitation)
+	}
 
 	// Находим пользователя по email из приглашения.
 	var user models.User
@@ -203,22 +196,19 @@ func AcceptInvitation(c *fiber.Ctx) error {
 
 	// Удаляем приглашение.
 	config.DB.Delete(&invitation)
+	recordActivity(user.FamilyID, user.ID, ActivityMemberJoined, &user.ID, user.Name)
 
 	return c.JSON(fiber.Map{"message": "Приглашение принято. Вы вступили в семью."})
 }
 
 func GetFamilyDetails(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
 	// Находим пользователя
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Вы не состоите в семье"})
 	}
@@ -238,5 +228,96 @@ func GetFamilyDetails(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"family":  family,
 		"members": members,
+		"settings": fiber.Map{
+			"time_zone":  family.TimeZone,
+			"locale":     family.Locale,
+			"week_start": family.WeekStart,
+			"avatar_url": family.AvatarURL,
+		},
 	})
 }
+
+// localeWeekStart — первый день недели, принятый в регионе локали (по данным CLDR).
+func localeWeekStart(tag language.Tag) time.Weekday {
+	region, _ := tag.Region()
+	switch region.String() {
+	case "AG", "AS", "BD", "BR", "BS", "BT", "BW", "BZ", "CA", "CN", "CO", "DM", "DO", "ET", "GT", "GU",
+		"HK", "HN", "ID", "IL", "IN", "JM", "JP", "KE", "KH", "KR", "LA", "MH", "MM", "MO", "MT", "MX",
+		"MZ", "NI", "NP", "PA", "PE", "PH", "PK", "PR", "PT", "PY", "SA", "SG", "SV", "TH", "TT", "TW",
+		"UM", "US", "VE", "VI", "WS", "YE", "ZA", "ZW":
+		return time.Sunday
+	case "AE", "AF", "BH", "DJ", "DZ", "EG", "IQ", "IR", "JO", "KW", "LY", "OM", "QA", "SD", "SY":
+		return time.Saturday
+	}
+	return time.Monday
+}
+
+// UpdateFamilyInput – изменяемые настройки семьи. Отсутствующие поля не меняются.
+type UpdateFamilyInput struct {
+	Name      *string `json:"name"`
+	Avatar    *string `json:"avatar"` // dataURL картинки, как в чате; пустая строка удаляет аватар
+	TimeZone  *string `json:"time_zone"`
+	Locale    *string `json:"locale"`
+	WeekStart *int    `json:"week_start"`
+}
+
+// UpdateFamily меняет название, аватар, часовой пояс, локаль и первый день недели семьи.
+// Доступно только владельцу семьи.
+func UpdateFamily(c *fiber.Ctx) error {
+	owner, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input UpdateFamilyInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Название семьи не может быть пустым"})
+		}
+		family.Name = name
+	}
+	if input.TimeZone != nil {
+		if _, err := time.LoadLocation(*input.TimeZone); err != nil || *input.TimeZone == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неизвестный часовой пояс"})
+		}
+		family.TimeZone = *input.TimeZone
+	}
+	if input.Locale != nil {
+		tag, err := language.Parse(*input.Locale)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Некорректная локаль"})
+		}
+		family.Locale = tag.String()
+		// Первый день недели — как принято в регионе локали; week_start из того же запроса важнее
+		family.WeekStart = int(localeWeekStart(tag))
+	}
+	if input.WeekStart != nil {
+		if *input.WeekStart < 0 || *input.WeekStart > 6 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "week_start должен быть от 0 (воскресенье) до 6 (суббота)"})
+		}
+		family.WeekStart = *input.WeekStart
+	}
+	if input.Avatar != nil {
+		if *input.Avatar == "" {
+			family.AvatarURL = nil
+		} else {
+			url, err := saveBase64Image(*input.Avatar, owner.ID)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Не удалось сохранить аватар"})
+			}
+			family.AvatarURL = url
+		}
+	}
+
+	family.UpdatedAt = time.Now()
+	if err := config.DB.Save(&family).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения настроек семьи"})
+	}
+
+	return c.JSON(fiber.Map{"family": family})
+}
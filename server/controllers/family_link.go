@@ -0,0 +1,382 @@
+package controllers
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"diplom/config"
+	"diplom/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+// CreateJoinLinkInput – параметры ссылки-приглашения.
+type CreateJoinLinkInput struct {
+	MaxUses          int  `json:"max_uses"`          // 0 — без ограничения
+	ExpiresInHours   int  `json:"expires_in_hours"`  // 0 — бессрочная
+	RequiresApproval bool `json:"requires_approval"` // вступление только после одобрения владельцем
+}
+
+// inviteURL формирует клиентскую ссылку, по которой открывается страница принятия приглашения.
+func inviteURL(token string) string {
+	return os.Getenv("CLIENT_URL") + "/dashboard/family/invite/" + token
+}
+
+// familyOwnerFromCtx возвращает текущего пользователя и его семью, если он её владелец.
+func familyOwnerFromCtx(c *fiber.Ctx) (models.User, models.Family, *fiber.Error) {
+	var family models.Family
+
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return user, family, ferr
+	}
+	if user.FamilyID == 0 {
+		return user, family, fiber.NewError(fiber.StatusBadRequest, "Вы не состоите в семье")
+	}
+	if err := config.DB.First(&family, user.FamilyID).Error; err != nil {
+		return user, family, fiber.NewError(fiber.StatusNotFound, "Семья не найдена")
+	}
+	if family.OwnerID != user.ID {
+		return user, family, fiber.NewError(fiber.StatusForbidden, "Доступно только владельцу семьи")
+	}
+	return user, family, nil
+}
+
+// claimJoinLinkUse атомарно учитывает одно использование ссылки.
+// Возвращает false, если лимит использований уже исчерпан.
+func claimJoinLinkUse(tx *gorm.DB, invitationID uint) (bool, error) {
+	res := tx.Model(&models.FamilyInvitation{}).
+		Where("id = ? AND (max_uses = 0 OR used_count < max_uses)", invitationID).
+		UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// errAlreadyInFamily — пользователь успел вступить в другую семью.
+var errAlreadyInFamily = errors.New("пользователь уже состоит в семье")
+
+// joinFamily добавляет пользователя в семью, только если он ещё ни в одной не состоит:
+// проверка и запись — одним UPDATE, чтобы параллельные вступления не перезаписали друг друга.
+func joinFamily(tx *gorm.DB, userID, familyID uint) error {
+	res := tx.Model(&models.User{}).Where("id = ? AND family_id = 0", userID).Update("family_id", familyID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errAlreadyInFamily
+	}
+	return nil
+}
+
+// CreateJoinLink создает ссылку-приглашение в семью (только для владельца).
+func CreateJoinLink(c *fiber.Ctx) error {
+	owner, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input CreateJoinLinkInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	if input.MaxUses < 0 || input.ExpiresInHours < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "max_uses и expires_in_hours не могут быть отрицательными"})
+	}
+
+	link := models.FamilyInvitation{
+		FamilyID:         family.ID,
+		Token:            uuid.New().String(),
+		IsLink:           true,
+		CreatedBy:        owner.ID,
+		MaxUses:          input.MaxUses,
+		RequiresApproval: input.RequiresApproval,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if input.ExpiresInHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(input.ExpiresInHours) * time.Hour)
+		link.ExpiresAt = &expiresAt
+	}
+	if err := config.DB.Create(&link).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка создания ссылки-приглашения"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"link": link,
+		"url":  inviteURL(link.Token),
+	})
+}
+
+// ListJoinLinks возвращает все ссылки-приглашения семьи.
+func ListJoinLinks(c *fiber.Ctx) error {
+	_, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var links []models.FamilyInvitation
+	if err := config.DB.
+		Where("family_id = ? AND is_link = ?", family.ID, true).
+		Order("created_at DESC").
+		Find(&links).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки ссылок"})
+	}
+
+	out := make([]fiber.Map, 0, len(links))
+	for _, l := range links {
+		out = append(out, fiber.Map{
+			"link": l,
+			"url":  inviteURL(l.Token),
+		})
+	}
+	return c.JSON(out)
+}
+
+// RevokeJoinLink удаляет ссылку-приглашение — по ней больше нельзя вступить.
+func RevokeJoinLink(c *fiber.Ctx) error {
+	_, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	linkID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID ссылки"})
+	}
+	var link models.FamilyInvitation
+	if err := config.DB.Where("id = ? AND family_id = ? AND is_link = ?", linkID, family.ID, true).First(&link).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Ссылка не найдена"})
+	}
+
+	if err := config.DB.Delete(&link).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка удаления ссылки"})
+	}
+	return c.JSON(fiber.Map{"message": "Ссылка отозвана"})
+}
+
+// JoinLinkQR отдаёт ссылку-приглашение в виде PNG с QR-кодом.
+func JoinLinkQR(c *fiber.Ctx) error {
+	_, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	linkID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID ссылки"})
+	}
+	var link models.FamilyInvitation
+	if err := config.DB.Where("id = ? AND family_id = ? AND is_link = ?", linkID, family.ID, true).First(&link).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Ссылка не найдена"})
+	}
+
+	size := c.QueryInt("size", 256)
+	if size < 128 || size > 1024 {
+		size = 256
+	}
+	png, err := qrcode.Encode(inviteURL(link.Token), qrcode.Medium, size)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации QR-кода"})
+	}
+
+	c.Type("png")
+	return c.Send(png)
+}
+
+// acceptJoinLink обрабатывает вступление по ссылке-приглашению для вошедшего пользователя.
+func acceptJoinLink(c *fiber.Ctx, link models.FamilyInvitation) error {
+	user, authErr := currentUserFromCtx(c)
+	if authErr != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Войдите в аккаунт, чтобы вступить в семью по ссылке"})
+	}
+	if user.FamilyID != 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Вы уже состоите в семье"})
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Срок действия ссылки истёк"})
+	}
+	if link.MaxUses > 0 && link.UsedCount >= link.MaxUses {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Лимит использований ссылки исчерпан"})
+	}
+
+	// Ссылка с одобрением: создаём заявку, владелец решит позже.
+	if link.RequiresApproval {
+		var existing models.FamilyJoinRequest
+		err := config.DB.
+			Where("family_id = ? AND user_id = ? AND status = ?", link.FamilyID, user.ID, "pending").
+			First(&existing).Error
+		if err == nil {
+			return c.JSON(fiber.Map{"message": "Заявка уже отправлена и ожидает одобрения владельца семьи"})
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+		}
+
+		request := models.FamilyJoinRequest{
+			FamilyID:     link.FamilyID,
+			InvitationID: link.ID,
+			UserID:       user.ID,
+			Status:       "pending",
+		}
+		if err := config.DB.Create(&request).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка создания заявки"})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Заявка отправлена. Дождитесь одобрения владельца семьи."})
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		claimed, err := claimJoinLinkUse(tx, link.ID)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return fiber.NewError(fiber.StatusBadRequest, "Лимит использований ссылки исчерпан")
+		}
+		return joinFamily(tx, user.ID, link.FamilyID)
+	})
+	if errors.Is(err, errAlreadyInFamily) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Вы уже состоите в семье"})
+	}
+	var ferr *fiber.Error
+	if errors.As(err, &ferr) {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка вступления в семью"})
+	}
+	recordActivity(link.FamilyID, user.ID, ActivityMemberJoined, &user.ID, user.Name)
+
+	return c.JSON(fiber.Map{"message": "Вы вступили в семью"})
+}
+
+// ListJoinRequests возвращает заявки на вступление, ожидающие решения владельца.
+func ListJoinRequests(c *fiber.Ctx) error {
+	_, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var requests []models.FamilyJoinRequest
+	if err := config.DB.
+		Where("family_id = ? AND status = ?", family.ID, "pending").
+		Order("created_at ASC").
+		Find(&requests).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки заявок"})
+	}
+
+	out := make([]fiber.Map, 0, len(requests))
+	for _, r := range requests {
+		var applicant models.User
+		err := config.DB.First(&applicant, r.UserID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Заявитель удалил аккаунт — решать по заявке больше нечего
+			config.DB.Model(&r).Update("status", "rejected")
+			continue
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки заявок"})
+		}
+		out = append(out, fiber.Map{
+			"id":         r.ID,
+			"user_id":    r.UserID,
+			"user_name":  applicant.Name,
+			"user_email": applicant.Email,
+			"created_at": r.CreatedAt,
+		})
+	}
+	return c.JSON(out)
+}
+
+// ApproveJoinRequest одобряет заявку: пользователь вступает в семью.
+func ApproveJoinRequest(c *fiber.Ctx) error {
+	_, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	requestID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID заявки"})
+	}
+	var request models.FamilyJoinRequest
+	if err := config.DB.Where("id = ? AND family_id = ? AND status = ?", requestID, family.ID, "pending").First(&request).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Заявка не найдена"})
+	}
+
+	var applicant models.User
+	if err := config.DB.First(&applicant, request.UserID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Пользователь не найден"})
+	}
+	if applicant.FamilyID != 0 {
+		config.DB.Model(&request).Update("status", "rejected")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь уже состоит в семье"})
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// Если ссылку ещё не отозвали — учитываем использование и её лимит.
+		var link models.FamilyInvitation
+		if err := tx.First(&link, request.InvitationID).Error; err == nil {
+			claimed, err := claimJoinLinkUse(tx, link.ID)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				return fiber.NewError(fiber.StatusBadRequest, "Лимит использований ссылки исчерпан")
+			}
+		}
+		if err := joinFamily(tx, applicant.ID, family.ID); err != nil {
+			return err
+		}
+		// Условие на статус — повторное одобрение той же заявки не пройдёт
+		res := tx.Model(&request).Where("status = ?", "pending").Update("status", "approved")
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fiber.NewError(fiber.StatusNotFound, "Заявка не найдена")
+		}
+		return nil
+	})
+	if errors.Is(err, errAlreadyInFamily) {
+		config.DB.Model(&request).Update("status", "rejected")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь уже состоит в семье"})
+	}
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка одобрения заявки"})
+	}
+	recordActivity(family.ID, applicant.ID, ActivityMemberJoined, &applicant.ID, applicant.Name)
+
+	return c.JSON(fiber.Map{"message": "Заявка одобрена"})
+}
+
+// RejectJoinRequest отклоняет заявку на вступление.
+func RejectJoinRequest(c *fiber.Ctx) error {
+	_, family, ferr := familyOwnerFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	requestID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID заявки"})
+	}
+	var request models.FamilyJoinRequest
+	if err := config.DB.Where("id = ? AND family_id = ? AND status = ?", requestID, family.ID, "pending").First(&request).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Заявка не найдена"})
+	}
+
+	if err := config.DB.Model(&request).Update("status", "rejected").Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отклонения заявки"})
+	}
+	return c.JSON(fiber.Map{"message": "Заявка отклонена"})
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+)
+
+func joinRequestsApp() *fiber.App {
+	app := fiber.New()
+	family := app.Group("/api/family", middleware.JWTProtected())
+	family.Get("/join-requests", ListJoinRequests)
+	family.Post("/join-requests/:id/approve", ApproveJoinRequest)
+	return app
+}
+
+func familyRequest(t *testing.T, app *fiber.App, method, path string, user models.User) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, user))
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// Заявка удалившего аккаунт пользователя не показывается владельцу и закрывается.
+func TestListJoinRequestsSkipsDeletedApplicants(t *testing.T) {
+	setupTestDB(t)
+	family, owner := createTestFamily(t, "owner@example.com")
+	gone := createTestUser(t, "gone@example.com", 0)
+	active := createTestUser(t, "active@example.com", 0)
+	stale := models.FamilyJoinRequest{FamilyID: family.ID, InvitationID: 1, UserID: gone.ID, Status: "pending"}
+	config.DB.Create(&stale)
+	config.DB.Create(&models.FamilyJoinRequest{FamilyID: family.ID, InvitationID: 1, UserID: active.ID, Status: "pending"})
+	config.DB.Delete(&gone)
+
+	resp := familyRequest(t, joinRequestsApp(), http.MethodGet, "/api/family/join-requests", owner)
+	var out []struct {
+		UserID uint `json:"user_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != fiber.StatusOK || len(out) != 1 || out[0].UserID != active.ID {
+		t.Fatalf("статус %d, заявки %+v", resp.StatusCode, out)
+	}
+	config.DB.First(&stale, stale.ID)
+	if stale.Status != "rejected" {
+		t.Errorf("заявка удалённого пользователя в статусе %q", stale.Status)
+	}
+}
+
+// Одобрение не переводит в семью того, кто уже состоит в другой, и не проходит дважды.
+func TestApproveJoinRequestKeepsExistingFamily(t *testing.T) {
+	setupTestDB(t)
+	family, owner := createTestFamily(t, "owner@example.com")
+	other, _ := createTestFamily(t, "other@example.com")
+	applicant := createTestUser(t, "applicant@example.com", 0)
+
+	if err := joinFamily(config.DB, applicant.ID, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := joinFamily(config.DB, applicant.ID, family.ID); !errors.Is(err, errAlreadyInFamily) {
+		t.Fatalf("повторное вступление: %v, ожидался errAlreadyInFamily", err)
+	}
+	config.DB.First(&applicant, applicant.ID)
+	if applicant.FamilyID != other.ID {
+		t.Fatalf("семья пользователя перезаписана: %d", applicant.FamilyID)
+	}
+
+	newcomer := createTestUser(t, "newcomer@example.com", 0)
+	request := models.FamilyJoinRequest{FamilyID: family.ID, InvitationID: 0, UserID: newcomer.ID, Status: "pending"}
+	config.DB.Create(&request)
+	app := joinRequestsApp()
+	path := "/api/family/join-requests/" + strconv.Itoa(int(request.ID)) + "/approve"
+	if resp := familyRequest(t, app, http.MethodPost, path, owner); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("одобрение: статус %d", resp.StatusCode)
+	}
+	if resp := familyRequest(t, app, http.MethodPost, path, owner); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("повторное одобрение: статус %d, ожидался 404", resp.StatusCode)
+	}
+	config.DB.First(&newcomer, newcomer.ID)
+	if newcomer.FamilyID != family.ID {
+		t.Errorf("после одобрения семья %d, ожидалась %d", newcomer.FamilyID, family.ID)
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/middleware"
+)
+
+// Семья без настроенного часового пояса считает границы периодов в UTC, как до появления настроек.
+func TestCalendarLocationDefaultsToUTC(t *testing.T) {
+	setupTestDB(t)
+	family, _ := createTestFamily(t, "owner@example.com")
+
+	app := fiber.New()
+	var got *time.Location
+	app.Get("/", func(c *fiber.Ctx) error {
+		got = calendarLocation(c, family.ID)
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1); err != nil {
+		t.Fatal(err)
+	}
+	if got != time.UTC {
+		t.Errorf("часовой пояс по умолчанию %v, ожидался UTC", got)
+	}
+}
+
+// Локаль задаёт первый день недели, если week_start не передан в том же запросе.
+func TestUpdateFamilyLocaleSetsWeekStart(t *testing.T) {
+	setupTestDB(t)
+	family, owner := createTestFamily(t, "owner@example.com")
+
+	app := fiber.New()
+	app.Put("/api/family/settings", middleware.JWTProtected(), UpdateFamily)
+	update := func(body string) {
+		req := httptest.NewRequest(http.MethodPut, "/api/family/settings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, owner))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("%s: статус %d", body, resp.StatusCode)
+		}
+	}
+
+	update(`{"locale":"en-US"}`)
+	config.DB.First(&family, family.ID)
+	if family.Locale != "en-US" || family.WeekStart != int(time.Sunday) {
+		t.Errorf("en-US: локаль %q, первый день %d", family.Locale, family.WeekStart)
+	}
+
+	update(`{"locale":"en-US","week_start":1}`)
+	config.DB.First(&family, family.ID)
+	if family.WeekStart != int(time.Monday) {
+		t.Errorf("week_start из запроса не применён: %d", family.WeekStart)
+	}
+	if family.TimeZone != "" {
+		t.Errorf("часовой пояс заполнен без запроса: %q", family.TimeZone)
+	}
+}
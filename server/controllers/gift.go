@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"diplom/billing"
+	"diplom/config"
+	"diplom/mail"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/payments"
+)
+
+// BuyGiftInput — план, который дарится.
+type BuyGiftInput struct {
+	PlanID uint `json:"plan_id"`
+}
+
+// RedeemGiftInput — подарочный код.
+type RedeemGiftInput struct {
+	Code string `json:"code"`
+}
+
+// BuyGift — оплата подарочной подписки. Семья покупателю не нужна: после оплаты он получает
+// код (на почту и в GET /subscription/gifts) и передаёт его семье, которой дарит подписку.
+func BuyGift(c *fiber.Ctx) error {
+	user := middleware.Auth(c).User
+
+	var input BuyGiftInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	var plan models.Plan
+	if err := config.DB.Where("id = ? AND is_active = ?", input.PlanID, true).First(&plan).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "План не найден"})
+	}
+
+	code, err := billing.NewGiftCode()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Не удалось создать подарочный код"})
+	}
+
+	receipt := billing.NewReceipt(user.Email, fmt.Sprintf("Подарочная подписка «%s»", plan.Name), plan.Price, plan.Currency)
+
+	provider := payments.Default()
+	payResp, err := provider.CreatePayment(c.Context(), payments.CreatePaymentRequest{
+		Amount:         payments.Amount{Value: plan.Price, Currency: plan.Currency},
+		Description:    fmt.Sprintf("Подарочная подписка «%s»", plan.Name),
+		ReturnURL:      paymentReturnURL(),
+		Metadata:       map[string]string{"user_id": strconv.Itoa(int(user.ID)), "plan": plan.Code, "gift": "1"},
+		IdempotenceKey: uuid.New().String(),
+		Receipt:        receipt,
+	})
+	if err != nil {
+		log.Printf("Ошибка создания платежа у провайдера %s: %v\n", provider.Name(), err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Ошибка при запросе к платёжному сервису"})
+	}
+	if payResp.ConfirmationURL == "" {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Не удалось получить ссылку на оплату"})
+	}
+
+	gift := models.GiftCode{Code: code, PlanID: plan.ID, BuyerID: user.ID, Status: billing.GiftPending}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&gift).Error; err != nil {
+			return err
+		}
+		payment := models.Payment{
+			PaymentID:     payResp.ID,
+			Provider:      provider.Name(),
+			FamilyID:      user.FamilyID,
+			UserID:        user.ID,
+			PlanID:        &plan.ID,
+			Amount:        plan.Price,
+			Currency:      plan.Currency,
+			Status:        payResp.Status,
+			GiftCodeID:    &gift.ID,
+			ReceiptStatus: payResp.ReceiptStatus,
+		}
+		billing.AttachReceipt(&payment, receipt)
+		return tx.Create(&payment).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения Payment"})
+	}
+
+	return c.JSON(fiber.Map{"payment_url": payResp.ConfirmationURL, "gift_id": gift.ID})
+}
+
+// ListGifts — подарки, купленные текущим пользователем. Код виден только у оплаченных.
+func ListGifts(c *fiber.Ctx) error {
+	user := middleware.Auth(c).User
+
+	var gifts []models.GiftCode
+	if err := config.DB.Preload("Plan").Where("buyer_id = ?", user.ID).Order("created_at DESC").Find(&gifts).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+	for i := range gifts {
+		if gifts[i].Status != billing.GiftPaid && gifts[i].Status != billing.GiftRedeemed {
+			gifts[i].Code = ""
+		}
+	}
+	return c.JSON(gifts)
+}
+
+// RedeemGift — активировать подарочный код для своей семьи.
+func RedeemGift(c *fiber.Ctx) error {
+	user := middleware.Auth(c).User
+	if user.FamilyID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
+	}
+	var input RedeemGiftInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	var gift models.GiftCode
+	var sub models.FamilySubscription
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		gift, sub, err = billing.RedeemGift(tx, input.Code, user.FamilyID, user.ID, time.Now())
+		return err
+	})
+	switch {
+	case errors.Is(err, billing.ErrGiftNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, billing.ErrGiftRedeemed):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+
+	notifySubscriptionChange(sub, user.ID, ActivitySubscriptionGift,
+		"Активирована подарочная подписка, подписка активна до "+sub.EndDate.Format("02.01.2006"))
+	return c.JSON(fiber.Map{"message": "Подарочная подписка активирована", "plan_id": gift.PlanID, "end_date": sub.EndDate})
+}
+
+// sendGiftCode отправляет покупателю код оплаченного подарка.
+func sendGiftCode(giftID uint) {
+	var gift models.GiftCode
+	if err := config.DB.Preload("Plan").First(&gift, giftID).Error; err != nil || gift.Plan == nil {
+		log.Printf("Подарок %d не найден: %v\n", giftID, err)
+		return
+	}
+	var buyer models.User
+	if err := config.DB.First(&buyer, gift.BuyerID).Error; err != nil {
+		return
+	}
+	go func() {
+		if err := mail.NewMailService().SendGiftCodeMail(buyer.Email, gift.Code, gift.Plan.Name); err != nil {
+			log.Printf("Не удалось отправить подарочный код на %s: %v\n", buyer.Email, err)
+		}
+	}()
+}
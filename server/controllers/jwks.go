@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/utils"
+)
+
+// GetJWKS публикует открытые ключи подписи access-токенов (RFC 7517).
+// Во время ротации здесь есть и новый, и старый ключ, пока токены старого не истекут.
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(fiber.Map{"keys": utils.PublicJWKS()})
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"diplom/config"
+	"diplom/models"
+	"diplom/utils"
+)
+
+func TestMain(m *testing.M) {
+	// Временный ключ подписи access-токенов (JWT_KEYS_DIR в тестах не задаётся)
+	os.Unsetenv("JWT_KEYS_DIR")
+	os.Setenv("APP_ENV", "development")
+	if err := utils.LoadSigningKeys(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// setupTestDB подключает config.DB к отдельной схеме тестовой базы PostgreSQL
+// (TEST_DATABASE_URL) и создаёт в ней таблицы. Схема удаляется после теста.
+// Без TEST_DATABASE_URL тест пропускается.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задан: тест работает с PostgreSQL")
+	}
+	gormConfig := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+
+	admin, err := gorm.Open(postgres.Open(dsn), gormConfig)
+	if err != nil {
+		t.Fatalf("подключение к тестовой базе: %v", err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("создание схемы: %v", err)
+	}
+
+	db, err := gorm.Open(postgres.Open(withSearchPath(dsn, schema)), gormConfig)
+	if err != nil {
+		t.Fatalf("подключение к схеме %s: %v", schema, err)
+	}
+	if err := db.AutoMigrate(models.All()...); err != nil {
+		t.Fatalf("миграция: %v", err)
+	}
+
+	prev := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = prev
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// withSearchPath добавляет search_path в DSN вида URL или key=value.
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// createTestUser создаёт активированного пользователя с паролем password.
+func createTestUser(t *testing.T, email string, familyID uint) models.User {
+	t.Helper()
+	user := models.User{Name: strings.Split(email, "@")[0], Email: email, Password: "-", IsActivated: true, FamilyID: familyID}
+	if err := config.DB.Create(&user).Error; err != nil {
+		t.Fatalf("создание пользователя: %v", err)
+	}
+	return user
+}
+
+// createTestFamily создаёт семью с владельцем-участником и возвращает обоих.
+func createTestFamily(t *testing.T, email string) (models.Family, models.User) {
+	t.Helper()
+	owner := createTestUser(t, email, 0)
+	family := models.Family{Name: "Тестовая семья", OwnerID: owner.ID}
+	if err := config.DB.Create(&family).Error; err != nil {
+		t.Fatalf("создание семьи: %v", err)
+	}
+	if err := config.DB.Model(&owner).Update("family_id", family.ID).Error; err != nil {
+		t.Fatalf("добавление в семью: %v", err)
+	}
+	owner.FamilyID = family.ID
+	return family, owner
+}
+
+// accessTokenFor выдаёт access-токен пользователя для заголовка Authorization.
+// Токен без сессии: JWTProtected не ищет для него refresh-токен в базе.
+func accessTokenFor(t *testing.T, user models.User) string {
+	t.Helper()
+	token, err := utils.GenerateAccessToken(user.ID, user.Email, user.Role, "")
+	if err != nil {
+		t.Fatalf("access-токен: %v", err)
+	}
+	return token
+}
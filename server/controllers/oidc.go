@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+
+	"diplom/config"
+	"diplom/models"
+	"diplom/sso"
+	"diplom/utils"
+)
+
+// oidcStateTTL — сколько ждём возврата пользователя от провайдера.
+const oidcStateTTL = 10 * time.Minute
+
+// oidcBrowserCookie связывает начатый вход с браузером: без неё чужая ссылка на callback
+// (login CSRF) не войдёт в аккаунт атакующего.
+const oidcBrowserCookie = "oidc_browser"
+
+var errEmailNotVerified = errors.New("email не подтверждён провайдером")
+
+// GetOIDCProviders возвращает список провайдеров для кнопок «Войти через…».
+func GetOIDCProviders(c *fiber.Ctx) error {
+	list := []fiber.Map{}
+	for _, name := range sso.Names() {
+		list = append(list, fiber.Map{"name": name, "display_name": sso.DisplayName(name)})
+	}
+	return c.JSON(fiber.Map{"providers": list})
+}
+
+// OIDCLogin начинает вход через провайдера: сохраняет state, nonce и PKCE verifier
+// и перенаправляет пользователя на страницу провайдера.
+func OIDCLogin(c *fiber.Ctx) error {
+	provider, err := sso.Get(c.Params("provider"))
+	if errors.Is(err, sso.ErrUnknownProvider) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Провайдер не найден"})
+	}
+	if err != nil {
+		log.Printf("OIDC %s: %v\n", c.Params("provider"), err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Провайдер временно недоступен"})
+	}
+
+	state, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации токена"})
+	}
+	nonce, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации токена"})
+	}
+	browser, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации токена"})
+	}
+	entry := models.OIDCLoginState{
+		StateHash:    utils.HashToken(state),
+		Provider:     provider.Name,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		BrowserHash:  utils.HashToken(browser),
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения состояния входа"})
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcBrowserCookie,
+		Value:    browser,
+		Path:     "/api/auth/oidc",
+		Expires:  entry.ExpiresAt,
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+
+	return c.Redirect(provider.AuthCodeURL(state, entry.CodeVerifier, nonce), fiber.StatusFound)
+}
+
+// OIDCCallback завершает вход: обменивает код, проверяет ID-токен и выдаёт те же токены, что и Login.
+// Ответ — редирект на клиент: refresh-токен уже в cookie, access-токен клиент получает через /auth/refresh.
+// Если нужна 2FA, челлендж приходит в HttpOnly cookie, и клиент продолжает через /auth/2fa/verify.
+func OIDCCallback(c *fiber.Ctx) error {
+	if providerErr := c.Query("error"); providerErr != "" {
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {providerErr}})
+	}
+
+	provider, err := sso.Get(c.Params("provider"))
+	if err != nil {
+		log.Printf("OIDC %s: %v\n", c.Params("provider"), err)
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"provider_unavailable"}})
+	}
+
+	// State одноразовый: удаляем его сразу, чтобы повторный callback не прошёл
+	var entry models.OIDCLoginState
+	stateHash := utils.HashToken(c.Query("state"))
+	if err := config.DB.Where("state_hash = ? AND provider = ?", stateHash, provider.Name).First(&entry).Error; err != nil {
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"invalid_state"}})
+	}
+	res := config.DB.Where("id = ?", entry.ID).Delete(&models.OIDCLoginState{})
+	if res.Error != nil || res.RowsAffected == 0 || time.Now().After(entry.ExpiresAt) {
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"invalid_state"}})
+	}
+	// Вход завершает только тот браузер, который его начал
+	browser := c.Cookies(oidcBrowserCookie)
+	clearOIDCBrowserCookie(c)
+	if browser == "" || subtle.ConstantTimeCompare([]byte(utils.HashToken(browser)), []byte(entry.BrowserHash)) != 1 {
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"invalid_state"}})
+	}
+
+	claims, err := provider.Exchange(context.Background(), c.Query("code"), entry.CodeVerifier, entry.Nonce)
+	if err != nil {
+		log.Printf("OIDC %s: обмен кода: %v\n", provider.Name, err)
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"exchange_failed"}})
+	}
+
+	user, err := resolveExternalUser(provider.Name, claims)
+	if errors.Is(err, errEmailNotVerified) {
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"email_not_verified"}})
+	}
+	if err != nil {
+		log.Printf("OIDC %s: привязка аккаунта: %v\n", provider.Name, err)
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"server_error"}})
+	}
+
+	if user.TwoFactorEnabled || twoFactorRequired(user) {
+		if res := checkTwoFactorLimits(c.IP(), user.ID); res.RetryAfter > 0 {
+			return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"too_many_attempts"}})
+		}
+		token, challenge, err := createLoginChallenge(user)
+		if err != nil {
+			return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"server_error"}})
+		}
+		// Челлендж — в cookie, а не в URL: из адреса он попал бы в историю браузера и Referer
+		setLoginChallengeCookie(c, token, challenge.ExpiresAt)
+		var params url.Values
+		if !user.TwoFactorEnabled {
+			params = url.Values{"setup_required": {"true"}}
+		}
+		return oidcRedirect(c, "/auth/2fa", params)
+	}
+
+	if _, err := issueLoginTokens(c, user); err != nil {
+		return oidcRedirect(c, "/auth/oidc/error", url.Values{"error": {"server_error"}})
+	}
+	return oidcRedirect(c, "/auth/oidc/complete", nil)
+}
+
+// resolveExternalUser находит пользователя по привязке к провайдеру, а при первом входе —
+// по подтверждённому провайдером email (или создаёт нового). Такие аккаунты сразу активируются.
+func resolveExternalUser(provider string, claims sso.Claims) (models.User, error) {
+	var user models.User
+	takenOver := false
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var identity models.ExternalIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+		if err == nil {
+			if err := tx.First(&user, identity.UserID).Error; err == nil {
+				return tx.Model(&identity).Update("last_login_at", now).Error
+			}
+			// Аккаунт удалён — привязка устарела
+			if err := tx.Delete(&identity).Error; err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		email := strings.TrimSpace(claims.Email)
+		if email == "" || !claims.EmailVerified {
+			return errEmailNotVerified
+		}
+
+		err = tx.Where("LOWER(email) = LOWER(?)", email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// Пароля у такого аккаунта нет: вход только через провайдера или после сброса пароля
+			randomPassword, err := bcrypt.GenerateFromPassword([]byte(uuid.New().String()), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			name := strings.TrimSpace(claims.Name)
+			if name == "" {
+				name = strings.Split(email, "@")[0]
+			}
+			user = models.User{
+				Name:        name,
+				Email:       email,
+				Password:    string(randomPassword),
+				IsActivated: true,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !user.IsActivated:
+			// Провайдер подтвердил владение адресом — письмо активации больше не нужно.
+			// Неактивированный аккаунт мог зарегистрировать кто угодно на чужой email: пароль,
+			// ожидающая смена адреса, ссылки сброса и сессии остались бы у него, поэтому сбрасываем их
+			if err := takeOverUnactivatedUser(tx, &user); err != nil {
+				return err
+			}
+			takenOver = true
+		}
+
+		return tx.Create(&models.ExternalIdentity{
+			UserID:      user.ID,
+			Provider:    provider,
+			Subject:     claims.Subject,
+			Email:       email,
+			LastLoginAt: &now,
+			CreatedAt:   now,
+		}).Error
+	})
+	if err == nil && takenOver {
+		disconnectUser(user.ID, "")
+	}
+	return user, err
+}
+
+// takeOverUnactivatedUser активирует аккаунт, владение адресом которого подтвердил провайдер,
+// и отбирает у него всё, что мог оставить зарегистрировавший его: пароль (заменяется случайным),
+// ожидающую смену email, ссылки сброса пароля и refresh-токены. Вызывается внутри транзакции.
+func takeOverUnactivatedUser(tx *gorm.DB, user *models.User) error {
+	randomPassword, err := bcrypt.GenerateFromPassword([]byte(uuid.New().String()), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.Password = string(randomPassword)
+	user.IsActivated = true
+	user.ActivationLink = ""
+	user.ActivationExpiresAt = nil
+	user.PendingEmail = ""
+	user.EmailChangeToken = ""
+	user.EmailChangeExpiresAt = nil
+	if err := tx.Save(user).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Token{}).Error
+}
+
+func clearOIDCBrowserCookie(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcBrowserCookie,
+		Value:    "",
+		Path:     "/api/auth/oidc",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+func oidcRedirect(c *fiber.Ctx, path string, params url.Values) error {
+	target := os.Getenv("CLIENT_URL") + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	return c.Redirect(target, fiber.StatusFound)
+}
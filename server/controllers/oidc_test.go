@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"diplom/config"
+	"diplom/models"
+)
+
+// mockOIDC — минимальный OIDC-провайдер: discovery, JWKS и token endpoint,
+// который на любой код выдаёт ID-токен с заданными claims.
+type mockOIDC struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims // sub, email, ...; iss, aud, exp и nonce дописываются при выдаче
+	nonce  string
+}
+
+func newMockOIDC(t *testing.T, clientID string) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := jwt.MapClaims{
+			"iss":   m.server.URL,
+			"aud":   clientID,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": m.nonce,
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "mock-access", "token_type": "Bearer", "expires_in": 3600, "id_token": idToken,
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// sharedOIDC — один провайдер на все тесты пакета: sso кэширует discovery по имени провайдера,
+// поэтому адрес issuer не должен меняться между тестами.
+var sharedOIDC *mockOIDC
+
+func setupMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	const clientID = "family-planner"
+	if sharedOIDC == nil {
+		sharedOIDC = newMockOIDC(t, clientID)
+	}
+	mock := sharedOIDC
+	mock.claims, mock.nonce = nil, ""
+	t.Setenv("OIDC_PROVIDERS", "mock")
+	t.Setenv("OIDC_MOCK_ISSUER", mock.server.URL)
+	t.Setenv("OIDC_MOCK_CLIENT_ID", clientID)
+	t.Setenv("OIDC_MOCK_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_REDIRECT_BASE_URL", "http://localhost:8080")
+	t.Setenv("CLIENT_URL", "http://client.test")
+	return mock
+}
+
+func oidcApp() *fiber.App {
+	app := fiber.New()
+	app.Get("/api/auth/oidc/:provider", OIDCLogin)
+	app.Get("/api/auth/oidc/:provider/callback", OIDCCallback)
+	return app
+}
+
+// startOIDCLogin начинает вход: возвращает адрес callback с state и cookie браузера.
+func startOIDCLogin(t *testing.T, app *fiber.App, mock *mockOIDC) (string, *http.Cookie) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("OIDCLogin: статус %d", resp.StatusCode)
+	}
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), mock.server.URL+"/authorize") {
+		t.Fatalf("OIDCLogin: неожиданный редирект %q", resp.Header.Get("Location"))
+	}
+	mock.nonce = authURL.Query().Get("nonce")
+
+	var browser *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcBrowserCookie {
+			browser = cookie
+		}
+	}
+	if browser == nil || !browser.HttpOnly {
+		t.Fatal("OIDCLogin: нет HttpOnly cookie браузера")
+	}
+	return "/api/auth/oidc/mock/callback?code=test-code&state=" + url.QueryEscape(authURL.Query().Get("state")), browser
+}
+
+// Login CSRF: атакующий начинает вход своим аккаунтом и отдаёт ссылку на callback жертве.
+// Без cookie браузера, начавшего вход, callback не выдаёт токены.
+func TestOIDCCallbackRequiresBrowserThatStartedLogin(t *testing.T) {
+	setupTestDB(t)
+	mock := setupMockOIDC(t)
+	mock.claims = jwt.MapClaims{"sub": "attacker-sub", "email": "attacker@example.com", "email_verified": true}
+
+	app := oidcApp()
+	callback, _ := startOIDCLogin(t, app, mock)
+
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(&http.Cookie{Name: oidcBrowserCookie, Value: "victim-browser"})
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := resp.Header.Get("Location"); loc != "http://client.test/auth/oidc/error?error=invalid_state" {
+		t.Fatalf("OIDCCallback: редирект %q, ожидалась ошибка state", loc)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "refresh_token" && cookie.Value != "" {
+			t.Fatal("чужой браузер получил refresh-токен")
+		}
+	}
+}
+
+// Челлендж 2FA после входа через провайдера приходит в HttpOnly cookie, а не в адресе редиректа.
+func TestOIDCCallbackPassesChallengeInCookie(t *testing.T) {
+	setupTestDB(t)
+	mock := setupMockOIDC(t)
+	mock.claims = jwt.MapClaims{"sub": "sub-2fa", "email": "2fa@example.com", "email_verified": true}
+	user := createTestUser(t, "2fa@example.com", 0)
+	config.DB.Model(&user).Updates(map[string]interface{}{"two_factor_enabled": true, "totp_secret": "JBSWY3DPEHPK3PXP"})
+
+	app := oidcApp()
+	app.Post("/api/auth/2fa/verify", VerifyTwoFactorLogin)
+	callback, browser := startOIDCLogin(t, app, mock)
+
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(browser)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := resp.Header.Get("Location"); loc != "http://client.test/auth/2fa" {
+		t.Fatalf("OIDCCallback: редирект %q", loc)
+	}
+	var challenge *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == loginChallengeCookie {
+			challenge = cookie
+		}
+	}
+	if challenge == nil || !challenge.HttpOnly || challenge.Value == "" {
+		t.Fatal("челлендж не передан в HttpOnly cookie")
+	}
+
+	// Второй шаг находит челлендж по cookie: неверный код — 401, а не «челлендж не найден»
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/2fa/verify", strings.NewReader(`{"code":"000000"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(challenge)
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != fiber.StatusUnauthorized || out.Error != "Неверный код" {
+		t.Fatalf("второй шаг: статус %d, %q", resp.StatusCode, out.Error)
+	}
+}
+
+// Неактивированный аккаунт, который зарегистрировал кто-то другой на email жертвы: после входа
+// жертвы через провайдера пароль, смена email, ссылки сброса и сессии регистратора не действуют.
+func TestOIDCLinksUnactivatedAccountAndDropsForeignCredentials(t *testing.T) {
+	setupTestDB(t)
+	mock := setupMockOIDC(t)
+	mock.claims = jwt.MapClaims{"sub": "victim-sub", "email": "victim@example.com", "email_verified": true, "name": "Жертва"}
+
+	// Регистрация «атакующего»: чужой email, свой пароль, свои токены
+	attackerPassword, _ := bcrypt.GenerateFromPassword([]byte("attacker-pass"), bcrypt.MinCost)
+	emailChangeExpires := time.Now().Add(time.Hour)
+	user := models.User{
+		Name: "attacker", Email: "victim@example.com", Password: string(attackerPassword),
+		ActivationLink: "activation", PendingEmail: "attacker@example.com",
+		EmailChangeToken: "change-token", EmailChangeExpiresAt: &emailChangeExpires,
+	}
+	if err := config.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	config.DB.Model(&user).Update("is_activated", false)
+	config.DB.Create(&models.Token{UserID: user.ID, Token: "attacker-refresh", SessionID: "attacker-session", ExpiresAt: time.Now().Add(time.Hour)})
+	config.DB.Create(&models.PasswordResetToken{UserID: user.ID, TokenHash: "attacker-reset", ExpiresAt: time.Now().Add(time.Hour)})
+
+	app := oidcApp()
+	callback, browser := startOIDCLogin(t, app, mock)
+
+	// Возврат от провайдера в тот же браузер
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(browser)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := resp.Header.Get("Location"); loc != "http://client.test/auth/oidc/complete" {
+		t.Fatalf("OIDCCallback: редирект %q, ожидался вход", loc)
+	}
+
+	var got models.User
+	if err := config.DB.First(&got, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsActivated {
+		t.Error("аккаунт не активирован")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(got.Password), []byte("attacker-pass")) == nil {
+		t.Error("пароль регистратора по-прежнему подходит")
+	}
+	if got.PendingEmail != "" || got.EmailChangeToken != "" || got.EmailChangeExpiresAt != nil {
+		t.Errorf("ожидающая смена email не сброшена: %q", got.PendingEmail)
+	}
+
+	var count int64
+	config.DB.Model(&models.Token{}).Where("token = ?", "attacker-refresh").Count(&count)
+	if count != 0 {
+		t.Error("refresh-токен регистратора не отозван")
+	}
+	config.DB.Model(&models.PasswordResetToken{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Error("ссылки сброса пароля не удалены")
+	}
+	config.DB.Model(&models.ExternalIdentity{}).Where("user_id = ? AND provider = ? AND subject = ?", user.ID, "mock", "victim-sub").Count(&count)
+	if count != 1 {
+		t.Error("привязка к провайдеру не создана")
+	}
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/models"
+	"diplom/payments"
+)
+
+// yooKassaStub — локальная заглушка API YooKassa: GET /payments/{id} отдаёт заданные платежи.
+type yooKassaStub struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	byID   map[string]map[string]interface{}
+}
+
+// newYooKassaStub поднимает заглушку, направляет на неё клиента (YOO_KASSA_API_URL)
+// и делает провайдером по умолчанию YooKassa.
+func newYooKassaStub(t *testing.T) *yooKassaStub {
+	t.Helper()
+	stub := &yooKassaStub{byID: map[string]map[string]interface{}{}}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutPrefix(r.URL.Path, "/payments/")
+		stub.mu.Lock()
+		p, found := stub.byID[id]
+		stub.mu.Unlock()
+		if r.Method != http.MethodGet || !ok || !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"type": "error", "code": "not_found"})
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+	}))
+	t.Cleanup(stub.server.Close)
+
+	t.Setenv("YOO_KASSA_API_URL", stub.server.URL)
+	t.Setenv("YOO_KASSA_SHOP_ID", "test-shop")
+	t.Setenv("YOO_KASSA_SECRET_KEY", "test-secret")
+	payments.SetDefault(payments.NewYooKassa())
+	return stub
+}
+
+// setPayment — состояние платежа, которое вернёт API.
+func (s *yooKassaStub) setPayment(id, status, value, currency string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID[id] = map[string]interface{}{
+		"id":     id,
+		"status": status,
+		"paid":   status == payments.StatusSucceeded,
+		"amount": map[string]string{"value": value, "currency": currency},
+	}
+}
+
+// webhookApp — приложение с одним маршрутом уведомлений.
+func webhookApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/api/subscription/webhook", PaymentWebhook)
+	return app
+}
+
+func postWebhook(t *testing.T, app *fiber.App, event, paymentID string) (int, string) {
+	t.Helper()
+	body := `{"type":"notification","event":"` + event + `","object":{"id":"` + paymentID + `","status":"succeeded","amount":{"value":"300.00","currency":"RUB"}}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/subscription/webhook", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out["message"]
+}
+
+// createPendingPayment — платёж семьи, ожидающий оплаты, как после BuySubscription.
+func createPendingPayment(t *testing.T, familyID, userID uint, paymentID string) models.Payment {
+	t.Helper()
+	payment := models.Payment{
+		PaymentID: paymentID, Provider: "yookassa", FamilyID: familyID, UserID: userID,
+		Amount: "300.00", Currency: "RUB", Status: payments.StatusPending,
+	}
+	if err := config.DB.Create(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+func TestPaymentWebhookRejectsUntrustedIP(t *testing.T) {
+	newYooKassaStub(t)
+	t.Setenv("YOO_KASSA_WEBHOOK_IPS", "") // сети YooKassa по умолчанию; адрес app.Test в них не входит
+
+	status, _ := postWebhook(t, webhookApp(), "payment.succeeded", "pay-1")
+	if status != fiber.StatusForbidden {
+		t.Fatalf("статус %d, ожидался 403", status)
+	}
+}
+
+// Тело уведомления говорит succeeded, но у провайдера платёж ещё pending — ничего не меняется.
+func TestPaymentWebhookIgnoresForgedBody(t *testing.T) {
+	setupTestDB(t)
+	stub := newYooKassaStub(t)
+	t.Setenv("YOO_KASSA_WEBHOOK_IPS", "0.0.0.0") // адрес клиента в app.Test
+
+	family, owner := createTestFamily(t, "owner@example.com")
+	payment := createPendingPayment(t, family.ID, owner.ID, "pay-forged")
+	stub.setPayment("pay-forged", payments.StatusPending, "300.00", "RUB")
+
+	if status, _ := postWebhook(t, webhookApp(), "payment.succeeded", "pay-forged"); status != fiber.StatusOK {
+		t.Fatalf("статус %d", status)
+	}
+
+	config.DB.First(&payment, payment.ID)
+	if payment.Status != payments.StatusPending {
+		t.Errorf("статус платежа %q, ожидался pending", payment.Status)
+	}
+	var subs int64
+	config.DB.Model(&models.FamilySubscription{}).Where("family_id = ? AND is_active = ?", family.ID, true).Count(&subs)
+	if subs != 0 {
+		t.Error("подписка активирована по поддельному уведомлению")
+	}
+}
+
+func TestPaymentWebhookRejectsAmountMismatch(t *testing.T) {
+	setupTestDB(t)
+	stub := newYooKassaStub(t)
+	t.Setenv("YOO_KASSA_WEBHOOK_IPS", "0.0.0.0")
+	family, owner := createTestFamily(t, "owner@example.com")
+
+	cases := []struct{ name, id, value, currency string }{
+		{"сумма", "pay-amount", "1.00", "RUB"},
+		{"валюта", "pay-currency", "300.00", "USD"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			payment := createPendingPayment(t, family.ID, owner.ID, tc.id)
+			stub.setPayment(tc.id, payments.StatusSucceeded, tc.value, tc.currency)
+
+			if _, msg := postWebhook(t, webhookApp(), "payment.succeeded", tc.id); msg != "amount mismatch" {
+				t.Errorf("ответ %q, ожидался amount mismatch", msg)
+			}
+			err := applyPaymentNotification(context.Background(), payments.Default(),
+				&payments.Notification{Event: "payment.succeeded", PaymentID: tc.id})
+			if !errors.Is(err, errPaymentMismatch) {
+				t.Errorf("applyPaymentNotification: %v, ожидался errPaymentMismatch", err)
+			}
+
+			config.DB.First(&payment, payment.ID)
+			if payment.Status != payments.StatusPending {
+				t.Errorf("статус платежа %q, ожидался pending", payment.Status)
+			}
+		})
+	}
+	var subs int64
+	config.DB.Model(&models.FamilySubscription{}).Where("family_id = ?", family.ID).Count(&subs)
+	if subs != 0 {
+		t.Error("подписка создана по платежу с другой суммой")
+	}
+}
+
+// Повтор того же уведомления не продлевает подписку второй раз.
+func TestPaymentWebhookAppliesEventOnce(t *testing.T) {
+	setupTestDB(t)
+	stub := newYooKassaStub(t)
+	t.Setenv("YOO_KASSA_WEBHOOK_IPS", "0.0.0.0")
+
+	family, owner := createTestFamily(t, "owner@example.com")
+	payment := createPendingPayment(t, family.ID, owner.ID, "pay-twice")
+	stub.setPayment("pay-twice", payments.StatusSucceeded, "300.00", "RUB")
+
+	app := webhookApp()
+	before := time.Now()
+	for i := 0; i < 2; i++ {
+		if status, msg := postWebhook(t, app, "payment.succeeded", "pay-twice"); status != fiber.StatusOK || msg != "ok" {
+			t.Fatalf("уведомление %d: статус %d, ответ %q", i+1, status, msg)
+		}
+	}
+	err := applyPaymentNotification(context.Background(), payments.Default(),
+		&payments.Notification{Event: "payment.succeeded", PaymentID: "pay-twice"})
+	if !errors.Is(err, errEventProcessed) {
+		t.Errorf("повторное применение: %v, ожидался errEventProcessed", err)
+	}
+
+	config.DB.First(&payment, payment.ID)
+	if payment.Status != payments.StatusSucceeded {
+		t.Errorf("статус платежа %q", payment.Status)
+	}
+	var periods, events int64
+	config.DB.Model(&models.SubscriptionPeriod{}).Where("family_id = ?", family.ID).Count(&periods)
+	config.DB.Model(&models.PaymentEvent{}).Where("payment_id = ?", "pay-twice").Count(&events)
+	if periods != 1 || events != 1 {
+		t.Errorf("периодов %d, событий %d — ожидалось по одному", periods, events)
+	}
+
+	var sub models.FamilySubscription
+	if err := config.DB.Where("family_id = ?", family.ID).First(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := before.AddDate(0, 1, 0); !sub.IsActive || sub.EndDate.Before(want) || sub.EndDate.After(want.Add(time.Minute)) {
+		t.Errorf("подписка: active=%v, до %v; ожидался месяц с %v", sub.IsActive, sub.EndDate, before)
+	}
+}
+
+// Цена плана, записанная без копеек ("300"), совпадает с суммой провайдера "300.00".
+func TestPaymentWebhookComparesAmountsByValue(t *testing.T) {
+	setupTestDB(t)
+	stub := newYooKassaStub(t)
+	t.Setenv("YOO_KASSA_WEBHOOK_IPS", "0.0.0.0")
+
+	family, owner := createTestFamily(t, "owner@example.com")
+	payment := createPendingPayment(t, family.ID, owner.ID, "pay-whole")
+	config.DB.Model(&payment).Update("amount", "300")
+	stub.setPayment("pay-whole", payments.StatusSucceeded, "300.00", "RUB")
+
+	if status, msg := postWebhook(t, webhookApp(), "payment.succeeded", "pay-whole"); status != fiber.StatusOK || msg != "ok" {
+		t.Fatalf("статус %d, ответ %q", status, msg)
+	}
+	config.DB.First(&payment, payment.ID)
+	if payment.Status != payments.StatusSucceeded {
+		t.Errorf("статус платежа %q, ожидался succeeded", payment.Status)
+	}
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/utils"
+)
+
+// Срок действия персонального токена по умолчанию и максимальный, в днях
+const (
+	personalTokenDefaultDays = 90
+	personalTokenMaxDays     = 365
+)
+
+// CreatePersonalTokenInput — название, права и срок действия нового токена.
+type CreatePersonalTokenInput struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// ListPersonalTokens возвращает токены пользователя (без самих значений).
+func ListPersonalTokens(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var tokens []models.PersonalAccessToken
+	if err := config.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки токенов"})
+	}
+	return c.JSON(fiber.Map{"tokens": tokens, "available_scopes": middleware.PersonalTokenScopes})
+}
+
+// CreatePersonalToken выпускает персональный токен. Значение возвращается только в этом ответе.
+func CreatePersonalToken(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input CreatePersonalTokenInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len([]rune(name)) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Название должно содержать от 1 до 100 символов"})
+	}
+
+	scopes, ok := normalizeScopes(input.Scopes)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":            "Укажите права токена из списка доступных",
+			"available_scopes": middleware.PersonalTokenScopes,
+		})
+	}
+
+	days := input.ExpiresInDays
+	if days == 0 {
+		days = personalTokenDefaultDays
+	}
+	if days < 1 || days > personalTokenMaxDays {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Срок действия — от 1 до 365 дней"})
+	}
+
+	secret, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации токена"})
+	}
+	value := middleware.PersonalTokenPrefix + secret
+	token := models.PersonalAccessToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: utils.HashToken(value),
+		Prefix:    value[:len(middleware.PersonalTokenPrefix)+6],
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: time.Now().Add(time.Duration(days) * 24 * time.Hour),
+		CreatedAt: time.Now(),
+	}
+	if err := config.DB.Create(&token).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения токена"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"token": value, // больше показать его не получится
+		"info":  token,
+	})
+}
+
+// RevokePersonalToken отзывает токен и закрывает открытые им WebSocket-соединения.
+func RevokePersonalToken(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID токена"})
+	}
+
+	res := config.DB.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, user.ID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отзыва токена"})
+	}
+	if res.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Токен не найден"})
+	}
+	disconnectSession(middleware.PersonalTokenSessionID(uint(id)))
+
+	return c.JSON(fiber.Map{"message": "Токен отозван"})
+}
+
+// normalizeScopes убирает дубликаты и проверяет, что все права известны.
+func normalizeScopes(requested []string) ([]string, bool) {
+	var scopes []string
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		known := false
+		for _, s := range middleware.PersonalTokenScopes {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, false
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, len(scopes) > 0
+}
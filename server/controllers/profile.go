@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"diplom/config"
+	"diplom/mail"
+	"diplom/middleware"
+	"diplom/models"
+)
+
+// UpdateProfileInput — изменяемые поля профиля. Отсутствующие поля не меняются.
+type UpdateProfileInput struct {
+	Name *string `json:"name"`
+}
+
+// ChangePasswordInput — смена пароля с подтверждением текущего.
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangeEmailInput — запрос на смену email; пароль подтверждает владельца аккаунта.
+type ChangeEmailInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// AvatarInput — аватар в виде dataURL, как картинки в чате.
+type AvatarInput struct {
+	Avatar string `json:"avatar"`
+}
+
+// currentUserFromCtx возвращает копию пользователя, загруженного middleware.JWTProtected.
+func currentUserFromCtx(c *fiber.Ctx) (models.User, *fiber.Error) {
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return models.User{}, fiber.NewError(fiber.StatusUnauthorized, "Требуется авторизация")
+	}
+	return auth.User, nil
+}
+
+// GetProfile возвращает профиль текущего пользователя.
+func GetProfile(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	return c.JSON(fiber.Map{"user": user})
+}
+
+// UpdateProfile меняет имя пользователя.
+func UpdateProfile(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input UpdateProfileInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" || len([]rune(name)) > 100 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Имя должно содержать от 1 до 100 символов"})
+		}
+		user.Name = name
+	}
+
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения профиля"})
+	}
+	return c.JSON(fiber.Map{"user": user})
+}
+
+// ChangePassword меняет пароль после проверки текущего и завершает остальные сессии.
+func ChangePassword(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input ChangePasswordInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный текущий пароль"})
+	}
+	if len(input.NewPassword) < 6 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Новый пароль должен содержать не менее 6 символов"})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка при хэшировании пароля"})
+	}
+	user.Password = string(hashedPassword)
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения пароля"})
+	}
+
+	// Остальные устройства должны войти заново; текущая сессия сохраняется
+	if err := revokeUserSessions(user.ID, currentSessionID(c)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Пароль изменён, но не удалось завершить другие сессии"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Пароль изменён"})
+}
+
+// RequestEmailChange сохраняет новый email как ожидающий и отправляет на него письмо с подтверждением.
+func RequestEmailChange(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input ChangeEmailInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный пароль"})
+	}
+
+	newEmail := strings.TrimSpace(input.Email)
+	if !strings.Contains(newEmail, "@") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Некорректный email"})
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Это ваш текущий email"})
+	}
+	var count int64
+	config.DB.Model(&models.User{}).Where("email = ?", newEmail).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email уже используется"})
+	}
+
+	token := uuid.New().String()
+	expiresAt := time.Now().Add(24 * time.Hour)
+	user.PendingEmail = newEmail
+	user.EmailChangeToken = token
+	user.EmailChangeExpiresAt = &expiresAt
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения запроса на смену email"})
+	}
+
+	mailService := mail.NewMailService()
+	confirmURL := os.Getenv("CLIENT_URL") + "/auth/email/confirm/" + token
+	if err := mailService.SendEmailChangeMail(newEmail, confirmURL); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отправки письма с подтверждением"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Письмо с подтверждением отправлено на новый email"})
+}
+
+// ConfirmEmailChange применяет ожидающий email по токену из письма.
+func ConfirmEmailChange(c *fiber.Ctx) error {
+	token := c.Params("token")
+	var user models.User
+	if err := config.DB.Where("email_change_token = ?", token).First(&user).Error; err != nil || token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверная ссылка подтверждения"})
+	}
+	if user.EmailChangeExpiresAt == nil || time.Now().After(*user.EmailChangeExpiresAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Срок действия ссылки истёк"})
+	}
+
+	// Адрес могли занять, пока письмо шло
+	var other models.User
+	err := config.DB.Where("email = ? AND id <> ?", user.PendingEmail, user.ID).First(&other).Error
+	if err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email уже используется"})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	user.EmailChangeToken = ""
+	user.EmailChangeExpiresAt = nil
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения email"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Email успешно изменён"})
+}
+
+// UploadAvatar сохраняет аватар пользователя в то же хранилище, что и медиа чата.
+func UploadAvatar(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input AvatarInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	url, err := saveBase64Image(input.Avatar, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Не удалось сохранить аватар"})
+	}
+
+	user.AvatarURL = url
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения аватара"})
+	}
+	return c.JSON(fiber.Map{"avatar_url": url})
+}
+
+// DeleteAvatar убирает аватар пользователя.
+func DeleteAvatar(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	user.AvatarURL = nil
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка удаления аватара"})
+	}
+	return c.JSON(fiber.Map{"message": "Аватар удалён"})
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/billing"
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/payments"
+)
+
+// CreatePromoCodeInput — новый промокод. Указывается либо discount_percent (1–99),
+// либо discount_amount ("100.00"). max_redemptions = 0 — без ограничения.
+type CreatePromoCodeInput struct {
+	Code            string     `json:"code"`
+	Description     string     `json:"description"`
+	DiscountPercent int        `json:"discount_percent"`
+	DiscountAmount  string     `json:"discount_amount"`
+	MaxRedemptions  int        `json:"max_redemptions"`
+	ValidFrom       *time.Time `json:"valid_from"`
+	ValidUntil      *time.Time `json:"valid_until"`
+}
+
+// ListPromoCodes — все промокоды, от новых к старым.
+func ListPromoCodes(c *fiber.Ctx) error {
+	var promos []models.PromoCode
+	if err := config.DB.Order("created_at DESC").Find(&promos).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки промокодов"})
+	}
+	return c.JSON(promos)
+}
+
+// CreatePromoCode — администратор заводит промокод.
+func CreatePromoCode(c *fiber.Ctx) error {
+	var input CreatePromoCodeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	code := billing.NormalizeCode(input.Code)
+	if code == "" || len(code) > 50 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Код должен быть от 1 до 50 символов"})
+	}
+	hasPercent, hasAmount := input.DiscountPercent != 0, input.DiscountAmount != ""
+	if hasPercent == hasAmount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Укажите либо процент скидки, либо сумму"})
+	}
+	if hasPercent && (input.DiscountPercent < 1 || input.DiscountPercent > 99) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Процент скидки должен быть от 1 до 99"})
+	}
+	if hasAmount {
+		amount, err := payments.ParseAmount(input.DiscountAmount)
+		if err != nil || amount <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Некорректная сумма скидки"})
+		}
+		input.DiscountAmount = payments.FormatAmount(amount)
+	}
+	if input.MaxRedemptions < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Лимит использований не может быть отрицательным"})
+	}
+	if input.ValidFrom != nil && input.ValidUntil != nil && !input.ValidUntil.After(*input.ValidFrom) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Окончание действия должно быть позже начала"})
+	}
+
+	var exists int64
+	config.DB.Model(&models.PromoCode{}).Where("code = ?", code).Count(&exists)
+	if exists > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Такой промокод уже есть"})
+	}
+
+	promo := models.PromoCode{
+		Code:            code,
+		Description:     input.Description,
+		DiscountPercent: input.DiscountPercent,
+		DiscountAmount:  input.DiscountAmount,
+		MaxRedemptions:  input.MaxRedemptions,
+		ValidFrom:       input.ValidFrom,
+		ValidUntil:      input.ValidUntil,
+		IsActive:        true,
+		CreatedByID:     middleware.Auth(c).UserID(),
+	}
+	if err := config.DB.Create(&promo).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения промокода"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(promo)
+}
+
+// DeactivatePromoCode отключает промокод. Уже оформленные с ним платежи не меняются.
+func DeactivatePromoCode(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID промокода"})
+	}
+	res := config.DB.Model(&models.PromoCode{}).Where("id = ?", id).Update("is_active", false)
+	if res.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+	if res.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Промокод не найден"})
+	}
+	return c.JSON(fiber.Map{"message": "Промокод отключён"})
+}
+
+// CheckPromoCode — цена плана с промокодом, без использования промокода (для формы покупки).
+// GET /subscription/promo/:code?plan_id=
+func CheckPromoCode(c *fiber.Ctx) error {
+	promo, err := billing.FindPromo(config.DB, c.Params("code"), time.Now())
+	if err != nil {
+		return promoError(c, err)
+	}
+
+	var plan models.Plan
+	query := config.DB.Where("is_active = ?", true)
+	if planID := c.QueryInt("plan_id"); planID != 0 {
+		query = query.Where("id = ?", planID)
+	} else {
+		query = query.Where("code = ?", billing.DefaultPlanCode)
+	}
+	if err := query.First(&plan).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "План не найден"})
+	}
+
+	price, discount, err := billing.PromoPrice(promo, plan)
+	if err != nil {
+		return promoError(c, err)
+	}
+	return c.JSON(fiber.Map{"code": promo.Code, "plan_id": plan.ID, "price": plan.Price,
+		"discount": discount, "amount": price, "valid_until": promo.ValidUntil})
+}
+
+// promoError — ответ на ошибку проверки промокода.
+func promoError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, billing.ErrPromoNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, billing.ErrPromoInactive), errors.Is(err, billing.ErrPromoExhausted),
+		errors.Is(err, billing.ErrPromoUsed), errors.Is(err, billing.ErrPromoNotApplicable):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/limiter"
+	"diplom/mail"
+)
+
+// Лимиты на вход, второй шаг входа, регистрацию, повторную отправку письма активации и запрос сброса пароля. По умолчанию состояние хранится в памяти;
+// UseRateLimitStore переключает их на общее хранилище (например, БД).
+var (
+	// С одного IP могут входить несколько человек (NAT), поэтому порог выше, а блокировки нет — только задержки
+	loginIPLimiter = limiter.New(limiter.NewMemoryStore(), "login:ip:", limiter.ConfigFromEnv("LOGIN_IP", limiter.Config{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}))
+	loginEmailLimiter = limiter.New(limiter.NewMemoryStore(), "login:email:", limiter.ConfigFromEnv("LOGIN_EMAIL", limiter.Config{
+		FreeAttempts:     3,
+		BaseDelay:        2 * time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  30 * time.Minute,
+		Window:           time.Hour,
+	}))
+	// Неверные коды второго шага (2FA): по пользователю — через все его челленджи, по IP — перебор чужих
+	twoFactorUserLimiter = limiter.New(limiter.NewMemoryStore(), "2fa:user:", limiter.ConfigFromEnv("TWO_FACTOR_USER", limiter.Config{
+		FreeAttempts:     5,
+		BaseDelay:        5 * time.Second,
+		MaxDelay:         15 * time.Minute,
+		LockoutThreshold: 20,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}))
+	twoFactorIPLimiter = limiter.New(limiter.NewMemoryStore(), "2fa:ip:", limiter.ConfigFromEnv("TWO_FACTOR_IP", limiter.Config{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}))
+	// Регистрация считается каждой попыткой, а не только неудачной
+	registerIPLimiter = limiter.New(limiter.NewMemoryStore(), "register:ip:", limiter.ConfigFromEnv("REGISTER_IP", limiter.Config{
+		FreeAttempts: 5,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}))
+	// Повторная отправка письма активации: одно письмо сразу, дальше с растущим интервалом
+	resendEmailLimiter = limiter.New(limiter.NewMemoryStore(), "resend:email:", limiter.ConfigFromEnv("RESEND_EMAIL", limiter.Config{
+		FreeAttempts: 1,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}))
+	resendIPLimiter = limiter.New(limiter.NewMemoryStore(), "resend:ip:", limiter.ConfigFromEnv("RESEND_IP", limiter.Config{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}))
+	// Запрос ссылки сброса пароля: одно письмо сразу, дальше с растущим интервалом
+	forgotEmailLimiter = limiter.New(limiter.NewMemoryStore(), "forgot:email:", limiter.ConfigFromEnv("FORGOT_EMAIL", limiter.Config{
+		FreeAttempts: 1,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}))
+	forgotIPLimiter = limiter.New(limiter.NewMemoryStore(), "forgot:ip:", limiter.ConfigFromEnv("FORGOT_IP", limiter.Config{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}))
+)
+
+// UseRateLimitStore задаёт хранилище для всех лимитеров авторизации.
+func UseRateLimitStore(store limiter.Store) {
+	loginIPLimiter.SetStore(store)
+	loginEmailLimiter.SetStore(store)
+	twoFactorUserLimiter.SetStore(store)
+	twoFactorIPLimiter.SetStore(store)
+	registerIPLimiter.SetStore(store)
+	resendEmailLimiter.SetStore(store)
+	resendIPLimiter.SetStore(store)
+	forgotEmailLimiter.SetStore(store)
+	forgotIPLimiter.SetStore(store)
+}
+
+// normalizeEmailKey приводит email к виду ключа лимитера, чтобы регистр не обходил счётчик.
+func normalizeEmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLimits возвращает самый строгий результат из нескольких лимитеров.
+// Ошибки хранилища только логируются: недоступность лимитера не должна блокировать вход.
+func checkLimits(checks ...func() (limiter.Result, error)) limiter.Result {
+	var worst limiter.Result
+	for _, check := range checks {
+		res, err := check()
+		if err != nil {
+			log.Printf("Ошибка лимитера: %v\n", err)
+			continue
+		}
+		if (res.Locked && !worst.Locked) || (res.Locked == worst.Locked && res.RetryAfter > worst.RetryAfter) {
+			worst = res
+		}
+	}
+	return worst
+}
+
+// tooManyAttempts отвечает 429 с заголовком Retry-After.
+func tooManyAttempts(c *fiber.Ctx, res limiter.Result) error {
+	seconds := int(math.Ceil(res.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	message := "Слишком много попыток, попробуйте позже"
+	if res.Locked {
+		message = "Вход временно заблокирован из-за большого числа неудачных попыток"
+	}
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// reserveLoginAttempt засчитывает попытку входа по IP и email до проверки пароля: параллельные
+// запросы не обойдут задержку и блокировку, пока первая неудача ещё не записана. Отклонённая
+// попытка не засчитывается. Возвращает самый строгий результат и признак того, что email
+// попал под блокировку именно этой попыткой.
+func reserveLoginAttempt(ip, emailKey string) (limiter.Result, bool) {
+	res, err := loginIPLimiter.Hit(ip)
+	if err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+	if res.RetryAfter > 0 {
+		return res, false
+	}
+	res, err = loginEmailLimiter.Hit(emailKey)
+	if err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+	if res.RetryAfter > 0 {
+		releaseLoginIP(ip)
+		return res, false
+	}
+	return limiter.Result{}, res.LockedNow
+}
+
+// releaseLoginAttempt снимает зарезервированную попытку, если пароль так и не проверялся.
+func releaseLoginAttempt(ip, emailKey string) {
+	releaseLoginIP(ip)
+	if err := loginEmailLimiter.Release(emailKey); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+}
+
+// loginSucceeded сбрасывает счётчик email после верного пароля. Счётчик по IP только
+// освобождает эту попытку: иначе успешный вход в свой аккаунт обнулял бы перебор чужих.
+func loginSucceeded(ip, emailKey string) {
+	releaseLoginIP(ip)
+	if err := loginEmailLimiter.Reset(emailKey); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+}
+
+func releaseLoginIP(ip string) {
+	if err := loginIPLimiter.Release(ip); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+}
+
+// notifyAccountLocked сообщает владельцу email, что вход заблокирован после неудачных попыток.
+func notifyAccountLocked(email string) {
+	lockedUntil := time.Now().Add(loginEmailLimiter.Config().LockoutDuration)
+	go func() {
+		if err := mail.NewMailService().SendAccountLockedMail(email, lockedUntil); err != nil {
+			log.Printf("Ошибка отправки уведомления о блокировке %s: %v\n", email, err)
+		}
+	}()
+}
+
+// twoFactorUserKey — ключ лимитера второго шага для пользователя.
+func twoFactorUserKey(userID uint) string {
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// checkTwoFactorLimits — самый строгий из лимитов второго шага для пользователя и IP.
+func checkTwoFactorLimits(ip string, userID uint) limiter.Result {
+	return checkLimits(
+		func() (limiter.Result, error) { return twoFactorIPLimiter.Check(ip) },
+		func() (limiter.Result, error) { return twoFactorUserLimiter.Check(twoFactorUserKey(userID)) },
+	)
+}
+
+// reserveTwoFactorAttempt засчитывает ввод кода второго фактора по IP и пользователю до его
+// проверки, как reserveLoginAttempt: параллельный перебор не обгонит счётчик. Отклонённая
+// попытка не засчитывается.
+func reserveTwoFactorAttempt(ip string, userID uint) limiter.Result {
+	res, err := twoFactorIPLimiter.Hit(ip)
+	if err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+	if res.RetryAfter > 0 {
+		return res
+	}
+	res, err = twoFactorUserLimiter.Hit(twoFactorUserKey(userID))
+	if err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+	if res.RetryAfter > 0 {
+		releaseTwoFactorIP(ip)
+		return res
+	}
+	return limiter.Result{}
+}
+
+// releaseTwoFactorAttempt снимает зарезервированную попытку, если код так и не проверялся.
+func releaseTwoFactorAttempt(ip string, userID uint) {
+	releaseTwoFactorIP(ip)
+	if err := twoFactorUserLimiter.Release(twoFactorUserKey(userID)); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+}
+
+// twoFactorSucceeded сбрасывает счётчик пользователя после верного кода. По IP только
+// снимается эта попытка — как и при входе по паролю.
+func twoFactorSucceeded(ip string, userID uint) {
+	releaseTwoFactorIP(ip)
+	if err := twoFactorUserLimiter.Reset(twoFactorUserKey(userID)); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+}
+
+func releaseTwoFactorIP(ip string) {
+	if err := twoFactorIPLimiter.Release(ip); err != nil {
+		log.Printf("Ошибка лимитера: %v\n", err)
+	}
+}
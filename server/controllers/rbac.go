@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/rbac"
+)
+
+// GrantRoleInput — имя выдаваемой роли.
+type GrantRoleInput struct {
+	Role string `json:"role"`
+}
+
+// ListRoles возвращает все роли с их правами.
+func ListRoles(c *fiber.Ctx) error {
+	var roles []models.Role
+	if err := config.DB.Preload("Permissions").Order("priority DESC").Find(&roles).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки ролей"})
+	}
+	return c.JSON(roles)
+}
+
+// GetUserRoles возвращает роли пользователя и итоговый набор прав.
+func GetUserRoles(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID пользователя"})
+	}
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Пользователь не найден"})
+	}
+
+	var roles []models.UserRole
+	if err := config.DB.Preload("Role").Where("user_id = ?", user.ID).Find(&roles).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки ролей"})
+	}
+	perms, err := rbac.UserPermissions(config.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки прав"})
+	}
+	return c.JSON(fiber.Map{"primary_role": user.Role, "roles": roles, "permissions": perms})
+}
+
+// GrantRole выдаёт пользователю роль. Выдать роль с правами, которых нет у себя, нельзя.
+func GrantRole(c *fiber.Ctx) error {
+	auth := middleware.Auth(c)
+
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID пользователя"})
+	}
+	var input GrantRoleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Пользователь не найден"})
+	}
+	var role models.Role
+	if err := config.DB.Preload("Permissions").Where("name = ?", input.Role).First(&role).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": rbac.ErrUnknownRole.Error()})
+	}
+	codes := make([]string, 0, len(role.Permissions))
+	for _, p := range role.Permissions {
+		codes = append(codes, p.Code)
+	}
+	if !rbac.Covers(auth.Permissions, codes) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Нельзя выдать роль с правами, которых нет у вас"})
+	}
+
+	if err := rbac.Grant(config.DB, user.ID, role.Name, auth.UserID()); err != nil {
+		if errors.Is(err, rbac.ErrUnknownRole) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Эту роль нельзя выдать"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка выдачи роли"})
+	}
+	return c.JSON(fiber.Map{"message": "Роль выдана", "user_id": user.ID, "role": role.Name})
+}
+
+// RevokeRole отбирает у пользователя роль.
+func RevokeRole(c *fiber.Ctx) error {
+	auth := middleware.Auth(c)
+
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID пользователя"})
+	}
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Пользователь не найден"})
+	}
+	// Нельзя понизить пользователя, у которого есть права, которых нет у тебя
+	targetPerms, err := rbac.UserPermissions(config.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка проверки прав"})
+	}
+	if !rbac.Covers(auth.Permissions, targetPerms) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Нет доступа"})
+	}
+
+	switch err := rbac.Revoke(config.DB, user.ID, c.Params("role")); {
+	case errors.Is(err, rbac.ErrUnknownRole):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, rbac.ErrLastRoleManager):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отзыва роли"})
+	}
+	return c.JSON(fiber.Map{"message": "Роль отозвана", "user_id": user.ID, "role": c.Params("role")})
+}
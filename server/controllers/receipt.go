@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/payments"
+	"diplom/rbac"
+)
+
+// GetMyPayments — платежи текущего пользователя с возвратами и статусами чеков, от новых к старым.
+func GetMyPayments(c *fiber.Ctx) error {
+	user := middleware.Auth(c).User
+
+	var list []models.Payment
+	if err := config.DB.Preload("Refunds").Where("user_id = ?", user.ID).Order("created_at DESC").Find(&list).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки платежей"})
+	}
+	return c.JSON(list)
+}
+
+// DownloadReceipt — сводка по чеку платежа текстовым файлом: позиции, НДС, контакт покупателя,
+// статусы платежа и регистрации чека, возвраты. Доступна плательщику и сотрудникам с payments:read.
+func DownloadReceipt(c *fiber.Ctx) error {
+	auth := middleware.Auth(c)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID платежа"})
+	}
+	var payment models.Payment
+	if err := config.DB.Preload("Refunds").First(&payment, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Платёж не найден"})
+	}
+	if payment.UserID != auth.UserID() && !auth.Can(rbac.PaymentsRead) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Платёж не найден"})
+	}
+
+	// Чек мог зарегистрироваться после последнего уведомления — уточняем у провайдера
+	if payment.ReceiptStatus == payments.StatusPending {
+		provider := payments.Default()
+		if payment.Provider == provider.Name() {
+			if remote, err := provider.GetPayment(c.Context(), payment.PaymentID); err == nil && remote.ReceiptStatus != "" {
+				payment.ReceiptStatus = remote.ReceiptStatus
+				config.DB.Model(&payment).Update("receipt_status", remote.ReceiptStatus)
+			}
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="receipt-%d.txt"`, payment.ID))
+	return c.SendString(receiptSummary(payment))
+}
+
+// receiptSummary — текст сводки по чеку.
+func receiptSummary(p models.Payment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Платёж №%d от %s\n", p.ID, p.CreatedAt.Format("02.01.2006 15:04"))
+	fmt.Fprintf(&b, "Идентификатор у провайдера: %s\n", p.PaymentID)
+	fmt.Fprintf(&b, "Статус платежа: %s\n\n", paymentStatusTitle(p.Status))
+
+	if len(p.ReceiptItems) == 0 {
+		b.WriteString("Фискальный чек по этому платежу не передавался.\n")
+	} else {
+		fmt.Fprintf(&b, "Покупатель: %s\n", p.ReceiptEmail)
+		b.WriteString("Позиции:\n")
+		for i, item := range p.ReceiptItems {
+			fmt.Fprintf(&b, "  %d. %s — %s × %s %s, НДС: %s\n",
+				i+1, item.Description, item.Quantity, item.Amount, p.Currency, vatTitle(item.VATCode))
+		}
+		fmt.Fprintf(&b, "Статус чека: %s\n", receiptStatusTitle(p.ReceiptStatus))
+	}
+	if p.Discount != "" {
+		fmt.Fprintf(&b, "Скидка по промокоду: %s %s\n", p.Discount, p.Currency)
+	}
+	fmt.Fprintf(&b, "Итого: %s %s\n", p.Amount, p.Currency)
+
+	if len(p.Refunds) > 0 {
+		b.WriteString("\nВозвраты:\n")
+		for _, r := range p.Refunds {
+			fmt.Fprintf(&b, "  %s — %s %s, %s", r.CreatedAt.Format("02.01.2006"), r.Amount, r.Currency, paymentStatusTitle(r.Status))
+			if r.ReceiptStatus != "" {
+				fmt.Fprintf(&b, ", чек возврата: %s", receiptStatusTitle(r.ReceiptStatus))
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func paymentStatusTitle(status string) string {
+	switch status {
+	case payments.StatusPending, payments.StatusWaitingForCapture:
+		return "ожидает оплаты"
+	case payments.StatusSucceeded:
+		return "оплачен"
+	case payments.StatusCanceled:
+		return "отменён"
+	case payments.StatusRefunded:
+		return "возвращён"
+	}
+	return status
+}
+
+func receiptStatusTitle(status string) string {
+	switch status {
+	case payments.StatusPending:
+		return "регистрируется"
+	case payments.StatusSucceeded:
+		return "зарегистрирован"
+	case payments.StatusCanceled:
+		return "не зарегистрирован"
+	}
+	return "нет данных"
+}
+
+// vatTitle — ставка НДС по коду YooKassa.
+func vatTitle(code int) string {
+	switch code {
+	case 1:
+		return "без НДС"
+	case 2:
+		return "0%"
+	case 3:
+		return "10%"
+	case 4:
+		return "20%"
+	case 5:
+		return "10/110"
+	case 6:
+		return "20/120"
+	}
+	return fmt.Sprintf("код %d", code)
+}
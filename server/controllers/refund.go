@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/billing"
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/payments"
+	"diplom/utils"
+)
+
+// RefundPaymentInput — сумма возврата ("100.00"; пусто — весь остаток) и причина.
+type RefundPaymentInput struct {
+	Amount string `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// RefundPayment — администратор оформляет полный или частичный возврат через провайдера.
+// Право payments:refund проверено в routes (middleware.RequirePermission).
+// Заголовок Idempotence-Key (необязательный) позволяет клиенту безопасно повторить запрос.
+func RefundPayment(c *fiber.Ctx) error {
+	admin := middleware.Auth(c)
+
+	paymentID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID платежа"})
+	}
+	var input RefundPaymentInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	clientKey := c.Get("Idempotence-Key")
+	if len(clientKey) > 64 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Idempotence-Key длиннее 64 символов"})
+	}
+
+	// Строка платежа заблокирована от проверки остатка до записи возврата: параллельные
+	// запросы не вернут вместе больше суммы платежа
+	provider := payments.Default()
+	var payment models.Payment
+	var r *payments.Refund
+	var refund models.PaymentRefund
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentID).Error; err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Платёж не найден")
+		}
+		if payment.Status != payments.StatusSucceeded {
+			return fiber.NewError(fiber.StatusBadRequest, "Вернуть можно только проведённый платёж")
+		}
+		if payment.Provider != provider.Name() {
+			return fiber.NewError(fiber.StatusBadRequest, "Платёж проведён через другого провайдера")
+		}
+
+		// Остаток: сумма платежа минус возвраты, которые прошли или ещё обрабатываются
+		total, err := payments.ParseAmount(payment.Amount)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Некорректная сумма платежа")
+		}
+		refunded, err := refundedAmount(tx, payment.ID, payments.StatusPending, payments.StatusSucceeded)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Ошибка базы данных")
+		}
+		amount := total - refunded
+		if input.Amount != "" {
+			if amount, err = payments.ParseAmount(input.Amount); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Некорректная сумма возврата")
+			}
+		}
+		if amount <= 0 || amount > total-refunded {
+			return errRefundExceedsRemaining{remaining: total - refunded}
+		}
+
+		// Ключ идемпотентности: от клиента или из платежа и запроса — повтор того же запроса
+		// (двойной клик, обрыв связи) вернёт уже оформленный возврат. Второй возврат той же суммы
+		// по платежу оформляется с другой причиной или своим Idempotence-Key
+		key := clientKey
+		if key == "" {
+			key = fmt.Sprintf("refund-%d-%d-%s", payment.ID, amount, utils.HashToken(input.Reason)[:16])
+		}
+		r, err = provider.Refund(c.Context(), payments.RefundRequest{
+			PaymentID:      payment.PaymentID,
+			Amount:         payments.Amount{Value: payments.FormatAmount(amount), Currency: payment.Currency},
+			Description:    input.Reason,
+			IdempotenceKey: key,
+			Receipt:        billing.RefundReceipt(payment, payments.FormatAmount(amount)),
+		})
+		if err != nil {
+			log.Printf("Ошибка возврата по платежу %s: %v\n", payment.PaymentID, err)
+			return fiber.NewError(fiber.StatusBadGateway, "Платёжный сервис отклонил возврат")
+		}
+
+		// Запись могла появиться раньше из уведомления — тогда дописываем автора и причину
+		adminID := admin.UserID()
+		refund = models.PaymentRefund{
+			RefundID:    r.ID,
+			PaymentID:   payment.ID,
+			FamilyID:    payment.FamilyID,
+			Amount:      r.Amount.Value,
+			Currency:    r.Amount.Currency,
+			Status:      payments.StatusPending, // итоговый статус применит applyRefundNotification
+			Description: input.Reason,
+			CreatedByID: &adminID,
+
+			ReceiptStatus: r.ReceiptStatus,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "refund_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "created_by_id"}),
+		}).Create(&refund).Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Ошибка сохранения возврата")
+		}
+		return nil
+	})
+	var exceeds errRefundExceedsRemaining
+	var ferr *fiber.Error
+	switch {
+	case errors.As(err, &exceeds):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":     "Сумма возврата должна быть больше нуля и не больше остатка",
+			"remaining": payments.FormatAmount(exceeds.remaining),
+		})
+	case errors.As(err, &ferr):
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения возврата"})
+	}
+
+	if r.Status == payments.StatusSucceeded || r.Status == payments.StatusCanceled {
+		err := applyRefundNotification(c.Context(), provider, &payments.Notification{
+			Event:     "refund." + r.Status,
+			PaymentID: payment.PaymentID,
+			RefundID:  r.ID,
+			SourceIP:  c.IP(),
+		})
+		if err != nil && !errors.Is(err, errEventProcessed) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Возврат оформлен, но не применён к подписке"})
+		}
+	}
+
+	config.DB.Where("refund_id = ?", r.ID).First(&refund)
+	return c.JSON(refund)
+}
+
+// errRefundExceedsRemaining — запрошенная сумма больше остатка платежа (remaining — в копейках).
+type errRefundExceedsRemaining struct {
+	remaining int64
+}
+
+func (e errRefundExceedsRemaining) Error() string {
+	return "сумма возврата больше остатка платежа"
+}
+
+// applyRefundNotification запрашивает возврат у провайдера и применяет его статус. Прошедший
+// возврат сокращает подписку семьи пропорционально сумме; полный — помечает платёж refunded
+// и отключает автопродление.
+func applyRefundNotification(ctx context.Context, provider payments.Provider, n *payments.Notification) error {
+	var payment models.Payment
+	if err := config.DB.Where("payment_id = ?", n.PaymentID).First(&payment).Error; err != nil {
+		log.Println("Payment not found:", n.PaymentID)
+		return err
+	}
+
+	remote, err := provider.GetRefund(ctx, n.RefundID)
+	if err != nil {
+		log.Printf("Не удалось проверить возврат %s у провайдера: %v\n", n.RefundID, err)
+		return err
+	}
+	if remote.PaymentID != payment.PaymentID || remote.Amount.Currency != payment.Currency {
+		log.Printf("Возврат %s не относится к платежу %s\n", n.RefundID, payment.PaymentID)
+		return errPaymentMismatch
+	}
+	amount, err := payments.ParseAmount(remote.Amount.Value)
+	if err != nil {
+		return errPaymentMismatch
+	}
+
+	event := models.PaymentEvent{
+		EventKey:  "refund." + remote.Status + ":" + remote.ID,
+		PaymentID: payment.PaymentID,
+		Event:     n.Event,
+		Status:    remote.Status,
+		SourceIP:  n.SourceIP,
+	}
+	var refund models.PaymentRefund
+	var sub models.FamilySubscription
+	applied, full := false, false
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// Строка платежа блокируется, как в RefundPayment: параллельные уведомления о частичных
+		// возвратах иначе не увидят друг друга в сумме и платёж не станет refunded
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, payment.ID).Error; err != nil {
+			return err
+		}
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errEventProcessed
+		}
+
+		// Запись о возврате: оформленный в кабинете провайдера возврат появляется здесь впервые
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.PaymentRefund{
+			RefundID:  remote.ID,
+			PaymentID: payment.ID,
+			FamilyID:  payment.FamilyID,
+			Amount:    remote.Amount.Value,
+			Currency:  remote.Amount.Currency,
+			Status:    payments.StatusPending,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("refund_id = ?", remote.ID).First(&refund).Error; err != nil {
+			return err
+		}
+		prevStatus := refund.Status
+		if err := tx.Model(&refund).Updates(map[string]interface{}{
+			"status":         remote.Status,
+			"amount":         remote.Amount.Value,
+			"receipt_status": remote.ReceiptStatus,
+		}).Error; err != nil {
+			return err
+		}
+		if remote.Status != payments.StatusSucceeded || prevStatus == payments.StatusSucceeded {
+			return nil
+		}
+
+		total, err := payments.ParseAmount(payment.Amount)
+		if err != nil {
+			return err
+		}
+		refunded, err := refundedAmount(tx, payment.ID, payments.StatusSucceeded)
+		if err != nil {
+			return err
+		}
+		full = refunded >= total
+		if full {
+			if err := tx.Model(&payment).Update("status", payments.StatusRefunded).Error; err != nil {
+				return err
+			}
+			payment.Status = payments.StatusRefunded
+		}
+
+		sub, err = billing.RevokePaidTime(tx, payment, amount, total, time.Now())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // подписки уже нет — менять нечего
+		}
+		if err != nil {
+			return err
+		}
+		if full && payment.GiftCodeID == nil {
+			if err := tx.Model(&sub).Updates(map[string]interface{}{
+				"auto_renew":        false,
+				"payment_method_id": "",
+				"next_renewal_at":   nil,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		applied = true
+		return nil
+	})
+	if errors.Is(err, errEventProcessed) {
+		log.Printf("Уведомление %s уже обработано\n", event.EventKey)
+		return err
+	}
+	if err != nil {
+		log.Printf("Ошибка обработки возврата %s: %v\n", remote.ID, err)
+		return err
+	}
+
+	if applied {
+		actorID := payment.UserID
+		if refund.CreatedByID != nil {
+			actorID = *refund.CreatedByID
+		}
+		summary := "Возврат " + remote.Amount.Value + " " + remote.Amount.Currency + ", " + subscriptionSummary(sub)
+		if full {
+			summary = "Платёж возвращён полностью, " + subscriptionSummary(sub)
+		}
+		notifySubscriptionChange(sub, actorID, ActivitySubscriptionRefunded, summary)
+	}
+	return nil
+}
+
+// refundedAmount — сумма возвратов по платежу (в копейках) в указанных статусах.
+func refundedAmount(db *gorm.DB, paymentID uint, statuses ...string) (int64, error) {
+	var refunds []models.PaymentRefund
+	if err := db.Where("payment_id = ? AND status IN ?", paymentID, statuses).Find(&refunds).Error; err != nil {
+		return 0, err
+	}
+	var sum int64
+	for _, r := range refunds {
+		v, err := payments.ParseAmount(r.Amount)
+		if err != nil {
+			return 0, err
+		}
+		sum += v
+	}
+	return sum, nil
+}
+
+// subscriptionSummary — состояние подписки для ленты активности.
+func subscriptionSummary(sub models.FamilySubscription) string {
+	if !sub.IsActive {
+		return "подписка отключена"
+	}
+	return "подписка активна до " + sub.EndDate.Format("02.01.2006")
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/billing"
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/payments"
+)
+
+// Повтор того же запроса на возврат не создаёт второй возврат, а сумма сверх остатка отклоняется.
+func TestRefundPaymentIsIdempotent(t *testing.T) {
+	setupTestDB(t)
+	fake := payments.NewFake()
+	payments.SetDefault(fake)
+
+	family, owner := createTestFamily(t, "owner@example.com")
+	admin := createTestUser(t, "admin@example.com", 0)
+	remote, err := fake.CreatePayment(context.Background(), payments.CreatePaymentRequest{
+		Amount: payments.Amount{Value: "300.00", Currency: "RUB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Complete(remote.ID, payments.StatusSucceeded)
+	payment := models.Payment{
+		PaymentID: remote.ID, Provider: fake.Name(), FamilyID: family.ID, UserID: owner.ID,
+		Amount: "300.00", Currency: "RUB", Status: payments.StatusSucceeded,
+	}
+	config.DB.Create(&payment)
+	// Подписка без периода платежа: возврат сокращает её от текущего момента
+	config.DB.Create(&models.FamilySubscription{FamilyID: family.ID, IsActive: true, EndDate: time.Now().AddDate(0, 2, 0)})
+
+	app := fiber.New()
+	app.Post("/api/admin/payments/:id/refund", middleware.JWTProtected(), RefundPayment)
+	refund := func(body, key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/payments/"+strconv.Itoa(int(payment.ID))+"/refund", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotence-Key", key)
+		}
+		req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, admin))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 2; i++ {
+		if status := refund(`{"amount":"100.00","reason":"Ошибка списания"}`, ""); status != fiber.StatusOK {
+			t.Fatalf("возврат %d: статус %d", i+1, status)
+		}
+	}
+	var refunds []models.PaymentRefund
+	config.DB.Where("payment_id = ?", payment.ID).Find(&refunds)
+	if len(refunds) != 1 || refunds[0].Status != payments.StatusSucceeded {
+		t.Fatalf("возвратов %d, ожидался один проведённый: %+v", len(refunds), refunds)
+	}
+
+	// Второй возврат той же суммы — со своим ключом клиента
+	if status := refund(`{"amount":"100.00","reason":"Ошибка списания"}`, "second-refund"); status != fiber.StatusOK {
+		t.Fatalf("возврат с ключом клиента: статус %d", status)
+	}
+	var count int64
+	config.DB.Model(&models.PaymentRefund{}).Where("payment_id = ?", payment.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("возвратов %d, ожидалось 2", count)
+	}
+
+	if status := refund(`{"amount":"150.00"}`, ""); status != fiber.StatusBadRequest {
+		t.Errorf("возврат сверх остатка: статус %d, ожидался 400", status)
+	}
+
+	var activity models.FamilyActivity
+	config.DB.Where("family_id = ? AND type = ?", family.ID, ActivitySubscriptionRefunded).First(&activity)
+	if !strings.HasPrefix(activity.Summary, "Возврат 100.00 RUB") {
+		t.Errorf("запись в ленте: %q", activity.Summary)
+	}
+}
+
+// Частичный возврат оплаты неактивированного подарка оставляет код действующим, полный — аннулирует.
+func TestGiftCanceledOnlyOnFullRefund(t *testing.T) {
+	setupTestDB(t)
+	fake := payments.NewFake()
+	payments.SetDefault(fake)
+
+	buyer := createTestUser(t, "buyer@example.com", 0)
+	admin := createTestUser(t, "admin@example.com", 0)
+	plan := models.Plan{Code: "monthly", Name: "Месяц", Price: "300.00", Currency: "RUB", DurationMonths: 1, IsActive: true}
+	config.DB.Create(&plan)
+	gift := models.GiftCode{Code: "GIFT-TEST", PlanID: plan.ID, BuyerID: buyer.ID, Status: billing.GiftPaid}
+	config.DB.Create(&gift)
+
+	remote, err := fake.CreatePayment(context.Background(), payments.CreatePaymentRequest{
+		Amount: payments.Amount{Value: "300.00", Currency: "RUB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Complete(remote.ID, payments.StatusSucceeded)
+	payment := models.Payment{
+		PaymentID: remote.ID, Provider: fake.Name(), UserID: buyer.ID, PlanID: &plan.ID, GiftCodeID: &gift.ID,
+		Amount: "300.00", Currency: "RUB", Status: payments.StatusSucceeded,
+	}
+	config.DB.Create(&payment)
+
+	app := fiber.New()
+	app.Post("/api/admin/payments/:id/refund", middleware.JWTProtected(), RefundPayment)
+	refund := func(amount string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/payments/"+strconv.Itoa(int(payment.ID))+"/refund",
+			strings.NewReader(`{"amount":"`+amount+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, admin))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("возврат %s: статус %d", amount, resp.StatusCode)
+		}
+	}
+
+	refund("100.00")
+	config.DB.First(&gift, gift.ID)
+	if gift.Status != billing.GiftPaid {
+		t.Fatalf("после частичного возврата статус подарка %q, ожидался paid", gift.Status)
+	}
+
+	refund("200.00")
+	config.DB.First(&gift, gift.ID)
+	if gift.Status != billing.GiftCanceled {
+		t.Errorf("после полного возврата статус подарка %q, ожидался canceled", gift.Status)
+	}
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+)
+
+// CancelAutoRenew — отключить автопродление и забыть сохранённый способ оплаты.
+// Доступно владельцу семьи и тому, чей способ оплаты сохранён. Оплаченный период не меняется.
+func CancelAutoRenew(c *fiber.Ctx) error {
+	user := middleware.Auth(c).User
+	if user.FamilyID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
+	}
+
+	var sub models.FamilySubscription
+	if err := config.DB.Where("family_id = ?", user.FamilyID).First(&sub).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Подписка не найдена"})
+	}
+	var family models.Family
+	if err := config.DB.First(&family, user.FamilyID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+	isPayer := sub.AutoRenewUserID != nil && *sub.AutoRenewUserID == user.ID
+	if family.OwnerID != user.ID && !isPayer {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Отключить автопродление может владелец семьи или плательщик"})
+	}
+
+	if err := config.DB.Model(&sub).Updates(map[string]interface{}{
+		"auto_renew":           false,
+		"auto_renew_user_id":   nil,
+		"payment_method_id":    "",
+		"payment_method_title": "",
+		"renewal_attempts":     0,
+		"next_renewal_at":      nil,
+		"last_renewal_error":   "",
+	}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+	return c.JSON(fiber.Map{"message": "Автопродление отключено", "end_date": sub.EndDate})
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+)
+
+/*────────────────────────── WebSocket по сессиям ─────────────*/
+
+type socketOwner struct {
+	UserID    uint
+	SessionID string
+}
+
+var (
+	// sessionSockets — все открытые WebSocket (чат и поддержка) и сессии, с которыми они открыты
+	sessionSockets   = make(map[*websocket.Conn]socketOwner)
+	sessionSocketsMu sync.Mutex
+)
+
+func trackSocket(conn *websocket.Conn, userID uint, sessionID string) {
+	sessionSocketsMu.Lock()
+	sessionSockets[conn] = socketOwner{UserID: userID, SessionID: sessionID}
+	sessionSocketsMu.Unlock()
+}
+
+func untrackSocket(conn *websocket.Conn) {
+	sessionSocketsMu.Lock()
+	delete(sessionSockets, conn)
+	sessionSocketsMu.Unlock()
+}
+
+// closeSockets закрывает соединения, подходящие под match. Цикл чтения
+// в обработчике получит ошибку и сам уберёт соединение из комнат.
+func closeSockets(match func(socketOwner) bool) {
+	sessionSocketsMu.Lock()
+	defer sessionSocketsMu.Unlock()
+	for conn, owner := range sessionSockets {
+		if match(owner) {
+			msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session revoked")
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			_ = conn.Close()
+			delete(sessionSockets, conn)
+		}
+	}
+}
+
+// disconnectSession закрывает WebSocket, открытые с access-токенами сессии.
+func disconnectSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	closeSockets(func(o socketOwner) bool { return o.SessionID == sessionID })
+}
+
+// disconnectUser закрывает все WebSocket пользователя, кроме сессии exceptSessionID.
+func disconnectUser(userID uint, exceptSessionID string) {
+	closeSockets(func(o socketOwner) bool {
+		return o.UserID == userID && (exceptSessionID == "" || o.SessionID != exceptSessionID)
+	})
+}
+
+/*────────────────────────── HTTP: сессии пользователя ────────*/
+
+// currentSessionID — сессия, с которой выдан access-токен запроса.
+func currentSessionID(c *fiber.Ctx) string {
+	if auth := middleware.Auth(c); auth != nil {
+		return auth.SessionID()
+	}
+	return ""
+}
+
+// revokeUserSessions удаляет refresh-токены всех сессий пользователя, кроме exceptSessionID,
+// и закрывает их WebSocket.
+func revokeUserSessions(userID uint, exceptSessionID string) error {
+	query := config.DB.Unscoped().Where("user_id = ?", userID)
+	if exceptSessionID != "" {
+		query = query.Where("session_id <> ?", exceptSessionID)
+	}
+	if err := query.Delete(&models.Token{}).Error; err != nil {
+		return err
+	}
+	disconnectUser(userID, exceptSessionID)
+	return nil
+}
+
+// ListSessions возвращает активные сессии (устройства) текущего пользователя.
+func ListSessions(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	// Действующий токен сессии — последний в цепочке, ещё не заменённый новым
+	var tokens []models.Token
+	if err := config.DB.
+		Where("user_id = ? AND rotated_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&tokens).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки сессий"})
+	}
+
+	current := currentSessionID(c)
+	out := make([]fiber.Map, 0, len(tokens))
+	for _, t := range tokens {
+		out = append(out, fiber.Map{
+			"session_id":   t.SessionID,
+			"user_agent":   t.UserAgent,
+			"ip":           t.IP,
+			"started_at":   t.StartedAt,
+			"last_used_at": t.LastUsedAt,
+			"expires_at":   t.ExpiresAt,
+			"current":      t.SessionID != "" && t.SessionID == current,
+		})
+	}
+	return c.JSON(out)
+}
+
+// RevokeSession завершает одну сессию пользователя.
+func RevokeSession(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	sessionID := c.Params("id")
+	var token models.Token
+	if err := config.DB.Where("user_id = ? AND session_id = ?", user.ID, sessionID).First(&token).Error; err != nil || sessionID == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Сессия не найдена"})
+	}
+
+	if err := revokeRefreshSession(token); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка завершения сессии"})
+	}
+	if sessionID == currentSessionID(c) {
+		clearRefreshCookie(c)
+	}
+	return c.JSON(fiber.Map{"message": "Сессия завершена"})
+}
+
+// LogoutEverywhere завершает все сессии пользователя, включая текущую.
+func LogoutEverywhere(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	if err := revokeUserSessions(user.ID, ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка завершения сессий"})
+	}
+	clearRefreshCookie(c)
+	return c.JSON(fiber.Map{"message": "Вы вышли на всех устройствах"})
+}
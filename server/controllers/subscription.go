@@ -1,235 +1,421 @@
 package controllers
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"diplom/billing"
 	"diplom/config"
+	"diplom/middleware"
 	"diplom/models"
+	"diplom/payments"
 )
 
-// DTO для запроса к YooKassa
-type CreatePaymentRequest struct {
-	Amount struct {
-		Value    string `json:"value"`
-		Currency string `json:"currency"`
-	} `json:"amount"`
-	Confirmation struct {
-		Type      string `json:"type"`
-		ReturnURL string `json:"return_url"`
-	} `json:"confirmation"`
-	Capture     bool   `json:"capture"`
-	Description string `json:"description"`
+// paymentReturnURL — страница клиента, куда провайдер возвращает пользователя после оплаты
+func paymentReturnURL() string {
+	return os.Getenv("CLIENT_URL") + "/dashboard/payment-success"
 }
 
-// DTO для ответа от YooKassa
-type CreatePaymentResponse struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
-	Amount struct {
-		Value    string `json:"value"`
-		Currency string `json:"currency"`
-	} `json:"amount"`
-	Confirmation struct {
-		Type            string `json:"type"`
-		ConfirmationURL string `json:"confirmation_url"`
-	} `json:"confirmation"`
-	Paid        bool   `json:"paid"`
-	CreatedAt   string `json:"created_at"`
-	Description string `json:"description"`
+// BuySubscriptionInput — выбранный план (GET /subscription/plans)
+// save_payment_method — сохранить способ оплаты и продлевать подписку автоматически
+// promo_code — промокод на скидку (действует на этот платёж, автопродление — по полной цене)
+type BuySubscriptionInput struct {
+	PlanID            uint   `json:"plan_id"`
+	SavePaymentMethod bool   `json:"save_payment_method"`
+	PromoCode         string `json:"promo_code"`
+}
+
+// ListPlans — публичный каталог планов подписки
+func ListPlans(c *fiber.Ctx) error {
+	var plans []models.Plan
+	if err := config.DB.Where("is_active = ?", true).Order("sort_order, id").Find(&plans).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки планов"})
+	}
+	return c.JSON(plans)
 }
 
 // BuySubscription — инициация платежа
 func BuySubscription(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
 	// Находим пользователя
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
-	}
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Нет семьи"})
 	}
 
-	// Сумма
-	amountStr := os.Getenv("PAYMENT_AMOUNT")
-	if amountStr == "" {
-		amountStr = "300"
+	// План: по plan_id, без него — месячный (как раньше)
+	var input BuySubscriptionInput
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+		}
 	}
-	valFloat, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		valFloat = 199.0
+	var plan models.Plan
+	query := config.DB.Where("is_active = ?", true)
+	if input.PlanID != 0 {
+		query = query.Where("id = ?", input.PlanID)
+	} else {
+		query = query.Where("code = ?", billing.DefaultPlanCode)
 	}
-	finalSum := fmt.Sprintf("%.2f", valFloat)
-
-	// Формируем тело запроса
-	reqBody := CreatePaymentRequest{
-		Capture:     true,
-		Description: fmt.Sprintf("Подписка на 1 месяц для семьи #%d", user.FamilyID),
+	if err := query.First(&plan).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "План не найден"})
 	}
-	reqBody.Amount.Value = finalSum
-	reqBody.Amount.Currency = "RUB"
-	reqBody.Confirmation.Type = "redirect"
-	reqBody.Confirmation.ReturnURL = os.Getenv("CLIENT_URL") + "/dashboard/payment-success"
 
-	jsonData, _ := json.Marshal(reqBody)
-
-	yoykassaURL := "https://api.yookassa.ru/v3/payments"
-	httpReq, err := http.NewRequest("POST", yoykassaURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Ошибка формирования запроса"})
-	}
-
-	shopID := os.Getenv("YOO_KASSA_SHOP_ID")
-	secretKey := os.Getenv("YOO_KASSA_SECRET_KEY")
-	httpReq.SetBasicAuth(shopID, secretKey)
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	// Уникальный ключ для идемпотентности
+	// Промокод занимается и запись Payment сохраняется в одной короткой транзакции: ReservePromo
+	// блокирует строку семьи до записи Payment, поэтому параллельные покупки не применят один
+	// промокод дважды. Запрос к провайдеру идёт уже после неё, чтобы медленный провайдер не держал
+	// соединение с БД и блокировку семьи. Пока провайдер не ответил, у записи временный payment_id
+	amount := plan.Price
+	var discount string
+	provider := payments.Default()
 	idempotenceKey := uuid.New().String()
-	httpReq.Header.Set("Idempotence-Key", idempotenceKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Ошибка при запросе к YooKassa"})
+	var receipt *payments.Receipt
+	payment := models.Payment{
+		PaymentID: "local-" + idempotenceKey,
+		Provider:  provider.Name(),
+		FamilyID:  user.FamilyID,
+		UserID:    user.ID,
+		PlanID:    &plan.ID,
+		Currency:  plan.Currency,
+		Status:    payments.StatusPending,
 	}
-	defer resp.Body.Close()
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if input.PromoCode != "" {
+			promo, price, off, err := billing.ReservePromo(tx, input.PromoCode, plan, user.FamilyID, time.Now())
+			if err != nil {
+				return err
+			}
+			amount, discount = price, off
+			payment.PromoCodeID = &promo.ID
+		}
 
-	if resp.StatusCode != 200 && resp.StatusCode != 202 {
-		var failBody map[string]interface{}
-		json.NewDecoder(resp.Body).Decode(&failBody)
-		return c.Status(500).JSON(fiber.Map{
-			"error":  "YooKassa error",
-			"detail": failBody,
-		})
+		// Чек (54-ФЗ) — на сумму со скидкой, на email покупателя
+		receipt = billing.NewReceipt(user.Email, fmt.Sprintf("Подписка «%s»", plan.Name), amount, plan.Currency)
+		payment.Amount = amount
+		payment.Discount = discount
+		billing.AttachReceipt(&payment, receipt)
+		if err := tx.Create(&payment).Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Ошибка сохранения Payment")
+		}
+		return nil
+	})
+	var ferr *fiber.Error
+	if errors.As(err, &ferr) {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
 	}
-
-	var payResp CreatePaymentResponse
-	if err := json.NewDecoder(resp.Body).Decode(&payResp); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Ошибка парсинга ответа YooKassa"})
+	if err != nil {
+		return promoError(c, err)
 	}
 
-	confirmationURL := payResp.Confirmation.ConfirmationURL
+	payResp, err := provider.CreatePayment(c.Context(), payments.CreatePaymentRequest{
+		Amount:      payments.Amount{Value: amount, Currency: plan.Currency},
+		Description: fmt.Sprintf("Подписка «%s» для семьи #%d", plan.Name, user.FamilyID),
+		ReturnURL:   paymentReturnURL(),
+		Metadata:    map[string]string{"family_id": strconv.Itoa(int(user.FamilyID)), "plan": plan.Code},
+		// Уникальный ключ для идемпотентности
+		IdempotenceKey:    idempotenceKey,
+		SavePaymentMethod: input.SavePaymentMethod,
+		Receipt:           receipt,
+	})
+	if err != nil {
+		log.Printf("Ошибка создания платежа у провайдера %s: %v\n", provider.Name(), err)
+		discardPendingPayment(payment)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Ошибка при запросе к платёжному сервису"})
+	}
+	confirmationURL := payResp.ConfirmationURL
 	if confirmationURL == "" {
-		return c.Status(500).JSON(fiber.Map{"error": "Не удалось получить ссылку на оплату"})
+		discardPendingPayment(payment)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Не удалось получить ссылку на оплату"})
 	}
 
-	// Сохраняем запись Payment
-	payment := models.Payment{
-		PaymentID: payResp.ID,
-		FamilyID:  user.FamilyID,
-		UserID:    user.ID,
-		Amount:    finalSum,
-		Status:    payResp.Status, // обычно "pending"
-	}
-	if err := config.DB.Create(&payment).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Ошибка сохранения Payment",
-		})
+	// Запись получает ID платежа у провайдера — по нему придёт уведомление
+	if err := config.DB.Model(&payment).Updates(map[string]interface{}{
+		"payment_id":     payResp.ID,
+		"status":         payResp.Status, // обычно "pending"
+		"receipt_status": payResp.ReceiptStatus,
+	}).Error; err != nil {
+		log.Printf("Ошибка сохранения платежа %s: %v\n", payResp.ID, err)
+		discardPendingPayment(payment)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения Payment"})
 	}
 
 	// Возвращаем ссылку фронту
 	return c.JSON(fiber.Map{
 		"payment_url": confirmationURL,
+		"amount":      amount,
+		"discount":    discount,
 	})
 }
 
-// YooKassaWebhook — публичный endpoint
-func YooKassaWebhook(c *fiber.Ctx) error {
-	type YooKassaCallback struct {
-		Object struct {
-			ID     string `json:"id"`
-			Status string `json:"status"`
-		} `json:"object"`
+// discardPendingPayment удаляет запись покупки, для которой провайдер не создал платёж,
+// и возвращает занятое ею использование промокода.
+func discardPendingPayment(payment models.Payment) {
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&payment).Error; err != nil {
+			return err
+		}
+		if payment.PromoCodeID != nil {
+			return billing.ReleasePromo(tx, *payment.PromoCodeID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Ошибка отмены покупки %s: %v\n", payment.PaymentID, err)
 	}
+}
+
+// errEventProcessed — уведомление с таким EventKey уже обработано
+var errEventProcessed = errors.New("event already processed")
 
-	var cb YooKassaCallback
-	if err := c.BodyParser(&cb); err != nil {
+// errPaymentMismatch — платёж у провайдера не совпадает с нашей записью (сумма, валюта)
+var errPaymentMismatch = errors.New("payment mismatch")
+
+// PaymentWebhook — публичный endpoint для уведомлений платёжного провайдера.
+// Телу уведомления не доверяем: провайдер проверяет источник, затем платёж запрашивается
+// у провайдера и применяется статус оттуда. Каждое событие применяется один раз.
+func PaymentWebhook(c *fiber.Ctx) error {
+	provider := payments.Default()
+
+	n, err := provider.ParseWebhook(c.Body(), c.IP())
+	if errors.Is(err, payments.ErrUntrustedSource) {
+		log.Printf("Уведомление о платеже с недоверенного адреса %s отклонено\n", c.IP())
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "forbidden"})
+	}
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad json"})
 	}
+	log.Printf("Webhook from %s: payment_id=%s, event=%s\n", provider.Name(), n.PaymentID, n.Event)
 
-	paymentID := cb.Object.ID
-	status := cb.Object.Status
-	log.Printf("Webhook from YooKassa: payment_id=%s, status=%s\n", paymentID, status)
+	apply := applyPaymentNotification
+	if n.RefundID != "" {
+		apply = applyRefundNotification
+	}
+	switch err := apply(c.Context(), provider, n); {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, payments.ErrPaymentNotFound), errors.Is(err, payments.ErrRefundNotFound):
+		return c.JSON(fiber.Map{"message": "payment not found"})
+	case errors.Is(err, errPaymentMismatch):
+		return c.JSON(fiber.Map{"message": "amount mismatch"})
+	case errors.Is(err, errEventProcessed):
+		return c.JSON(fiber.Map{"message": "ok"})
+	case err != nil:
+		// Не 200 — провайдер повторит уведомление позже
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "cannot process notification"})
+	}
+	return c.JSON(fiber.Map{"message": "ok"})
+}
 
-	// Ищем Payment в своей БД
+// applyPaymentNotification запрашивает актуальный платёж у провайдера и применяет его статус:
+// обновляет Payment и при успешной оплате активирует подписку семьи.
+func applyPaymentNotification(ctx context.Context, provider payments.Provider, n *payments.Notification) error {
+	// 1. Ищем Payment в своей БД
 	var payment models.Payment
-	if err := config.DB.Where("payment_id = ?", paymentID).First(&payment).Error; err != nil {
-		log.Println("Payment not found:", paymentID)
-		return c.JSON(fiber.Map{"message": "payment not found"})
+	if err := config.DB.Where("payment_id = ?", n.PaymentID).First(&payment).Error; err != nil {
+		log.Println("Payment not found:", n.PaymentID)
+		return err
 	}
 
-	// Обновляем статус
-	payment.Status = status
-	config.DB.Save(&payment)
+	// 2. Актуальное состояние платежа — от провайдера
+	remote, err := provider.GetPayment(ctx, n.PaymentID)
+	if errors.Is(err, payments.ErrPaymentNotFound) {
+		log.Printf("Платёж %s не найден у провайдера, уведомление проигнорировано\n", n.PaymentID)
+		return err
+	}
+	if err != nil {
+		log.Printf("Не удалось проверить платёж %s у провайдера: %v\n", n.PaymentID, err)
+		return err
+	}
+	// Суммы сравниваются по значению: цена плана может быть записана как "300" или "300.0"
+	if !payments.SameAmount(remote.Amount.Value, payment.Amount) || remote.Amount.Currency != payment.Currency {
+		log.Printf("ОШИБКА: сумма платежа %s не совпадает, платёж не применён: у провайдера %s %s, у нас %s %s\n",
+			n.PaymentID, remote.Amount.Value, remote.Amount.Currency, payment.Amount, payment.Currency)
+		return errPaymentMismatch
+	}
+	// Регистрация чека идёт отдельно от оплаты — статус сохраняем при каждом уведомлении
+	if remote.ReceiptStatus != "" && remote.ReceiptStatus != payment.ReceiptStatus {
+		config.DB.Model(&payment).Update("receipt_status", remote.ReceiptStatus)
+	}
 
-	if status == "succeeded" {
-		// Активируем подписку
-		var sub models.FamilySubscription
-		err := config.DB.Where("family_id = ?", payment.FamilyID).First(&sub).Error
+	// 3. Применяем статус один раз на событие
+	event := models.PaymentEvent{
+		EventKey:  "payment." + remote.Status + ":" + n.PaymentID,
+		PaymentID: n.PaymentID,
+		Event:     n.Event,
+		Status:    remote.Status,
+		SourceIP:  n.SourceIP,
+	}
+	var sub models.FamilySubscription
+	activated := false
+	revoked := false
+	renewalFailed := false
+	giftPaid := false
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errEventProcessed
+		}
 
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// Если записи нет — создаём новую
-			sub = models.FamilySubscription{
-				FamilyID:  payment.FamilyID,
-				IsActive:  true,
-				StartDate: time.Now(),
-				EndDate:   time.Now().AddDate(0, 1, 0),
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, payment.ID).Error; err != nil {
+			return err
+		}
+		prevStatus := payment.Status
+		if prevStatus == payments.StatusRefunded {
+			return nil // возвращённый платёж больше не меняется
+		}
+		if err := tx.Model(&payment).Update("status", remote.Status).Error; err != nil {
+			return err
+		}
+		if remote.Status == payments.StatusCanceled && prevStatus != payments.StatusCanceled && payment.Recurring {
+			renewalFailed = true
+		}
+		if remote.Status == payments.StatusCanceled && prevStatus != payments.StatusCanceled && prevStatus != payments.StatusSucceeded {
+			// Платёж не состоялся: освобождаем промокод и аннулируем неоплаченный подарок
+			if payment.PromoCodeID != nil {
+				if err := billing.ReleasePromo(tx, *payment.PromoCodeID); err != nil {
+					return err
+				}
+			}
+			if payment.GiftCodeID != nil {
+				if err := tx.Model(&models.GiftCode{}).Where("id = ?", *payment.GiftCodeID).
+					Update("status", billing.GiftCanceled).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if remote.Status == payments.StatusCanceled && prevStatus == payments.StatusSucceeded {
+			// Оплата отменена после зачисления — забираем оплаченное ею время
+			total, _ := payments.ParseAmount(payment.Amount)
+			s, err := billing.RevokePaidTime(tx, payment, total, total, time.Now())
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
 			}
-			config.DB.Create(&sub)
-		} else if err != nil {
-			// Если другая ошибка БД — логируем, но не возвращаем 500 клиенту
-			log.Printf("Ошибка при получении подписки: %v\n", err)
-		} else {
-			// Обновляем существующую
-			sub.IsActive = true
-			sub.StartDate = time.Now()
-			sub.EndDate = time.Now().AddDate(0, 1, 0)
-			config.DB.Save(&sub)
+			sub, revoked = s, err == nil
+			return nil
+		}
+		if remote.Status != payments.StatusSucceeded || prevStatus == payments.StatusSucceeded {
+			return nil
+		}
+
+		// Оплачен подарок: код становится доступен для активации, своя подписка не меняется
+		if payment.GiftCodeID != nil {
+			giftPaid = true
+			return tx.Model(&models.GiftCode{}).Where("id = ? AND status = ?", *payment.GiftCodeID, billing.GiftPending).
+				Update("status", billing.GiftPaid).Error
 		}
 
-		log.Printf("Подписка активирована для FamilyID=%d\n", payment.FamilyID)
+		// Продлеваем подписку: новый период — с окончания текущего
+		var err error
+		sub, _, err = billing.ExtendSubscription(tx, payment.FamilyID, payment.PlanID, &payment.ID, time.Now())
+		if err != nil {
+			return err
+		}
+
+		// Оплата прошла — сбрасываем неудачные попытки автопродления;
+		// если способ оплаты сохранён, включаем автопродление с ним
+		updates := map[string]interface{}{
+			"renewal_attempts":   0,
+			"next_renewal_at":    nil,
+			"last_renewal_error": "",
+		}
+		if remote.PaymentMethodSaved && remote.PaymentMethodID != "" {
+			updates["auto_renew"] = true
+			updates["auto_renew_user_id"] = payment.UserID
+			updates["payment_method_id"] = remote.PaymentMethodID
+			updates["payment_method_title"] = remote.PaymentMethodTitle
+		}
+		if err := tx.Model(&sub).Updates(updates).Error; err != nil {
+			return err
+		}
+		activated = true
+		return nil
+	})
+	if errors.Is(err, errEventProcessed) {
+		log.Printf("Уведомление %s уже обработано\n", event.EventKey)
+		return err
+	}
+	if err != nil {
+		log.Printf("Ошибка обработки уведомления %s: %v\n", event.EventKey, err)
+		return err
 	}
 
-	return c.JSON(fiber.Map{"message": "ok"})
+	if renewalFailed {
+		billing.RegisterRenewalFailure(config.DB, payment.FamilyID, payment.Amount, remote.CancellationReason)
+	}
+	if giftPaid {
+		sendGiftCode(*payment.GiftCodeID)
+	}
+	if revoked {
+		notifySubscriptionChange(sub, payment.UserID, ActivitySubscriptionRefunded,
+			"Платёж отменён, "+subscriptionSummary(sub))
+	}
+	if activated {
+		log.Printf("Подписка семьи FamilyID=%d продлена до %s\n", payment.FamilyID, sub.EndDate.Format("02.01.2006"))
+		notifySubscriptionChange(sub, payment.UserID, ActivitySubscriptionActivated,
+			"Подписка активна до "+sub.EndDate.Format("02.01.2006"))
+	}
+	return nil
 }
 
-// CheckSubscription — смотрим, есть ли активная подписка
-func CheckSubscription(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
+// FakeCheckout — страница оплаты тестового провайдера (PAYMENT_PROVIDER=fake).
+// ?status=canceled отменяет платёж, иначе он считается оплаченным. Дальше — тот же путь,
+// что у уведомления от настоящего провайдера, и редирект на страницу клиента.
+func FakeCheckout(c *fiber.Ctx) error {
+	fake, ok := payments.Default().(*payments.Fake)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Not found"})
 	}
-	userID := uint(claims["user_id"].(float64))
 
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Пользователь не найден"})
+	status := payments.StatusSucceeded
+	if c.Query("status") == payments.StatusCanceled {
+		status = payments.StatusCanceled
+	}
+	p, err := fake.Complete(c.Params("id"), status)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Платёж не найден"})
 	}
+
+	err = applyPaymentNotification(c.Context(), fake, &payments.Notification{
+		Event:     "payment." + p.Status,
+		PaymentID: p.ID,
+		SourceIP:  c.IP(),
+	})
+	if err != nil && !errors.Is(err, errEventProcessed) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка обработки платежа"})
+	}
+	return c.Redirect(paymentReturnURL())
+}
+
+// CheckSubscription — смотрим, есть ли активная подписка
+func CheckSubscription(c *fiber.Ctx) error {
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
+	}
+
+	user := auth.User
 	if user.FamilyID == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
 	}
 
 	var sub models.FamilySubscription
 	err := config.DB.
+		Preload("Plan").
 		Where("family_id = ?", user.FamilyID).
 		First(&sub).
 		Error
@@ -243,7 +429,53 @@ func CheckSubscription(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
 	}
 
-	now := time.Now()
-	isActive := sub.IsActive && now.Before(sub.EndDate)
-	return c.JSON(fiber.Map{"isActive": isActive})
-}
\ No newline at end of file
+	// В льготный срок после EndDate подписка ещё действует
+	state := billing.State(sub, time.Now())
+	return c.JSON(fiber.Map{"isActive": state != billing.StateExpired, "state": state,
+		"plan": sub.Plan, "endDate": sub.EndDate, "graceUntil": billing.GraceUntil(sub),
+		"autoRenew": sub.AutoRenew, "paymentMethod": sub.PaymentMethodTitle})
+}
+
+// GetSubscriptionHistory — оплаченные периоды подписки семьи текущего пользователя, от новых к старым
+func GetSubscriptionHistory(c *fiber.Ctx) error {
+	user := middleware.Auth(c).User
+	if user.FamilyID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
+	}
+
+	var periods []models.SubscriptionPeriod
+	if err := config.DB.
+		Preload("Plan").
+		Preload("Payment").
+		Where("family_id = ?", user.FamilyID).
+		Order("start_date DESC").
+		Find(&periods).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+	return c.JSON(periods)
+}
+
+// StartTrial — бесплатный пробный период для семьи, у которой ещё не было подписки.
+func StartTrial(c *fiber.Ctx) error {
+	user := middleware.Auth(c).User
+	if user.FamilyID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "У пользователя нет семьи"})
+	}
+
+	var sub models.FamilySubscription
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		sub, _, err = billing.StartTrial(tx, user.FamilyID, time.Now())
+		return err
+	})
+	if errors.Is(err, billing.ErrTrialUnavailable) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка базы данных"})
+	}
+
+	notifySubscriptionChange(sub, user.ID, ActivitySubscriptionTrial,
+		"Пробный период до "+sub.EndDate.Format("02.01.2006"))
+	return c.JSON(fiber.Map{"message": "Пробный период активирован", "end_date": sub.EndDate})
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"diplom/billing"
+	"diplom/models"
+	"diplom/payments"
+)
+
+// SubscriptionNotifier передаёт события фоновых задач подписок (billing) в ленту семьи
+// и WebSocket, а итог автосписания — в обработку уведомлений о платежах.
+type SubscriptionNotifier struct{}
+
+// Типы записей ленты для событий фоновых задач
+var subscriptionEventActivity = map[string]string{
+	billing.EventPlanChanged: ActivitySubscriptionPlan,
+	billing.EventGrace:       ActivitySubscriptionGrace,
+	billing.EventExpired:     ActivitySubscriptionExpired,
+}
+
+func (SubscriptionNotifier) SubscriptionChanged(sub models.FamilySubscription, event, summary string) {
+	notifySubscriptionChange(sub, 0, subscriptionEventActivity[event], summary)
+}
+
+func (SubscriptionNotifier) PaymentFinished(ctx context.Context, provider payments.Provider, n *payments.Notification) error {
+	return applyPaymentNotification(ctx, provider, n)
+}
+
+// notifySubscriptionChange записывает изменение подписки в ленту семьи и рассылает
+// новое состояние по семейному WebSocket. actorID = 0 — событие системы.
+func notifySubscriptionChange(sub models.FamilySubscription, actorID uint, typ, summary string) {
+	recordActivity(sub.FamilyID, actorID, typ, &sub.ID, summary)
+	broadcastSubscription(sub.FamilyID, subscriptionState{
+		State:      billing.State(sub, time.Now()),
+		EndDate:    sub.EndDate,
+		GraceUntil: billing.GraceUntil(sub),
+		PlanID:     sub.PlanID,
+		AutoRenew:  sub.AutoRenew,
+	})
+}
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/billing"
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/payments"
+)
+
+// Полный путь покупки с тестовым провайдером: BuySubscription → оплата на странице провайдера
+// (Fake.Complete) → уведомление → подписка семьи активна до конца оплаченного плана.
+func TestBuySubscriptionWithFakeProvider(t *testing.T) {
+	setupTestDB(t)
+	if err := billing.SeedPlans(config.DB); err != nil {
+		t.Fatal(err)
+	}
+	fake := payments.NewFake()
+	payments.SetDefault(fake)
+
+	family, owner := createTestFamily(t, "owner@example.com")
+	var plan models.Plan
+	if err := config.DB.Where("code = ?", "yearly").First(&plan).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/api/subscription/buy", middleware.JWTProtected(), BuySubscription)
+
+	body := `{"plan_id":` + strconv.Itoa(int(plan.ID)) + `}`
+	req := httptest.NewRequest(http.MethodPost, "/api/subscription/buy", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, owner))
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		PaymentURL string `json:"payment_url"`
+		Amount     string `json:"amount"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != fiber.StatusOK || out.Amount != plan.Price {
+		t.Fatalf("покупка: статус %d, сумма %q", resp.StatusCode, out.Amount)
+	}
+
+	var payment models.Payment
+	if err := config.DB.Where("family_id = ?", family.ID).First(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != payments.StatusPending || payment.PlanID == nil || *payment.PlanID != plan.ID {
+		t.Fatalf("платёж: статус %q, план %v", payment.Status, payment.PlanID)
+	}
+	if out.PaymentURL != fake.CheckoutURL+"/"+payment.PaymentID {
+		t.Errorf("ссылка на оплату %q", out.PaymentURL)
+	}
+
+	// Пока платёж не оплачен, уведомление ничего не активирует
+	n := &payments.Notification{Event: "payment.succeeded", PaymentID: payment.PaymentID}
+	if err := applyPaymentNotification(context.Background(), fake, n); err != nil {
+		t.Fatalf("уведомление до оплаты: %v", err)
+	}
+	var subs int64
+	config.DB.Model(&models.FamilySubscription{}).Where("family_id = ? AND is_active = ?", family.ID, true).Count(&subs)
+	if subs != 0 {
+		t.Fatal("подписка активна до оплаты")
+	}
+
+	paidAt := time.Now()
+	if _, err := fake.Complete(payment.PaymentID, payments.StatusSucceeded); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPaymentNotification(context.Background(), fake, n); err != nil {
+		t.Fatalf("уведомление после оплаты: %v", err)
+	}
+
+	config.DB.First(&payment, payment.ID)
+	if payment.Status != payments.StatusSucceeded {
+		t.Errorf("статус платежа %q", payment.Status)
+	}
+	var sub models.FamilySubscription
+	if err := config.DB.Where("family_id = ?", family.ID).First(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := paidAt.AddDate(0, plan.DurationMonths, 0)
+	if !sub.IsActive || sub.PlanID == nil || *sub.PlanID != plan.ID {
+		t.Errorf("подписка: active=%v, план %v", sub.IsActive, sub.PlanID)
+	}
+	if sub.EndDate.Before(want.Add(-time.Minute)) || sub.EndDate.After(want.Add(time.Minute)) {
+		t.Errorf("подписка до %v, ожидалось %v", sub.EndDate, want)
+	}
+}
+
+// Автопродление по карте участника отключается, когда он уходит из семьи.
+func TestLeaveFamilyClearsRenewalPayer(t *testing.T) {
+	setupTestDB(t)
+	family, _ := createTestFamily(t, "owner@example.com")
+	payer := createTestUser(t, "payer@example.com", family.ID)
+	other := createTestUser(t, "other@example.com", family.ID)
+
+	sub := models.FamilySubscription{
+		FamilyID: family.ID, IsActive: true, EndDate: time.Now().AddDate(0, 1, 0),
+		AutoRenew: true, AutoRenewUserID: &payer.ID, PaymentMethodID: "pm-1", PaymentMethodTitle: "Card *4242",
+	}
+	if err := config.DB.Create(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := leaveFamily(config.DB, other); err != nil {
+		t.Fatal(err)
+	}
+	config.DB.First(&sub, sub.ID)
+	if !sub.AutoRenew || sub.PaymentMethodID != "pm-1" {
+		t.Fatal("уход другого участника отключил автопродление")
+	}
+
+	if err := leaveFamily(config.DB, payer); err != nil {
+		t.Fatal(err)
+	}
+	var after models.FamilySubscription
+	if err := config.DB.First(&after, sub.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if after.AutoRenew || after.AutoRenewUserID != nil || after.PaymentMethodID != "" {
+		t.Errorf("после ухода плательщика: auto_renew=%v, плательщик %v, способ %q",
+			after.AutoRenew, after.AutoRenewUserID, after.PaymentMethodID)
+	}
+	if !after.IsActive || !after.EndDate.Equal(sub.EndDate) {
+		t.Error("оплаченный период изменился")
+	}
+}
+
+// План, купленный при действующей подписке, вступает в силу с начала своего периода.
+func TestPlanSwitchesWhenPrepaidPeriodStarts(t *testing.T) {
+	setupTestDB(t)
+	if err := billing.SeedPlans(config.DB); err != nil {
+		t.Fatal(err)
+	}
+	var monthly, yearly models.Plan
+	config.DB.Where("code = ?", "monthly").First(&monthly)
+	config.DB.Where("code = ?", "yearly").First(&yearly)
+	family, _ := createTestFamily(t, "owner@example.com")
+
+	now := time.Now()
+	if _, _, err := billing.ExtendSubscription(config.DB, family.ID, &monthly.ID, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	sub, _, err := billing.ExtendSubscription(config.DB, family.ID, &yearly.ID, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.PlanID == nil || *sub.PlanID != monthly.ID {
+		t.Fatalf("план сменился до начала оплаченного периода: %v", sub.PlanID)
+	}
+
+	if _, err := billing.AdvancePlan(config.DB, &sub, now); err != nil {
+		t.Fatal(err)
+	}
+	config.DB.First(&sub, sub.ID)
+	if *sub.PlanID != monthly.ID {
+		t.Fatalf("план сменился, пока идёт месячный период")
+	}
+
+	if _, err := billing.AdvancePlan(config.DB, &sub, now.AddDate(0, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	config.DB.First(&sub, sub.ID)
+	if *sub.PlanID != yearly.ID {
+		t.Errorf("после начала годового периода план %d, ожидался %d", *sub.PlanID, yearly.ID)
+	}
+}
+
+// Промокод занимается вместе с созданием платежа: второй раз семья его не применит,
+// а неудачная покупка возвращает использование.
+func TestBuySubscriptionReservesPromoOncePerFamily(t *testing.T) {
+	setupTestDB(t)
+	if err := billing.SeedPlans(config.DB); err != nil {
+		t.Fatal(err)
+	}
+	payments.SetDefault(payments.NewFake())
+	promo := models.PromoCode{Code: "SALE10", DiscountPercent: 10, IsActive: true}
+	config.DB.Create(&promo)
+	_, owner := createTestFamily(t, "owner@example.com")
+
+	app := fiber.New()
+	app.Post("/api/subscription/buy", middleware.JWTProtected(), BuySubscription)
+	buy := func() (int, string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/subscription/buy", strings.NewReader(`{"promo_code":"sale10"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, owner))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out struct {
+			Amount string `json:"amount"`
+		}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out.Amount
+	}
+
+	if status, amount := buy(); status != fiber.StatusOK || amount != "270.00" {
+		t.Fatalf("покупка с промокодом: статус %d, сумма %q", status, amount)
+	}
+	if status, _ := buy(); status != fiber.StatusBadRequest {
+		t.Errorf("повторное применение промокода семьёй: статус %d, ожидался 400", status)
+	}
+
+	// Провайдер недоступен — запись покупки удаляется, использование промокода возвращается
+	config.DB.Model(&models.Payment{}).Where("promo_code_id = ?", promo.ID).Update("status", payments.StatusCanceled)
+	newYooKassaStub(t)
+	if status, _ := buy(); status != fiber.StatusBadGateway {
+		t.Fatalf("покупка при ошибке провайдера: статус %d", status)
+	}
+	config.DB.First(&promo, promo.ID)
+	if promo.Redemptions != 1 {
+		t.Errorf("использований промокода %d, ожидалось 1", promo.Redemptions)
+	}
+	var pending int64
+	config.DB.Unscoped().Model(&models.Payment{}).Where("status = ?", payments.StatusPending).Count(&pending)
+	if pending != 0 {
+		t.Errorf("после ошибки провайдера осталось %d записей платежа", pending)
+	}
+}
+
+// Дополнительный календарь создаётся, только пока подписка с этой возможностью действует.
+func TestExtraCalendarRequiresSubscriptionFeature(t *testing.T) {
+	setupTestDB(t)
+	if err := billing.SeedPlans(config.DB); err != nil {
+		t.Fatal(err)
+	}
+	family, owner := createTestFamily(t, "owner@example.com")
+
+	app := fiber.New()
+	app.Post("/api/calendar/extra", middleware.JWTProtected(), CreateExtraCalendar)
+	create := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/calendar/extra", strings.NewReader(`{"title":"Работа"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+accessTokenFor(t, owner))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if s := create(); s != fiber.StatusPaymentRequired {
+		t.Fatalf("без подписки: статус %d, ожидался 402", s)
+	}
+
+	sub, _, err := billing.ExtendSubscription(config.DB, family.ID, nil, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := create(); s != fiber.StatusOK {
+		t.Fatalf("с подпиской: статус %d", s)
+	}
+
+	// Льготный срок прошёл — возможность закрывается
+	config.DB.Model(&sub).Update("end_date", time.Now().Add(-billing.GracePeriod-time.Hour))
+	if s := create(); s != fiber.StatusPaymentRequired {
+		t.Errorf("после окончания подписки: статус %d, ожидался 402", s)
+	}
+}
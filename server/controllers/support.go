@@ -4,16 +4,16 @@ import (
 	"encoding/json"
 	"io"
 	"log"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
-	"github.com/golang-jwt/jwt/v5"
 
 	"diplom/config"
+	"diplom/middleware"
 	"diplom/models"
+	"diplom/rbac"
 	"diplom/utils"
 )
 
@@ -24,7 +24,7 @@ var (
 
 type ticketClient struct {
 	UserID uint
-	Role   string
+	Staff  bool // право support:operate — такие участники показываются в присутствии операторов
 }
 
 var (
@@ -50,11 +50,11 @@ type ITicketInfo struct {
 
 // CreateTicket — создаёт новый тикет и первое сообщение
 func CreateTicket(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
+	userID := auth.UserID()
 
 	var input CreateTicketInput
 	if err := c.BodyParser(&input); err != nil {
@@ -89,11 +89,11 @@ func CreateTicket(c *fiber.Ctx) error {
 
 // GetMyTickets — возвращает список тикетов текущего пользователя
 func GetMyTickets(c *fiber.Ctx) error {
-	claims, ok := c.Locals("user").(jwt.MapClaims)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	userID := uint(claims["user_id"].(float64))
+	userID := auth.UserID()
 
 	var tickets []models.Ticket
 	if err := config.DB.
@@ -138,17 +138,15 @@ func GetTicketInfo(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Тикет не найден"})
 	}
 
-	// 2. Достаём JWT-claims
-	claimsAny := c.Locals("user")
-	if claimsAny == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Нет JWT claims"})
+	// 2. Текущий пользователь из middleware.JWTProtected
+	auth := middleware.Auth(c)
+	if auth == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 	}
-	claims := claimsAny.(jwt.MapClaims)
-	userID := uint(claims["user_id"].(float64))
-	role := claims["role"].(string)
+	userID := auth.UserID()
 
 	// 3. Проверка прав: пользователь — только свои тикеты; оператор — любой.
-	if role != "operator" && role != "admin" && ticket.UserID != userID {
+	if !auth.Can(rbac.SupportOperate) && ticket.UserID != userID {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Доступ запрещён"})
 	}
 
@@ -194,15 +192,14 @@ func GetTicketMessages(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Тикет не найден"})
 	}
 
-	claims, _ := c.Locals("user").(jwt.MapClaims)
-	userID := uint(claims["user_id"].(float64))
-	role := claims["role"].(string)
+	auth := middleware.Auth(c)
+	userID := auth.UserID()
 
 	isOwner := ticket.UserID == userID
-	isAssignedOperator := role == "operator" && ticket.OperatorID != nil && *ticket.OperatorID == userID
-	isAdmin := role == "admin"
+	isAssignedOperator := auth.Can(rbac.SupportOperate) && ticket.OperatorID != nil && *ticket.OperatorID == userID
+	canManage := auth.Can(rbac.SupportManage)
 
-	if !isOwner && !isAssignedOperator && !isAdmin {
+	if !isOwner && !isAssignedOperator && !canManage {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Доступ запрещён"})
 	}
 
@@ -218,12 +215,7 @@ func GetTicketMessages(c *fiber.Ctx) error {
 
 // ListOperatorTickets — возвращает список тикетов для оператора по статусу
 func ListOperatorTickets(c *fiber.Ctx) error {
-	claims, _ := c.Locals("user").(jwt.MapClaims)
-	role := claims["role"].(string)
-	if role != "operator" && role != "admin" {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Доступ только для операторов"})
-	}
-
+	// Право support:operate проверено в routes (middleware.RequirePermission)
 	status := c.Query("status", "new")
 	if status != "new" && status != "active" && status != "closed" {
 		status = "new"
@@ -256,12 +248,9 @@ func ListOperatorTickets(c *fiber.Ctx) error {
 
 // AssignTicket — оператор берёт тикет в работу
 func AssignTicket(c *fiber.Ctx) error {
-	claims, _ := c.Locals("user").(jwt.MapClaims)
-	role := claims["role"].(string)
-	if role != "operator" && role != "admin" {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Доступ только для операторов"})
-	}
-	operatorID := uint(claims["user_id"].(float64))
+	// Право support:operate проверено в routes (middleware.RequirePermission)
+	auth := middleware.Auth(c)
+	operatorID := auth.UserID()
 
 	ticketID, err := c.ParamsInt("id")
 	if err != nil {
@@ -288,12 +277,7 @@ func AssignTicket(c *fiber.Ctx) error {
 
 // CloseTicket — оператор (или admin) закрывает тикет
 func CloseTicket(c *fiber.Ctx) error {
-	claims, _ := c.Locals("user").(jwt.MapClaims)
-	role := claims["role"].(string)
-	if role != "operator" && role != "admin" {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Доступ только для операторов"})
-	}
-
+	// Право support:operate проверено в routes (middleware.RequirePermission)
 	ticketID, err := c.ParamsInt("id")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID тикета"})
@@ -333,14 +317,13 @@ func CreateTicketMessage(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Тикет не найден"})
 	}
 
-	claims, _ := c.Locals("user").(jwt.MapClaims)
-	userID := uint(claims["user_id"].(float64))
-	role := claims["role"].(string)
+	auth := middleware.Auth(c)
+	userID := auth.UserID()
 
 	isOwner := ticket.UserID == userID
-	isAssignedOperator := role == "operator" && ticket.OperatorID != nil && *ticket.OperatorID == userID
-	isAdmin := role == "admin"
-	if !isOwner && !isAssignedOperator && !isAdmin {
+	isAssignedOperator := auth.Can(rbac.SupportOperate) && ticket.OperatorID != nil && *ticket.OperatorID == userID
+	canManage := auth.Can(rbac.SupportManage)
+	if !isOwner && !isAssignedOperator && !canManage {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Доступ запрещён"})
 	}
 
@@ -350,7 +333,7 @@ func CreateTicketMessage(c *fiber.Ctx) error {
 	}
 
 	senderRole := "user"
-	if role == "operator" || role == "admin" {
+	if auth.Can(rbac.SupportOperate) {
 		senderRole = "operator"
 	}
 	msg := models.TicketMessage{
@@ -377,9 +360,8 @@ func CreateTicketMessage(c *fiber.Ctx) error {
 func DeleteTicketMessageHTTP(c *fiber.Ctx) error {
 	tid, _ := c.ParamsInt("id")
 	mid, _ := c.ParamsInt("msgId")
-	claims, _ := c.Locals("user").(jwt.MapClaims)
-	userID := uint(claims["user_id"].(float64))
-	role := claims["role"].(string)
+	auth := middleware.Auth(c)
+	userID := auth.UserID()
 
 	var msg models.TicketMessage
 	if err := config.DB.First(&msg, mid).Error; err != nil {
@@ -388,7 +370,7 @@ func DeleteTicketMessageHTTP(c *fiber.Ctx) error {
 	if msg.TicketID != uint(tid) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Сообщение не к этому тикету"})
 	}
-	if role != "operator" && role != "admin" && msg.SenderID != userID {
+	if !auth.Can(rbac.SupportOperate) && msg.SenderID != userID {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Нет доступа"})
 	}
 
@@ -401,24 +383,14 @@ func DeleteTicketMessageHTTP(c *fiber.Ctx) error {
 
 // SupportNewTicketsWS — WebSocket для уведомления операторов о новых тикетах
 func SupportNewTicketsWS(c *websocket.Conn) {
-	tokenStr := c.Query("token")
-	if tokenStr == "" {
-		c.Close()
-		return
-	}
-	secret := []byte(os.Getenv("JWT_SECRET"))
-	tok, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
-	if err != nil || !tok.Valid {
-		c.Close()
-		return
-	}
-	claims := tok.Claims.(jwt.MapClaims)
-	if claims["role"].(string) != "operator" && claims["role"].(string) != "admin" {
+	// Токен и сессия уже проверены в middleware.WSProtected
+	auth := middleware.SocketAuth(c)
+	if auth == nil || !auth.Can(rbac.SupportOperate) {
 		c.Close()
 		return
 	}
+	trackSocket(c, auth.UserID(), auth.SessionID())
+	defer untrackSocket(c)
 
 	newTicketConnsMu.Lock()
 	newTicketConns[c] = true
@@ -438,22 +410,15 @@ func SupportNewTicketsWS(c *websocket.Conn) {
 
 // SupportTicketChatWS — WebSocket для обмена сообщениями внутри конкретного тикета
 func SupportTicketChatWS(c *websocket.Conn) {
-	tokenStr := c.Query("token")
-	if tokenStr == "" {
-		c.Close()
-		return
-	}
-	secret := []byte(os.Getenv("JWT_SECRET"))
-	tok, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
-	if err != nil || !tok.Valid {
+	// Токен и сессия уже проверены в middleware.WSProtected
+	auth := middleware.SocketAuth(c)
+	if auth == nil {
 		c.Close()
 		return
 	}
-	claims := tok.Claims.(jwt.MapClaims)
-	userID := uint(claims["user_id"].(float64))
-	role := claims["role"].(string)
+	userID := auth.UserID()
+	staff := auth.Can(rbac.SupportOperate)
+	sessionID := auth.SessionID()
 
 	ticketIDStr := c.Params("id")
 	ticketIDInt, err := strconv.Atoi(ticketIDStr)
@@ -468,19 +433,22 @@ func SupportTicketChatWS(c *websocket.Conn) {
 	}
 
 	isOwner := ticket.UserID == userID
-	isAssignedOperator := role == "operator" && ticket.OperatorID != nil && *ticket.OperatorID == userID
-	isAdmin := role == "admin"
-	if !isOwner && !isAssignedOperator && !isAdmin {
+	isAssignedOperator := auth.Can(rbac.SupportOperate) && ticket.OperatorID != nil && *ticket.OperatorID == userID
+	canManage := auth.Can(rbac.SupportManage)
+	if !isOwner && !isAssignedOperator && !canManage {
 		c.Close()
 		return
 	}
 
+	trackSocket(c, userID, sessionID)
+	defer untrackSocket(c)
+
 	tid := ticket.ID
 	ticketRoomsMu.Lock()
 	if ticketRooms[tid] == nil {
 		ticketRooms[tid] = make(map[*websocket.Conn]ticketClient)
 	}
-	ticketRooms[tid][c] = ticketClient{UserID: userID, Role: role}
+	ticketRooms[tid][c] = ticketClient{UserID: userID, Staff: staff}
 	ticketRoomsMu.Unlock()
 	broadcastTicketPresence(tid)
 
@@ -505,7 +473,7 @@ func SupportTicketChatWS(c *websocket.Conn) {
 		if envelope.DeleteID != nil {
 			var m models.TicketMessage
 			if err := config.DB.First(&m, *envelope.DeleteID).Error; err == nil {
-				if role == "operator" || role == "admin" || m.SenderID == userID {
+				if staff || m.SenderID == userID {
 					config.DB.Delete(&m)
 					broadcastTicketDelete(tid, m.ID)
 				}
@@ -527,7 +495,7 @@ func SupportTicketChatWS(c *websocket.Conn) {
 		}
 
 		senderRole := "user"
-		if role == "operator" || role == "admin" {
+		if staff {
 			senderRole = "operator"
 		}
 		msg := models.TicketMessage{
@@ -651,7 +619,7 @@ func broadcastTicketPresence(ticketID uint) {
 	conns := ticketRooms[ticketID]
 	ids := make([]uint, 0, len(conns))
 	for _, info := range conns {
-		if info.Role == "operator" || info.Role == "admin" {
+		if info.Staff {
 			ids = append(ids, info.UserID)
 		}
 	}
@@ -0,0 +1,538 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"diplom/config"
+	"diplom/middleware"
+	"diplom/models"
+	"diplom/rbac"
+	"diplom/utils"
+)
+
+const (
+	loginChallengeTTL         = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+	recoveryCodesCount        = 10
+	totpIssuer                = "FP"
+
+	// loginChallengeCookie — челлендж входа через OIDC-провайдера (в URL редиректа его не передаём)
+	loginChallengeCookie = "login_challenge"
+)
+
+// TwoFactorChallengeInput — второй шаг входа.
+type TwoFactorChallengeInput struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`          // код из приложения-аутентификатора
+	RecoveryCode string `json:"recovery_code"` // либо одноразовый код восстановления
+}
+
+// TwoFactorCodeInput — подтверждение действия кодом 2FA.
+type TwoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+// DisableTwoFactorInput — отключение 2FA требует пароль и действующий код.
+type DisableTwoFactorInput struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RequireTwoFactorInput — администратор или оператор обязывает пользователя включить 2FA.
+type RequireTwoFactorInput struct {
+	Required bool `json:"required"`
+}
+
+/*────────────────────────── политика и проверки ──────────────*/
+
+// twoFactorRequired — обязана ли учётная запись входить со вторым фактором: флаг пользователя
+// или одна из её ролей (user_roles) в TWO_FACTOR_REQUIRED_ROLES (через запятую, например "admin,operator").
+// Если роли прочитать не удалось, 2FA считается обязательной.
+func twoFactorRequired(user models.User) bool {
+	if user.TwoFactorRequired {
+		return true
+	}
+	required := map[string]bool{}
+	for _, role := range strings.Split(os.Getenv("TWO_FACTOR_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			required[role] = true
+		}
+	}
+	if len(required) == 0 {
+		return false
+	}
+	roles, err := rbac.UserRoleNames(config.DB, user.ID)
+	if err != nil {
+		log.Printf("Не удалось загрузить роли пользователя %d: %v\n", user.ID, err)
+		return true
+	}
+	for _, role := range roles {
+		if required[role] {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// verifySecondFactor проверяет TOTP-код или код восстановления. Принятый код
+// помечается использованным атомарно, поэтому повторно его применить нельзя.
+func verifySecondFactor(user models.User, code, recoveryCode string) bool {
+	if code != "" && user.TOTPSecret != "" {
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return false
+		}
+		res := config.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		return res.Error == nil && res.RowsAffected == 1
+	}
+	if recoveryCode != "" {
+		res := config.DB.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(normalizeRecoveryCode(recoveryCode))).
+			Update("used_at", time.Now())
+		return res.Error == nil && res.RowsAffected == 1
+	}
+	return false
+}
+
+// generateRecoveryCodes заменяет коды восстановления пользователя новыми и возвращает их открытым текстом.
+func generateRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		raw, err := utils.GenerateSecureToken(5)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Create(&models.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// beginTOTPSetup создаёт новый секрет (2FA ещё не включена) и возвращает данные для приложения.
+func beginTOTPSetup(user *models.User) (fiber.Map, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = secret
+	if err := config.DB.Model(user).Update("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+
+	authURL := utils.TOTPAuthURL(totpIssuer, user.Email, secret)
+	png, err := qrcode.Encode(authURL, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	return fiber.Map{
+		"secret":      secret,
+		"otpauth_url": authURL,
+		"qr":          "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// enableTOTP включает 2FA после проверки первого кода и выдаёт коды восстановления.
+func enableTOTP(user *models.User, code string) ([]string, *fiber.Error) {
+	if user.TwoFactorEnabled {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Двухфакторная аутентификация уже включена")
+	}
+	if user.TOTPSecret == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Сначала получите секрет для приложения-аутентификатора")
+	}
+	if !verifySecondFactor(*user, code, "") {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Неверный код")
+	}
+
+	var codes []string
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("two_factor_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Ошибка включения двухфакторной аутентификации")
+	}
+	user.TwoFactorEnabled = true
+	return codes, nil
+}
+
+/*────────────────────────── вход: второй шаг ─────────────────*/
+
+// startTwoFactorChallenge завершает первый шаг входа: вместо токенов выдаётся челлендж.
+// Пока действует лимит неверных кодов, новый челлендж не выдаётся — иначе каждый вход
+// давал бы ещё loginChallengeMaxAttempts попыток.
+func startTwoFactorChallenge(c *fiber.Ctx, user models.User) error {
+	if res := checkTwoFactorLimits(c.IP(), user.ID); res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+	token, challenge, err := createLoginChallenge(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения челленджа"})
+	}
+
+	return c.JSON(fiber.Map{
+		"two_factor_required": true,
+		"setup_required":      !user.TwoFactorEnabled, // 2FA обязательна, но ещё не настроена
+		"challenge":           token,
+		"expires_at":          challenge.ExpiresAt,
+	})
+}
+
+// createLoginChallenge сохраняет челлендж второго шага входа и возвращает его токен.
+func createLoginChallenge(user models.User) (string, models.LoginChallenge, error) {
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return "", models.LoginChallenge{}, err
+	}
+	challenge := models.LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	}
+	if err := config.DB.Create(&challenge).Error; err != nil {
+		return "", challenge, err
+	}
+	return token, challenge, nil
+}
+
+// setLoginChallengeCookie передаёт челлендж клиенту в HttpOnly cookie, доступной только /auth/2fa.
+func setLoginChallengeCookie(c *fiber.Ctx, token string, expiresAt time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    token,
+		Path:     "/api/auth/2fa",
+		Expires:  expiresAt,
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+func clearLoginChallengeCookie(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    "",
+		Path:     "/api/auth/2fa",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+// loadLoginChallenge находит действующий челлендж и его пользователя. Челлендж берётся
+// из запроса, а если его там нет — из cookie (вход через OIDC-провайдера).
+func loadLoginChallenge(c *fiber.Ctx, token string) (models.LoginChallenge, models.User, *fiber.Error) {
+	var challenge models.LoginChallenge
+	var user models.User
+	if token == "" {
+		token = c.Cookies(loginChallengeCookie)
+	}
+	if token == "" {
+		return challenge, user, fiber.NewError(fiber.StatusUnauthorized, "Челлендж не найден")
+	}
+	if err := config.DB.Where("token_hash = ?", utils.HashToken(token)).First(&challenge).Error; err != nil {
+		return challenge, user, fiber.NewError(fiber.StatusUnauthorized, "Челлендж не найден")
+	}
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= loginChallengeMaxAttempts {
+		config.DB.Delete(&challenge)
+		return challenge, user, fiber.NewError(fiber.StatusUnauthorized, "Время на ввод кода истекло, войдите заново")
+	}
+	if err := config.DB.First(&user, challenge.UserID).Error; err != nil {
+		return challenge, user, fiber.NewError(fiber.StatusUnauthorized, "Пользователь не найден")
+	}
+	return challenge, user, nil
+}
+
+// claimChallengeAttempt занимает попытку ввода кода до его проверки. Условие attempts < max
+// не даёт параллельным запросам получить больше loginChallengeMaxAttempts попыток.
+func claimChallengeAttempt(challenge models.LoginChallenge) bool {
+	res := config.DB.Model(&models.LoginChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, loginChallengeMaxAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// beginChallengeAttempt засчитывает попытку по лимитам второго шага и занимает попытку челленджа.
+// Возвращает false, если ответ клиенту уже отправлен.
+func beginChallengeAttempt(c *fiber.Ctx, challenge models.LoginChallenge, user models.User) (bool, error) {
+	if res := reserveTwoFactorAttempt(c.IP(), user.ID); res.RetryAfter > 0 {
+		return false, tooManyAttempts(c, res)
+	}
+	if !claimChallengeAttempt(challenge) {
+		releaseTwoFactorAttempt(c.IP(), user.ID)
+		config.DB.Delete(&challenge)
+		return false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Время на ввод кода истекло, войдите заново"})
+	}
+	return true, nil
+}
+
+// VerifyTwoFactorLogin — второй шаг входа: код TOTP или код восстановления.
+func VerifyTwoFactorLogin(c *fiber.Ctx) error {
+	var input TwoFactorChallengeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	challenge, user, ferr := loadLoginChallenge(c, input.Challenge)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	if !user.TwoFactorEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Сначала настройте двухфакторную аутентификацию"})
+	}
+
+	if ok, err := beginChallengeAttempt(c, challenge, user); !ok {
+		return err
+	}
+	if !verifySecondFactor(user, input.Code, input.RecoveryCode) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный код"})
+	}
+	config.DB.Delete(&challenge)
+	clearLoginChallengeCookie(c)
+	twoFactorSucceeded(c.IP(), user.ID)
+
+	accessToken, err := issueLoginTokens(c, user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка выдачи токенов"})
+	}
+	return c.JSON(fiber.Map{
+		"access_token": accessToken,
+		"user":         user,
+	})
+}
+
+// SetupTwoFactorLogin — обязательная настройка 2FA во время входа: выдаёт секрет по челленджу.
+func SetupTwoFactorLogin(c *fiber.Ctx) error {
+	var input TwoFactorChallengeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	_, user, ferr := loadLoginChallenge(c, input.Challenge)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	if user.TwoFactorEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Двухфакторная аутентификация уже включена"})
+	}
+
+	setup, err := beginTOTPSetup(&user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации секрета"})
+	}
+	return c.JSON(setup)
+}
+
+// EnableTwoFactorLogin — подтверждение обязательной настройки 2FA первым кодом; завершает вход.
+func EnableTwoFactorLogin(c *fiber.Ctx) error {
+	var input TwoFactorChallengeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	challenge, user, ferr := loadLoginChallenge(c, input.Challenge)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	if ok, err := beginChallengeAttempt(c, challenge, user); !ok {
+		return err
+	}
+	codes, ferr := enableTOTP(&user, input.Code)
+	if ferr != nil {
+		// Неверный код остаётся засчитанным; остальные ошибки — не попытка ввода
+		if ferr.Code != fiber.StatusUnauthorized {
+			releaseTwoFactorAttempt(c.IP(), user.ID)
+		}
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	config.DB.Delete(&challenge)
+	clearLoginChallengeCookie(c)
+	twoFactorSucceeded(c.IP(), user.ID)
+
+	accessToken, err := issueLoginTokens(c, user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка выдачи токенов"})
+	}
+	return c.JSON(fiber.Map{
+		"access_token":   accessToken,
+		"user":           user,
+		"recovery_codes": codes,
+	})
+}
+
+/*────────────────────────── управление 2FA в профиле ─────────*/
+
+// SetupTwoFactor выдаёт новый секрет и QR-код для приложения-аутентификатора.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	if user.TwoFactorEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Двухфакторная аутентификация уже включена"})
+	}
+
+	setup, err := beginTOTPSetup(&user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации секрета"})
+	}
+	return c.JSON(setup)
+}
+
+// EnableTwoFactor включает 2FA после проверки кода и возвращает коды восстановления.
+func EnableTwoFactor(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	var input TwoFactorCodeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	if res := reserveTwoFactorAttempt(c.IP(), user.ID); res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+	codes, ferr := enableTOTP(&user, input.Code)
+	if ferr != nil {
+		if ferr.Code != fiber.StatusUnauthorized {
+			releaseTwoFactorAttempt(c.IP(), user.ID)
+		}
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	twoFactorSucceeded(c.IP(), user.ID)
+	return c.JSON(fiber.Map{
+		"message":        "Двухфакторная аутентификация включена. Сохраните коды восстановления.",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor отключает 2FA, если она не обязательна для пользователя.
+func DisableTwoFactor(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	var input DisableTwoFactorInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	if !user.TwoFactorEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Двухфакторная аутентификация не включена"})
+	}
+	if twoFactorRequired(user) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Для вашей учётной записи двухфакторная аутентификация обязательна"})
+	}
+	// Пароль и код проверяются под лимитами второго шага: украденный access-токен не даёт перебора
+	if res := reserveTwoFactorAttempt(c.IP(), user.ID); res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный пароль"})
+	}
+	if !verifySecondFactor(user, input.Code, input.RecoveryCode) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный код"})
+	}
+	twoFactorSucceeded(c.IP(), user.ID)
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"two_factor_enabled": false,
+			"totp_secret":        "",
+			"totp_last_step":     0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка отключения двухфакторной аутентификации"})
+	}
+	return c.JSON(fiber.Map{"message": "Двухфакторная аутентификация отключена"})
+}
+
+// RegenerateRecoveryCodes выдаёт новый набор кодов восстановления; старые перестают действовать.
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, ferr := currentUserFromCtx(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	var input TwoFactorCodeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+	if !user.TwoFactorEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Двухфакторная аутентификация не включена"})
+	}
+	// Пароль здесь не спрашивается, поэтому перебор кода ограничивают лимиты второго шага
+	if res := reserveTwoFactorAttempt(c.IP(), user.ID); res.RetryAfter > 0 {
+		return tooManyAttempts(c, res)
+	}
+	if !verifySecondFactor(user, input.Code, "") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный код"})
+	}
+	twoFactorSucceeded(c.IP(), user.ID)
+
+	codes, err := generateRecoveryCodes(config.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка генерации кодов восстановления"})
+	}
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+/*────────────────────────── администрирование ────────────────*/
+
+// RequireTwoFactor — пользователь с правом users:2fa обязывает пользователя (или снимает обязанность) входить с 2FA.
+func RequireTwoFactor(c *fiber.Ctx) error {
+	auth := middleware.Auth(c)
+
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный ID пользователя"})
+	}
+	var input RequireTwoFactorInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Невозможно разобрать JSON"})
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Пользователь не найден"})
+	}
+	// Нельзя менять требования к пользователю, у которого есть права, которых нет у тебя
+	targetPerms, err := rbac.UserPermissions(config.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка проверки прав"})
+	}
+	if !rbac.Covers(auth.Permissions, targetPerms) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Нет доступа"})
+	}
+
+	if err := config.DB.Model(&user).Update("two_factor_required", input.Required).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка сохранения"})
+	}
+	return c.JSON(fiber.Map{"user": user})
+}
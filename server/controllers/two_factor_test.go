@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/limiter"
+	"diplom/middleware"
+	"diplom/models"
+)
+
+// Челлендж даёт не больше loginChallengeMaxAttempts попыток, а лимит по пользователю
+// не даёт получить новые попытки повторным входом.
+func TestTwoFactorAttemptsAreCapped(t *testing.T) {
+	setupTestDB(t)
+	twoFactorUserLimiter.SetStore(limiter.NewMemoryStore())
+	twoFactorIPLimiter.SetStore(limiter.NewMemoryStore())
+
+	user := createTestUser(t, "user@example.com", 0)
+	config.DB.Model(&user).Updates(map[string]interface{}{"two_factor_enabled": true, "totp_secret": "JBSWY3DPEHPK3PXP"})
+	user.TwoFactorEnabled = true
+	token, challenge, err := createLoginChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/auth/2fa/verify", VerifyTwoFactorLogin)
+	app.Post("/login", func(c *fiber.Ctx) error { return startTwoFactorChallenge(c, user) })
+	post := func(path, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	verify := func(token string) int {
+		return post("/auth/2fa/verify", `{"challenge":"`+token+`","recovery_code":"wrong-code"}`)
+	}
+
+	var statuses []int
+	for i := 0; i < loginChallengeMaxAttempts+1; i++ {
+		statuses = append(statuses, verify(token))
+	}
+	for i, s := range statuses {
+		if s != fiber.StatusUnauthorized {
+			t.Fatalf("попытка %d: статус %d (все ответы %v)", i+1, s, statuses)
+		}
+	}
+	var rows int64
+	config.DB.Model(&models.LoginChallenge{}).Where("id = ?", challenge.ID).Count(&rows)
+	if rows != 0 {
+		t.Error("челлендж не удалён после исчерпания попыток")
+	}
+
+	// Новый вход даёт новый челлендж, но лимит неверных кодов по пользователю сохраняется:
+	// после очередной ошибки и проверка, и следующий челлендж получают 429
+	token, _, err = createLoginChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := verify(token); s != fiber.StatusUnauthorized {
+		t.Fatalf("первая попытка нового челленджа: статус %d", s)
+	}
+	if s := verify(token); s != fiber.StatusTooManyRequests {
+		t.Errorf("проверка после лимита: статус %d, ожидался 429", s)
+	}
+	if s := post("/login", ""); s != fiber.StatusTooManyRequests {
+		t.Errorf("новый челлендж после лимита: статус %d, ожидался 429", s)
+	}
+}
+
+// Перевыпуск кодов восстановления не спрашивает пароль: с украденным access-токеном код TOTP
+// перебирается только в пределах лимитов второго шага.
+func TestRegenerateRecoveryCodesIsRateLimited(t *testing.T) {
+	setupTestDB(t)
+	twoFactorUserLimiter.SetStore(limiter.NewMemoryStore())
+	twoFactorIPLimiter.SetStore(limiter.NewMemoryStore())
+
+	user := createTestUser(t, "user@example.com", 0)
+	config.DB.Model(&user).Updates(map[string]interface{}{"two_factor_enabled": true, "totp_secret": "JBSWY3DPEHPK3PXP"})
+
+	app := fiber.New()
+	app.Post("/api/me/2fa/recovery-codes", middleware.JWTProtected(), RegenerateRecoveryCodes)
+	token := accessTokenFor(t, user)
+
+	var statuses []int
+	for i := 0; i < twoFactorUserLimiter.Config().FreeAttempts+2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/me/2fa/recovery-codes", strings.NewReader(`{"code":"wrong"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, resp.StatusCode)
+	}
+	if last := statuses[len(statuses)-1]; last != fiber.StatusTooManyRequests {
+		t.Fatalf("перебор кода не ограничен: ответы %v", statuses)
+	}
+}
+
+// Обязательная 2FA определяется по ролям из user_roles, а не по устаревшему users.role.
+func TestTwoFactorRequiredByAssignedRole(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("TWO_FACTOR_REQUIRED_ROLES", "admin, operator")
+
+	operator := models.Role{Name: "operator"}
+	config.DB.Create(&operator)
+	staff := createTestUser(t, "staff@example.com", 0)
+	config.DB.Create(&models.UserRole{UserID: staff.ID, RoleID: operator.ID})
+	legacy := createTestUser(t, "legacy@example.com", 0)
+	legacy.Role = "admin" // роль только в старом поле, без записи в user_roles
+
+	if !twoFactorRequired(staff) {
+		t.Error("2FA не обязательна для пользователя с ролью operator из user_roles")
+	}
+	if twoFactorRequired(legacy) {
+		t.Error("2FA определяется по users.role")
+	}
+}
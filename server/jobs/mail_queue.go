@@ -0,0 +1,19 @@
+package jobs
+
+import (
+	"time"
+
+	"diplom/mail"
+)
+
+// StartMailQueue периодически досылает письма, которые не ушли с первой попытки.
+func StartMailQueue(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			mail.ProcessQueue()
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,21 @@
+package jobs
+
+import (
+	"time"
+
+	"diplom/billing"
+	"diplom/config"
+)
+
+// StartSubscriptionExpiry периодически предупреждает об окончании подписок
+// и отключает подписки, у которых закончился льготный срок.
+func StartSubscriptionExpiry(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			billing.ProcessExpiry(config.DB)
+			<-ticker.C
+		}
+	}()
+}
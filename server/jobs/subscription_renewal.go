@@ -0,0 +1,21 @@
+package jobs
+
+import (
+	"time"
+
+	"diplom/billing"
+	"diplom/config"
+)
+
+// StartSubscriptionRenewal периодически напоминает о предстоящих автосписаниях
+// и продлевает подписки по сохранённым способам оплаты.
+func StartSubscriptionRenewal(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			billing.ProcessRenewals(config.DB)
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"diplom/config"
+	"diplom/models"
+)
+
+// StartTokenCleanup периодически удаляет просроченные refresh-токены, токены сброса пароля, челленджи 2FA,
+// незавершённые входы через OIDC и устаревшие записи лимитера попыток входа.
+// Заменённые (rotated) токены хранятся до истечения срока, чтобы распознавать их повторное использование.
+func StartTokenCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			cleanupTokens()
+			<-ticker.C
+		}
+	}()
+}
+
+func cleanupTokens() {
+	now := time.Now()
+
+	res := config.DB.Unscoped().
+		Where("expires_at < ? OR deleted_at IS NOT NULL", now).
+		Delete(&models.Token{})
+	if res.Error != nil {
+		log.Printf("Очистка refresh токенов: %v\n", res.Error)
+	} else if res.RowsAffected > 0 {
+		log.Printf("Очистка refresh токенов: удалено %d\n", res.RowsAffected)
+	}
+
+	if err := config.DB.
+		Where("expires_at < ?", now).
+		Delete(&models.PasswordResetToken{}).Error; err != nil {
+		log.Printf("Очистка токенов сброса пароля: %v\n", err)
+	}
+
+	if err := config.DB.
+		Where("expires_at < ?", now).
+		Delete(&models.LoginChallenge{}).Error; err != nil {
+		log.Printf("Очистка челленджей 2FA: %v\n", err)
+	}
+
+	if err := config.DB.
+		Where("expires_at < ?", now).
+		Delete(&models.OIDCLoginState{}).Error; err != nil {
+		log.Printf("Очистка состояний входа OIDC: %v\n", err)
+	}
+
+	// Записи лимитера, по которым давно не было попыток и не действует блокировка
+	if err := config.DB.
+		Where("updated_at < ? AND locked_until < ? AND blocked_until < ?", now.Add(-24*time.Hour), now, now).
+		Delete(&models.RateLimitEntry{}).Error; err != nil {
+		log.Printf("Очистка записей лимитера: %v\n", err)
+	}
+}
@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/models"
+)
+
+// DBStore хранит состояния в таблице rate_limit_entries, чтобы несколько инстансов
+// сервера видели общий счётчик попыток.
+type DBStore struct {
+	db *gorm.DB
+}
+
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Get(key string) (State, error) {
+	var entry models.RateLimitEntry
+	err := s.db.Where("key = ?", key).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	return entryState(entry), nil
+}
+
+func (s *DBStore) Update(key string, fn func(*State)) (State, error) {
+	var st State
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Создаём запись, если её нет, и блокируем строку до конца транзакции
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.RateLimitEntry{Key: key}).Error; err != nil {
+			return err
+		}
+		var entry models.RateLimitEntry
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key = ?", key).First(&entry).Error; err != nil {
+			return err
+		}
+
+		st = entryState(entry)
+		fn(&st)
+
+		entry.Failures = st.Failures
+		entry.WindowStart = st.WindowStart
+		entry.BlockedUntil = st.BlockedUntil
+		entry.LockedUntil = st.LockedUntil
+		return tx.Save(&entry).Error
+	})
+	return st, err
+}
+
+func (s *DBStore) Delete(key string) error {
+	return s.db.Where("key = ?", key).Delete(&models.RateLimitEntry{}).Error
+}
+
+func entryState(entry models.RateLimitEntry) State {
+	return State{
+		Failures:     entry.Failures,
+		WindowStart:  entry.WindowStart,
+		BlockedUntil: entry.BlockedUntil,
+		LockedUntil:  entry.LockedUntil,
+	}
+}
@@ -0,0 +1,191 @@
+package limiter
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// State — счётчик неудачных попыток по одному ключу (IP или email).
+type State struct {
+	Failures     int
+	WindowStart  time.Time
+	BlockedUntil time.Time // экспоненциальная задержка до следующей попытки
+	LockedUntil  time.Time // временная блокировка после превышения порога
+}
+
+// Store хранит состояния лимитера. Update должен выполнять fn атомарно для ключа,
+// чтобы параллельные попытки на нескольких инстансах не теряли счёт.
+type Store interface {
+	Get(key string) (State, error)
+	Update(key string, fn func(*State)) (State, error)
+	Delete(key string) error
+}
+
+// Config — политика лимитера.
+type Config struct {
+	FreeAttempts     int           // сколько неудач допускается без задержки
+	BaseDelay        time.Duration // задержка после первой «платной» неудачи, дальше удваивается
+	MaxDelay         time.Duration // потолок задержки
+	LockoutThreshold int           // после стольких неудач — временная блокировка (0 — без блокировки)
+	LockoutDuration  time.Duration
+	Window           time.Duration // через столько после первой неудачи счётчик сбрасывается
+}
+
+// Result — итог проверки или регистрации неудачи.
+type Result struct {
+	RetryAfter time.Duration // > 0 — попытку нужно отклонить
+	Locked     bool          // действует временная блокировка
+	LockedNow  bool          // блокировка наступила именно этой неудачей (для уведомления)
+}
+
+type Limiter struct {
+	store  Store
+	cfg    Config
+	prefix string
+}
+
+func New(store Store, prefix string, cfg Config) *Limiter {
+	return &Limiter{store: store, cfg: cfg, prefix: prefix}
+}
+
+// Config возвращает политику лимитера.
+func (l *Limiter) Config() Config {
+	return l.cfg
+}
+
+// SetStore подменяет хранилище, например на общее для нескольких инстансов.
+func (l *Limiter) SetStore(store Store) {
+	l.store = store
+}
+
+// Check сообщает, можно ли сейчас делать попытку для ключа.
+func (l *Limiter) Check(key string) (Result, error) {
+	st, err := l.store.Get(l.prefix + key)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.result(st, time.Now()), nil
+}
+
+// Fail регистрирует неудачную попытку и пересчитывает задержку и блокировку.
+func (l *Limiter) Fail(key string) (Result, error) {
+	now := time.Now()
+	lockedNow := false
+	st, err := l.store.Update(l.prefix+key, func(st *State) {
+		lockedNow = l.fail(st, now)
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	res := l.result(st, now)
+	res.LockedNow = lockedNow
+	return res, nil
+}
+
+// Hit атомарно проверяет ключ и, если попытка разрешена, сразу засчитывает её как неудачную.
+// В отличие от пары Check и Fail, параллельные запросы не пройдут проверку все разом до того,
+// как учтена первая неудача. Отклонённая попытка не засчитывается. Если попытка оказалась
+// успешной, её снимают Reset или Release.
+func (l *Limiter) Hit(key string) (Result, error) {
+	now := time.Now()
+	var res Result
+	_, err := l.store.Update(l.prefix+key, func(st *State) {
+		if res = l.result(*st, now); res.RetryAfter > 0 {
+			return
+		}
+		res.LockedNow = l.fail(st, now)
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Release снимает попытку, засчитанную Hit, — для ключей, которые после успеха не сбрасываются
+// целиком (например, IP). Задержка снимается, если неудач осталось не больше бесплатных.
+func (l *Limiter) Release(key string) error {
+	_, err := l.store.Update(l.prefix+key, func(st *State) {
+		if st.Failures > 0 {
+			st.Failures--
+		}
+		if st.Failures <= l.cfg.FreeAttempts {
+			st.BlockedUntil = time.Time{}
+		}
+	})
+	return err
+}
+
+// fail учитывает неудачу в состоянии ключа; true — блокировка наступила именно ей.
+func (l *Limiter) fail(st *State, now time.Time) bool {
+	// Новое окно: первая неудача, окно истекло или закончилась прошлая блокировка
+	windowExpired := l.cfg.Window > 0 && now.Sub(st.WindowStart) > l.cfg.Window && now.After(st.LockedUntil)
+	lockExpired := !st.LockedUntil.IsZero() && now.After(st.LockedUntil)
+	if st.WindowStart.IsZero() || windowExpired || lockExpired {
+		*st = State{WindowStart: now}
+	}
+	st.Failures++
+
+	if l.cfg.LockoutThreshold > 0 && st.Failures >= l.cfg.LockoutThreshold {
+		lockedNow := !now.Before(st.LockedUntil)
+		st.LockedUntil = now.Add(l.cfg.LockoutDuration)
+		return lockedNow
+	}
+	if over := st.Failures - l.cfg.FreeAttempts; over > 0 {
+		delay := time.Duration(float64(l.cfg.BaseDelay) * math.Pow(2, float64(over-1)))
+		if l.cfg.MaxDelay > 0 && delay > l.cfg.MaxDelay {
+			delay = l.cfg.MaxDelay
+		}
+		st.BlockedUntil = now.Add(delay)
+	}
+	return false
+}
+
+// Reset сбрасывает счётчик ключа (например, после успешного входа).
+func (l *Limiter) Reset(key string) error {
+	return l.store.Delete(l.prefix + key)
+}
+
+func (l *Limiter) result(st State, now time.Time) Result {
+	if now.Before(st.LockedUntil) {
+		return Result{RetryAfter: st.LockedUntil.Sub(now), Locked: true}
+	}
+	if now.Before(st.BlockedUntil) {
+		return Result{RetryAfter: st.BlockedUntil.Sub(now)}
+	}
+	return Result{}
+}
+
+// ConfigFromEnv читает политику из переменных окружения с префиксом prefix
+// (например LOGIN_EMAIL_FREE_ATTEMPTS, LOGIN_EMAIL_LOCKOUT_MINUTES), подставляя значения по умолчанию.
+func ConfigFromEnv(prefix string, def Config) Config {
+	cfg := def
+	if v, ok := envInt(prefix + "_FREE_ATTEMPTS"); ok {
+		cfg.FreeAttempts = v
+	}
+	if v, ok := envInt(prefix + "_BASE_DELAY_SECONDS"); ok {
+		cfg.BaseDelay = time.Duration(v) * time.Second
+	}
+	if v, ok := envInt(prefix + "_MAX_DELAY_SECONDS"); ok {
+		cfg.MaxDelay = time.Duration(v) * time.Second
+	}
+	if v, ok := envInt(prefix + "_LOCKOUT_THRESHOLD"); ok {
+		cfg.LockoutThreshold = v
+	}
+	if v, ok := envInt(prefix + "_LOCKOUT_MINUTES"); ok {
+		cfg.LockoutDuration = time.Duration(v) * time.Minute
+	}
+	if v, ok := envInt(prefix + "_WINDOW_MINUTES"); ok {
+		cfg.Window = time.Duration(v) * time.Minute
+	}
+	return cfg
+}
+
+func envInt(name string) (int, bool) {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	return v, true
+}
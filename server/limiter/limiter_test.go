@@ -0,0 +1,171 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFailBacksOffAfterFreeAttempts(t *testing.T) {
+	l := New(NewMemoryStore(), "test:", Config{
+		FreeAttempts: 2,
+		BaseDelay:    time.Minute,
+		MaxDelay:     3 * time.Minute,
+		Window:       time.Hour,
+	})
+
+	for i := 1; i <= 2; i++ {
+		res, err := l.Fail("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RetryAfter != 0 {
+			t.Fatalf("неудача %d из бесплатных: задержка %v", i, res.RetryAfter)
+		}
+	}
+	// Дальше задержка удваивается и упирается в потолок
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		res, _ := l.Fail("key")
+		if res.RetryAfter <= want-time.Second || res.RetryAfter > want {
+			t.Fatalf("задержка %v, ожидалась %v", res.RetryAfter, want)
+		}
+		if res.Locked {
+			t.Fatal("блокировка без порога")
+		}
+	}
+
+	res, _ := l.Check("key")
+	if res.RetryAfter == 0 {
+		t.Fatal("Check пропускает попытку во время задержки")
+	}
+	if res, _ := l.Check("other"); res.RetryAfter != 0 {
+		t.Fatal("задержка затронула другой ключ")
+	}
+}
+
+func TestFailLocksOutAtThreshold(t *testing.T) {
+	l := New(NewMemoryStore(), "test:", Config{
+		FreeAttempts:     10,
+		LockoutThreshold: 3,
+		LockoutDuration:  30 * time.Minute,
+		Window:           time.Hour,
+	})
+
+	l.Fail("key")
+	l.Fail("key")
+	res, _ := l.Fail("key")
+	if !res.Locked || !res.LockedNow || res.RetryAfter <= 29*time.Minute {
+		t.Fatalf("после порога: %+v", res)
+	}
+	// Блокировка наступает один раз: следующая неудача продлевает её без нового уведомления
+	res, _ = l.Fail("key")
+	if !res.Locked || res.LockedNow {
+		t.Fatalf("повторная неудача во время блокировки: %+v", res)
+	}
+}
+
+func TestResetClearsState(t *testing.T) {
+	l := New(NewMemoryStore(), "test:", Config{
+		FreeAttempts:     0,
+		BaseDelay:        time.Minute,
+		LockoutThreshold: 2,
+		LockoutDuration:  time.Hour,
+	})
+
+	l.Fail("key")
+	l.Fail("key")
+	if res, _ := l.Check("key"); !res.Locked {
+		t.Fatal("ожидалась блокировка")
+	}
+	if err := l.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := l.Check("key"); res.RetryAfter != 0 {
+		t.Fatalf("после Reset: %+v", res)
+	}
+	// Счёт начинается заново
+	if res, _ := l.Fail("key"); res.Locked {
+		t.Fatal("после Reset первая неудача сразу блокирует")
+	}
+}
+
+func TestFailStartsNewWindow(t *testing.T) {
+	store := NewMemoryStore()
+	l := New(store, "test:", Config{
+		FreeAttempts: 1,
+		BaseDelay:    time.Minute,
+		Window:       time.Hour,
+	})
+
+	l.Fail("key")
+	l.Fail("key")
+	// Окно с прошлыми неудачами закончилось два часа назад
+	store.Update("test:key", func(st *State) {
+		st.WindowStart = time.Now().Add(-2 * time.Hour)
+		st.BlockedUntil = time.Time{}
+	})
+	if res, _ := l.Fail("key"); res.RetryAfter != 0 {
+		t.Fatalf("неудача в новом окне считается вместе со старыми: %+v", res)
+	}
+}
+
+func TestHitCountsParallelAttemptsBeforeVerification(t *testing.T) {
+	l := New(NewMemoryStore(), "test:", Config{
+		FreeAttempts:     100,
+		LockoutThreshold: 5,
+		LockoutDuration:  time.Hour,
+	})
+
+	// Все попытки резервируются до проверки: пройти может ровно столько, сколько до порога
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed, lockedNow := 0, 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := l.Hit("key")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if res.RetryAfter == 0 {
+				allowed++
+			}
+			if res.LockedNow {
+				lockedNow++
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 5 || lockedNow != 1 {
+		t.Fatalf("пропущено %d попыток (ожидалось 5), блокировок %d", allowed, lockedNow)
+	}
+}
+
+func TestReleaseUndoesHit(t *testing.T) {
+	l := New(NewMemoryStore(), "test:", Config{
+		FreeAttempts: 1,
+		BaseDelay:    time.Minute,
+		Window:       time.Hour,
+	})
+
+	// Успешные попытки с одного IP не копят задержку
+	for i := 0; i < 5; i++ {
+		res, _ := l.Hit("ip")
+		if res.RetryAfter != 0 {
+			t.Fatalf("попытка %d отклонена: %+v", i+1, res)
+		}
+		if err := l.Release("ip"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l.Hit("ip")
+	l.Hit("ip") // вторая неудача сверх бесплатной
+	if res, _ := l.Hit("ip"); res.RetryAfter == 0 {
+		t.Fatal("попытка во время задержки пропущена")
+	}
+}
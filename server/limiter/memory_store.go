@@ -0,0 +1,53 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore — хранилище в памяти процесса. Подходит для одного инстанса.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]State
+	writes  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]State)}
+}
+
+func (m *MemoryStore) Get(key string) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entries[key], nil
+}
+
+func (m *MemoryStore) Update(key string, fn func(*State)) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.entries[key]
+	fn(&st)
+	m.entries[key] = st
+
+	// Время от времени убираем записи, у которых всё истекло, чтобы карта не росла бесконечно
+	m.writes++
+	if m.writes%1000 == 0 {
+		m.sweep(time.Now())
+	}
+	return st, nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.mu.Lock()
+	delete(m.entries, key)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	for key, st := range m.entries {
+		if now.After(st.BlockedUntil) && now.After(st.LockedUntil) && now.Sub(st.WindowStart) > 24*time.Hour {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package mail
 import (
 	"os"
 	"strconv"
+	"time"
 
 	"gopkg.in/gomail.v2"
 )
@@ -54,4 +55,172 @@ func (m *MailService) SendFamilyInviteMail(to, inviteLink string) error {
 		</div>
 	`)
 	return m.dialer.DialAndSend(message)
-}
\ No newline at end of file
+}
+
+func (m *MailService) SendEmailChangeMail(to, confirmLink string) error {
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Подтверждение нового email на FP")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Смена email</h2>
+			<p>Здравствуйте,</p>
+			<p>Вы указали этот адрес как новый email аккаунта на FP. Для подтверждения перейдите по ссылке ниже (ссылка действует 24 часа):</p>
+			<p style="text-align: center;"><a href="`+confirmLink+`" style="display: inline-block; padding: 10px 20px; background-color: #007bff; color: #fff; text-decoration: none; border-radius: 5px;">Подтвердить email</a></p>
+			<p>Если вы не меняли email, просто проигнорируйте это письмо.</p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+func (m *MailService) SendPasswordResetMail(to, resetLink string) error {
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Восстановление пароля на FP")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Восстановление пароля</h2>
+			<p>Здравствуйте,</p>
+			<p>Мы получили запрос на сброс пароля для вашего аккаунта на FP. Чтобы задать новый пароль, перейдите по ссылке ниже (ссылка действует 1 час и может быть использована один раз):</p>
+			<p style="text-align: center;"><a href="`+resetLink+`" style="display: inline-block; padding: 10px 20px; background-color: #007bff; color: #fff; text-decoration: none; border-radius: 5px;">Сбросить пароль</a></p>
+			<p>Если вы не запрашивали сброс пароля, просто проигнорируйте это письмо — пароль останется прежним.</p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+func (m *MailService) SendAccountDeletionMail(to, confirmLink string) error {
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Подтверждение удаления аккаунта на FP")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Удаление аккаунта</h2>
+			<p>Здравствуйте,</p>
+			<p>Мы получили запрос на удаление вашего аккаунта на FP. Чтобы подтвердить удаление, перейдите по ссылке ниже (ссылка действует 1 час и может быть использована один раз):</p>
+			<p style="text-align: center;"><a href="`+confirmLink+`" style="display: inline-block; padding: 10px 20px; background-color: #dc3545; color: #fff; text-decoration: none; border-radius: 5px;">Удалить аккаунт</a></p>
+			<p>Если вы не запрашивали удаление, просто проигнорируйте это письмо — аккаунт останется без изменений.</p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+func (m *MailService) SendAccountLockedMail(to string, lockedUntil time.Time) error {
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Вход в аккаунт FP временно заблокирован")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Вход временно заблокирован</h2>
+			<p>Здравствуйте,</p>
+			<p>Мы зафиксировали много неудачных попыток входа в ваш аккаунт на FP, поэтому вход заблокирован до `+lockedUntil.Format("02.01.2006 15:04 MST")+`.</p>
+			<p>Если это были не вы, рекомендуем после разблокировки сменить пароль или восстановить его по ссылке «Забыли пароль?».</p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+func (m *MailService) SendRenewalReminderMail(to, planName, amount string, chargeAt time.Time) error {
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Скоро продление подписки FP")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Продление подписки</h2>
+			<p>Здравствуйте,</p>
+			<p>`+chargeAt.Format("02.01.2006")+` подписка «`+planName+`» будет продлена автоматически: с сохранённого способа оплаты спишется `+amount+` ₽.</p>
+			<p>Отключить автопродление можно в настройках подписки:</p>
+			<p style="text-align: center;"><a href="`+os.Getenv("CLIENT_URL")+`/dashboard/subscription" style="display: inline-block; padding: 10px 20px; background-color: #007bff; color: #fff; text-decoration: none; border-radius: 5px;">Управление подпиской</a></p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+// SendRenewalFailedMail — списание не прошло. nextAttempt == nil — попытки закончились и автопродление отключено.
+func (m *MailService) SendRenewalFailedMail(to, amount string, nextAttempt *time.Time) error {
+	next := "Попытки списания закончились, автопродление отключено. Чтобы подписка не прервалась, оплатите её вручную."
+	if nextAttempt != nil {
+		next = "Мы повторим попытку " + nextAttempt.Format("02.01.2006 15:04") + ". Проверьте, что на карте достаточно средств, или оплатите подписку вручную."
+	}
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Не удалось продлить подписку FP")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Не удалось продлить подписку</h2>
+			<p>Здравствуйте,</p>
+			<p>Нам не удалось списать `+amount+` ₽ за продление подписки FP.</p>
+			<p>`+next+`</p>
+			<p style="text-align: center;"><a href="`+os.Getenv("CLIENT_URL")+`/dashboard/subscription" style="display: inline-block; padding: 10px 20px; background-color: #007bff; color: #fff; text-decoration: none; border-radius: 5px;">Управление подпиской</a></p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+func (m *MailService) SendSubscriptionExpiringMail(to string, endDate time.Time, daysLeft int) error {
+	left := "через " + strconv.Itoa(daysLeft) + " дн."
+	if daysLeft <= 1 {
+		left = "завтра"
+	}
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Подписка FP скоро закончится")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Подписка скоро закончится</h2>
+			<p>Здравствуйте,</p>
+			<p>Подписка вашей семьи на FP закончится `+left+` — `+endDate.Format("02.01.2006")+`. Продлите её, чтобы не потерять доступ к возможностям подписки.</p>
+			<p style="text-align: center;"><a href="`+os.Getenv("CLIENT_URL")+`/dashboard/subscription" style="display: inline-block; padding: 10px 20px; background-color: #28a745; color: #fff; text-decoration: none; border-radius: 5px;">Продлить подписку</a></p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+func (m *MailService) SendSubscriptionExpiredMail(to string) error {
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Подписка FP закончилась")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Подписка закончилась</h2>
+			<p>Здравствуйте,</p>
+			<p>Подписка вашей семьи на FP закончилась, возможности подписки отключены. Все данные семьи сохранены — оформите подписку снова, чтобы вернуть доступ.</p>
+			<p style="text-align: center;"><a href="`+os.Getenv("CLIENT_URL")+`/dashboard/subscription" style="display: inline-block; padding: 10px 20px; background-color: #28a745; color: #fff; text-decoration: none; border-radius: 5px;">Оформить подписку</a></p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
+
+func (m *MailService) SendGiftCodeMail(to, code, planName string) error {
+	message := gomail.NewMessage()
+	message.SetHeader("From", os.Getenv("SMTP_USER"))
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", "Подарочная подписка FP")
+	message.SetBody("text/html", `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: auto; padding: 20px; border: 1px solid #ddd; border-radius: 8px; background-color: #f5f5f5;">
+			<h2 style="color: #333; text-align: center;">Подарочная подписка оплачена</h2>
+			<p>Здравствуйте,</p>
+			<p>Спасибо за покупку подписки «`+planName+`» в подарок. Передайте этот код семье, которой вы её дарите:</p>
+			<p style="text-align: center; font-size: 22px; font-weight: bold; letter-spacing: 2px;">`+code+`</p>
+			<p>Код активируется в разделе подписки: <a href="`+os.Getenv("CLIENT_URL")+`/dashboard/subscription">`+os.Getenv("CLIENT_URL")+`/dashboard/subscription</a>.</p>
+			<p>С уважением, команда FP.</p>
+		</div>
+	`)
+	return m.dialer.DialAndSend(message)
+}
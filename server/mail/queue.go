@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/config"
+	"diplom/models"
+)
+
+// Виды писем, которые можно поставить в очередь. Ссылка хранится в открытом виде,
+// поэтому письма со ссылками на хэшируемые токены (сброс пароля) сюда не попадают.
+const (
+	KindActivation = "activation"
+)
+
+// maxQueueAttempts — после стольких неудач письмо больше не отправляется.
+const maxQueueAttempts = 10
+
+// queueClaimTimeout — на столько взятое в отправку письмо скрыто от других запусков ProcessQueue.
+// Если экземпляр упадёт посреди отправки, письмо вернётся в очередь по истечении этого времени.
+const queueClaimTimeout = 10 * time.Minute
+
+// Enqueue ставит письмо в очередь на повторную отправку.
+func Enqueue(kind, to, link string, cause error) error {
+	item := models.QueuedMail{
+		To:            to,
+		Kind:          kind,
+		Link:          link,
+		NextAttemptAt: time.Now().Add(time.Minute),
+		CreatedAt:     time.Now(),
+	}
+	if cause != nil {
+		item.LastError = cause.Error()
+	}
+	return config.DB.Create(&item).Error
+}
+
+// SendOrEnqueue пытается отправить письмо сразу, а при ошибке ставит его в очередь.
+// Новое письмо заменяет неотправленные того же вида на тот же адрес: в них устаревшая ссылка.
+// Ошибка возвращается, только если письмо не удалось ни отправить, ни сохранить.
+func SendOrEnqueue(kind, to, link string) error {
+	if err := dropPending(kind, to); err != nil {
+		log.Printf("Очередь писем: не удалось убрать прежние письма %s для %s: %v\n", kind, to, err)
+	}
+	err := NewMailService().send(kind, to, link)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Письмо %s для %s поставлено в очередь: %v\n", kind, to, err)
+	return Enqueue(kind, to, link, err)
+}
+
+// dropPending удаляет из очереди неотправленные письма вида kind на адрес to.
+func dropPending(kind, to string) error {
+	return config.DB.
+		Where("sent_at IS NULL AND kind = ? AND LOWER(\"to\") = LOWER(?)", kind, to).
+		Delete(&models.QueuedMail{}).Error
+}
+
+// ProcessQueue отправляет письма, у которых подошло время очередной попытки.
+// Письма сначала забираются в отправку (FOR UPDATE SKIP LOCKED и перенос next_attempt_at),
+// поэтому параллельные запуски на разных экземплярах не отправят одно письмо дважды.
+func ProcessQueue() {
+	now := time.Now()
+	items, err := claimQueue(now)
+	if err != nil {
+		log.Printf("Очередь писем: %v\n", err)
+		return
+	}
+
+	service := NewMailService()
+	for _, item := range items {
+		updates := map[string]interface{}{"attempts": item.Attempts + 1}
+		if err := service.send(item.Kind, item.To, item.Link); err != nil {
+			// 2, 4, 8... минут, но не реже раза в 6 часов
+			delay := time.Duration(1<<(item.Attempts+1)) * time.Minute
+			if delay > 6*time.Hour {
+				delay = 6 * time.Hour
+			}
+			updates["last_error"] = err.Error()
+			updates["next_attempt_at"] = now.Add(delay)
+		} else {
+			updates["last_error"] = ""
+			updates["sent_at"] = time.Now()
+		}
+		// Update, а не Save: письмо, которое за время отправки заменили новым, не должно вернуться
+		if err := config.DB.Model(&models.QueuedMail{}).Where("id = ?", item.ID).Updates(updates).Error; err != nil {
+			log.Printf("Очередь писем: %v\n", err)
+		}
+	}
+}
+
+// claimQueue забирает в отправку письма, у которых подошло время попытки: переносит их
+// next_attempt_at на queueClaimTimeout вперёд. Строки, заблокированные другим запуском, пропускаются.
+func claimQueue(now time.Time) ([]models.QueuedMail, error) {
+	var items []models.QueuedMail
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND attempts < ? AND next_attempt_at <= ?", maxQueueAttempts, now).
+			Order("id ASC").Limit(100).Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+		return tx.Model(&models.QueuedMail{}).Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(queueClaimTimeout)).Error
+	})
+	return items, err
+}
+
+func (m *MailService) send(kind, to, link string) error {
+	switch kind {
+	case KindActivation:
+		return m.SendActivationMail(to, link)
+	}
+	return fmt.Errorf("неизвестный вид письма: %s", kind)
+}
@@ -3,14 +3,22 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/joho/godotenv"
 
+	"diplom/billing"
 	"diplom/config"
+	"diplom/controllers"
+	"diplom/jobs"
+	"diplom/limiter"
 	"diplom/models"
+	"diplom/rbac"
 	"diplom/routes"
+	"diplom/utils"
 )
 
 func main() {
@@ -19,19 +27,51 @@ func main() {
 		log.Println("Нет файла .env, используем системные переменные")
 	}
 
+	if err := utils.LoadSigningKeys(); err != nil {
+		log.Fatal("Ошибка загрузки ключей подписи JWT: ", err)
+	}
+
 	db := config.InitDB()
 	config.DB = db
 
-	config.DB.AutoMigrate(&models.User{}, &models.Token{}, &models.Family{}, &models.FamilyInvitation{}, &models.Calendar{}, &models.Event{}, &models.FamilySubscription{}, &models.Payment{}, &models.ChatMessage{}, &models.Ticket{}, &models.TicketMessage{},)
+	config.DB.AutoMigrate(models.All()...)
+
+	// Встроенные роли и права
+	if err := rbac.Seed(config.DB); err != nil {
+		log.Println("Ошибка инициализации ролей и прав:", err)
+	}
+
+	// Каталог планов подписки
+	if err := billing.SeedPlans(config.DB); err != nil {
+		log.Println("Ошибка инициализации планов подписки:", err)
+	}
+
+	// Лимиты попыток входа: по умолчанию в памяти, RATE_LIMIT_STORE=db — общие для всех инстансов
+	if os.Getenv("RATE_LIMIT_STORE") == "db" {
+		controllers.UseRateLimitStore(limiter.NewDBStore(config.DB))
+	}
+
+	// События фоновых задач подписок — в ленту семьи, WebSocket и обработку платежей
+	billing.SetNotifier(controllers.SubscriptionNotifier{})
+
+	// Фоновые задачи
+	jobs.StartTokenCleanup(time.Hour)
+	jobs.StartMailQueue(time.Minute)
+	jobs.StartSubscriptionRenewal(time.Hour)
+	jobs.StartSubscriptionExpiry(time.Hour)
 
-	app := fiber.New()
+	// За обратным прокси реальный адрес клиента берётся из заголовка PROXY_HEADER (например, X-Real-IP):
+	// от него зависят лимиты попыток входа и проверка адресов уведомлений YooKassa.
+	// Заголовку верим только от прокси из TRUSTED_PROXIES (адреса и подсети через запятую),
+	// иначе любой клиент подставил бы в него чужой адрес
+	app := fiber.New(proxyConfig())
 
 	// CORS с указанием AllowOrigins и AllowCredentials
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:5173",
 		AllowCredentials: true,
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Authorization, Content-Type",
+		AllowHeaders:     "Authorization, Content-Type, Idempotence-Key",
 	}))
 
 	app.Static("/uploads", "./public/uploads")
@@ -46,3 +86,25 @@ func main() {
 	log.Printf("Сервер запускается на порту %s\n", port)
 	log.Fatal(app.Listen(":" + port))
 }
+
+// proxyConfig — настройки Fiber для работы за обратным прокси.
+func proxyConfig() fiber.Config {
+	header := os.Getenv("PROXY_HEADER")
+	if header == "" {
+		return fiber.Config{}
+	}
+	var trusted []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trusted = append(trusted, p)
+		}
+	}
+	if len(trusted) == 0 {
+		log.Println("PROXY_HEADER задан без TRUSTED_PROXIES: заголовок игнорируется, используется адрес соединения")
+	}
+	return fiber.Config{
+		ProxyHeader:             header,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          trusted,
+	}
+}
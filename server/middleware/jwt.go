@@ -1,29 +1,185 @@
 package middleware
 
 import (
-	"os"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/gofiber/websocket/v2"
+
+	"diplom/config"
+	"diplom/models"
+	"diplom/rbac"
+	"diplom/utils"
 )
 
-func JWTProtected() fiber.Handler {
+// authLocalsKey — ключ, под которым AuthContext лежит в c.Locals (и в Locals WebSocket-соединения).
+const authLocalsKey = "auth"
+
+// AuthContext — результат аутентификации запроса: проверенные claims и загруженные один раз
+// пользователь, его семья и роль. Роль берётся из базы, а не из токена, чтобы изменения
+// вступали в силу сразу.
+type AuthContext struct {
+	Claims *utils.AccessClaims // nil при входе по персональному токену
+	User   models.User
+	Family *models.Family // nil, если пользователь не состоит в семье
+	Role   string         // основная роль, для отображения; доступ проверяется по Permissions
+	// Права по всем ролям пользователя (см. пакет rbac). У персонального токена прав нет.
+	Permissions []string
+
+	// Персональный токен (см. PersonalTokenPrefix): id и его scopes. У обычной сессии TokenID == 0.
+	TokenID uint
+	Scopes  []string
+}
+
+// UserID — id текущего пользователя.
+func (a *AuthContext) UserID() uint {
+	return a.User.ID
+}
+
+// SessionID — сессия (цепочка refresh-токенов), которой выдан access-токен.
+// Для персонального токена — "pat:<id>", чтобы при отзыве можно было закрыть его WebSocket.
+func (a *AuthContext) SessionID() string {
+	if a.TokenID != 0 {
+		return PersonalTokenSessionID(a.TokenID)
+	}
+	return a.Claims.SessionID
+}
+
+// HasScope проверяет право персонального токена. Обычной сессии доступно всё.
+func (a *AuthContext) HasScope(scope string) bool {
+	if a.TokenID == 0 {
+		return true
+	}
+	for _, s := range a.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Can проверяет право пользователя.
+func (a *AuthContext) Can(permission string) bool {
+	return rbac.Has(a.Permissions, permission)
+}
+
+// Authenticate проверяет access-токен и загружает пользователя и семью.
+// Ошибка — *fiber.Error с кодом ответа.
+func Authenticate(tokenStr string) (*AuthContext, error) {
+	if tokenStr == "" {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Отсутствует JWT")
+	}
+	if strings.HasPrefix(tokenStr, PersonalTokenPrefix) {
+		return authenticatePersonalToken(tokenStr)
+	}
+	claims, err := utils.ParseAccessToken(tokenStr)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Неверный или просроченный JWT")
+	}
+	// Access-токен отозванной сессии больше не принимается
+	if claims.SessionID != "" && !SessionActive(claims.SessionID) {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Сессия завершена")
+	}
+
+	auth := &AuthContext{Claims: claims}
+	if err := loadUser(auth, claims.UserID); err != nil {
+		return nil, err
+	}
+	permissions, err := rbac.UserPermissions(config.DB, auth.User.ID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Ошибка загрузки прав")
+	}
+	auth.Permissions = permissions
+	return auth, nil
+}
+
+// loadUser загружает пользователя, его роль и семью в AuthContext.
+func loadUser(auth *AuthContext, userID uint) error {
+	if err := config.DB.First(&auth.User, userID).Error; err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Пользователь не найден")
+	}
+	auth.Role = auth.User.Role
+	if auth.User.FamilyID != 0 {
+		var family models.Family
+		if err := config.DB.First(&family, auth.User.FamilyID).Error; err == nil {
+			auth.Family = &family
+		}
+	}
+	return nil
+}
+
+// JWTProtected пропускает запрос только с действующим access-токеном в заголовке Authorization
+// и кладёт AuthContext в c.Locals (см. Auth и SocketAuth).
+// Браузер не может передать заголовок при открытии WebSocket, поэтому для запросов на апгрейд
+// токен принимается и из ?token= — проверка проходит до апгрейда, с обычным HTTP 401.
+//
+// Персональный токен принимается только там, где маршрут перечисляет scopes, и только если
+// у токена есть все они. Без scopes маршрут доступен лишь обычной сессии.
+func JWTProtected(scopes ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Отсутствует JWT"})
+		tokenStr, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok && websocket.IsWebSocketUpgrade(c) {
+			tokenStr = c.Query("token")
 		}
-		tokenStr := authHeader[len("Bearer "):]
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil // JWT_SECRET ?????
-		})
+
+		auth, err := Authenticate(tokenStr)
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Ошибка парсинга JWT: " + err.Error()})
+			var ferr *fiber.Error
+			if errors.As(err, &ferr) {
+				return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Ошибка аутентификации"})
+		}
+		if auth.TokenID != 0 {
+			if len(scopes) == 0 {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Персональный токен не даёт доступа к этому запросу"})
+			}
+			for _, scope := range scopes {
+				if !auth.HasScope(scope) {
+					return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "У токена нет права " + scope})
+				}
+			}
+			touchPersonalToken(auth.TokenID, c.IP())
+		}
+		c.Locals(authLocalsKey, auth)
+		return c.Next()
+	}
+}
+
+// RequirePermission пропускает запрос, только если у пользователя есть право permission.
+// Ставится после JWTProtected.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		auth := Auth(c)
+		if auth == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Требуется авторизация"})
 		}
-		if !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Неверный или просроченный JWT"})
+		if !auth.Can(permission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Нет доступа"})
 		}
-		c.Locals("user", token.Claims)
 		return c.Next()
 	}
 }
+
+// Auth возвращает AuthContext запроса, прошедшего JWTProtected, или nil.
+func Auth(c *fiber.Ctx) *AuthContext {
+	auth, _ := c.Locals(authLocalsKey).(*AuthContext)
+	return auth
+}
+
+// SocketAuth возвращает AuthContext WebSocket-соединения, открытого через JWTProtected, или nil.
+func SocketAuth(c *websocket.Conn) *AuthContext {
+	auth, _ := c.Locals(authLocalsKey).(*AuthContext)
+	return auth
+}
+
+// SessionActive сообщает, есть ли у сессии действующий refresh-токен.
+func SessionActive(sessionID string) bool {
+	var count int64
+	config.DB.Model(&models.Token{}).
+		Where("session_id = ? AND expires_at > ?", sessionID, time.Now()).
+		Count(&count)
+	return count > 0
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"diplom/config"
+	"diplom/models"
+	"diplom/utils"
+)
+
+// PersonalTokenPrefix отличает персональные токены от JWT в заголовке Authorization.
+const PersonalTokenPrefix = "fpat_"
+
+// Права персональных токенов
+const (
+	ScopeCalendarRead  = "calendar:read"
+	ScopeCalendarWrite = "calendar:write"
+	ScopeChatWrite     = "chat:write"
+)
+
+// PersonalTokenScopes — все права, которые можно выдать персональному токену.
+var PersonalTokenScopes = []string{ScopeCalendarRead, ScopeCalendarWrite, ScopeChatWrite}
+
+// lastUsedPrecision — чаще этого last_used_at не обновляется, чтобы не писать в базу на каждый запрос.
+const lastUsedPrecision = time.Minute
+
+// PersonalTokenSessionID — идентификатор, под которым отслеживаются WebSocket-соединения токена.
+func PersonalTokenSessionID(tokenID uint) string {
+	return "pat:" + strconv.FormatUint(uint64(tokenID), 10)
+}
+
+func authenticatePersonalToken(tokenStr string) (*AuthContext, error) {
+	var token models.PersonalAccessToken
+	if err := config.DB.Where("token_hash = ?", utils.HashToken(tokenStr)).First(&token).Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Неверный токен")
+	}
+	now := time.Now()
+	if token.RevokedAt != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Токен отозван")
+	}
+	if now.After(token.ExpiresAt) {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Срок действия токена истёк")
+	}
+
+	auth := &AuthContext{TokenID: token.ID, Scopes: strings.Fields(token.Scopes)}
+	if err := loadUser(auth, token.UserID); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// touchPersonalToken отмечает использование токена (не чаще раза в lastUsedPrecision).
+func touchPersonalToken(tokenID uint, ip string) {
+	now := time.Now()
+	config.DB.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", tokenID, now.Add(-lastUsedPrecision)).
+		Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip})
+}
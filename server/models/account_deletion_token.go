@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AccountDeletionToken — одноразовый токен подтверждения удаления аккаунта из письма.
+// Нужен тем, кто входит через внешнего провайдера и не знает своего пароля. Хранится только SHA-256 хэш.
+type AccountDeletionToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	TokenHash string    `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
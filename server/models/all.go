@@ -0,0 +1,38 @@
+package models
+
+// All — все модели в порядке миграции (config.DB.AutoMigrate в main.go, тесты).
+func All() []interface{} {
+	return []interface{}{
+		&User{},
+		&Role{},
+		&Permission{},
+		&UserRole{},
+		&Token{},
+		&PasswordResetToken{},
+		&AccountDeletionToken{},
+		&RecoveryCode{},
+		&LoginChallenge{},
+		&PersonalAccessToken{},
+		&ExternalIdentity{},
+		&OIDCLoginState{},
+		&RateLimitEntry{},
+		&QueuedMail{},
+		&Family{},
+		&FamilyInvitation{},
+		&FamilyJoinRequest{},
+		&FamilyActivity{},
+		&Calendar{},
+		&Event{},
+		&Plan{},
+		&FamilySubscription{},
+		&SubscriptionPeriod{},
+		&Payment{},
+		&PaymentEvent{},
+		&PaymentRefund{},
+		&PromoCode{},
+		&GiftCode{},
+		&ChatMessage{},
+		&Ticket{},
+		&TicketMessage{},
+	}
+}
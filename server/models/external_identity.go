@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// ExternalIdentity — привязка аккаунта к внешнему OIDC-провайдеру (Google, Яндекс и т.п.).
+// Пользователь определяется по паре провайдер + subject, а не по email.
+type ExternalIdentity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	Provider    string     `gorm:"size:50;not null;uniqueIndex:idx_provider_subject" json:"provider"`
+	Subject     string     `gorm:"size:255;not null;uniqueIndex:idx_provider_subject" json:"-"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// OIDCLoginState — начатый вход через провайдера: state из URL, PKCE verifier и nonce.
+// BrowserHash — хэш значения cookie браузера, начавшего вход: callback из другого браузера
+// не пройдёт. Используется один раз в callback.
+type OIDCLoginState struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	StateHash    string    `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	Provider     string    `gorm:"size:50;not null" json:"provider"`
+	CodeVerifier string    `gorm:"size:128;not null" json:"-"`
+	Nonce        string    `gorm:"size:64;not null" json:"-"`
+	BrowserHash  string    `gorm:"size:64" json:"-"`
+	ExpiresAt    time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
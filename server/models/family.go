@@ -3,9 +3,16 @@ package models
 import "time"
 
 type Family struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `gorm:"not null" json:"name"`
-	OwnerID   uint      `json:"owner_id"` // Пользователь, создавший семью
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	Name      string  `gorm:"not null" json:"name"`
+	OwnerID   uint    `json:"owner_id"` // Пользователь, создавший семью
+	AvatarURL *string `json:"avatar_url,omitempty"`
+
+	// Настройки семьи — значения по умолчанию для запросов календаря
+	TimeZone  string `gorm:"size:64;default:''" json:"time_zone"` // IANA, например "Europe/Moscow"; пусто — UTC
+	Locale    string `gorm:"size:16;default:'ru'" json:"locale"`  // BCP 47, например "ru" или "en-US"; задаёт первый день недели
+	WeekStart int    `gorm:"default:1" json:"week_start"`         // 0 — воскресенье, 1 — понедельник, ...
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
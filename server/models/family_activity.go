@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// FamilyActivity — запись ленты «что изменилось» в семье.
+type FamilyActivity struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	FamilyID uint   `gorm:"index;not null" json:"family_id"`
+	ActorID  uint   `json:"actor_id"`                     // кто совершил действие (0 — система)
+	Type     string `gorm:"size:50;not null" json:"type"` // event_created, event_completed, member_joined, member_left, calendar_created, subscription_activated ...
+	EntityID *uint  `json:"entity_id,omitempty"`          // ID события, календаря, пользователя и т.п.
+	Summary  string `gorm:"size:300" json:"summary"`      // короткое описание для ленты, например название события
+
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
@@ -3,10 +3,19 @@ package models
 import "time"
 
 type FamilyInvitation struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	FamilyID  uint      `json:"family_id"`                    // семья, в которую приглашают
-	Email     string    `gorm:"not null" json:"email"`        // email приглашённого
-	Token     string    `gorm:"unique;not null" json:"token"` // уникальный токен приглашения
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	FamilyID uint   `json:"family_id"`                    // семья, в которую приглашают
+	Email    string `gorm:"not null" json:"email"`        // email приглашённого (пустой для ссылки-приглашения)
+	Token    string `gorm:"unique;not null" json:"token"` // уникальный токен приглашения
+
+	// Поля ссылки-приглашения (IsLink == true): ссылку может открыть любой пользователь без семьи.
+	IsLink           bool       `gorm:"default:false" json:"is_link"`
+	CreatedBy        uint       `json:"created_by"`                             // владелец семьи, создавший ссылку
+	MaxUses          int        `gorm:"default:0" json:"max_uses"`              // 0 — без ограничения
+	UsedCount        int        `gorm:"default:0" json:"used_count"`            // сколько раз ссылкой уже воспользовались
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`                   // nil — бессрочная
+	RequiresApproval bool       `gorm:"default:false" json:"requires_approval"` // вступление только после одобрения владельцем
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// FamilyJoinRequest — заявка на вступление в семью по ссылке, требующей одобрения владельца.
+type FamilyJoinRequest struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	FamilyID     uint      `gorm:"index;not null" json:"family_id"`
+	InvitationID uint      `gorm:"not null" json:"invitation_id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"`
+	Status       string    `gorm:"size:20;not null" json:"status"` // pending, approved, rejected
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
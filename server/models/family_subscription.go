@@ -9,10 +9,26 @@ import (
 type FamilySubscription struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	FamilyID  uint      `json:"family_id"`
+	PlanID    *uint     `json:"plan_id"` // активный план; nil — подписка, купленная до появления планов
+	Plan      *Plan     `json:"plan,omitempty"`
 	IsActive  bool      `gorm:"default:false" json:"is_active"`
 	StartDate time.Time `json:"start_date"`
 	EndDate   time.Time `json:"end_date"`
 
+	// Автопродление по сохранённому способу оплаты (save_payment_method)
+	AutoRenew          bool       `gorm:"default:false" json:"auto_renew"`
+	AutoRenewUserID    *uint      `json:"auto_renew_user_id"` // чей способ оплаты сохранён
+	PaymentMethodID    string     `json:"-"`
+	PaymentMethodTitle string     `json:"payment_method_title,omitempty"`
+	RenewalAttempts    int        `gorm:"default:0" json:"renewal_attempts"` // неудачные попытки списания подряд
+	NextRenewalAt      *time.Time `json:"next_renewal_at"`                   // следующая попытка после неудачи
+	LastRenewalError   string     `json:"last_renewal_error,omitempty"`      // причина последнего отказа
+	ReminderSentFor    *time.Time `json:"-"`                                 // EndDate, о списании перед которой уже напомнили
+
+	// Уведомления об окончании: для какой EndDate и за сколько дней отправлено последнее (0 — начался льготный срок)
+	ExpiryNoticeFor  *time.Time `json:"-"`
+	ExpiryNoticeDays int        `json:"-"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// GiftCode — подарочная подписка: пользователь оплачивает план, а код активирует
+// любая семья. Код показывается покупателю только после оплаты.
+type GiftCode struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	Code             string     `gorm:"uniqueIndex;size:32;not null" json:"code,omitempty"`
+	PlanID           uint       `gorm:"not null" json:"plan_id"`
+	Plan             *Plan      `json:"plan,omitempty"`
+	BuyerID          uint       `gorm:"index;not null" json:"buyer_id"`
+	Status           string     `gorm:"size:16;default:pending" json:"status"` // pending, paid, redeemed, canceled
+	RedeemedFamilyID *uint      `json:"redeemed_family_id"`
+	RedeemedByID     *uint      `json:"redeemed_by_id"`
+	RedeemedAt       *time.Time `json:"redeemed_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// QueuedMail — письмо, которое не удалось отправить сразу (например, SMTP недоступен).
+// Фоновая задача повторяет отправку с растущим интервалом.
+type QueuedMail struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	To            string     `gorm:"not null" json:"to"`
+	Kind          string     `gorm:"size:50;not null" json:"kind"` // см. константы в пакете mail
+	Link          string     `json:"-"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index" json:"next_attempt_at"`
+	LastError     string     `json:"last_error"`
+	SentAt        *time.Time `gorm:"index" json:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
@@ -6,17 +6,41 @@ import (
 	"gorm.io/gorm"
 )
 
-// Payment хранит связь payment_id (YooKassa) -> family_id / user_id
+// Payment хранит связь payment_id (у платёжного провайдера) -> family_id / user_id
 type Payment struct {
 	ID        uint   `gorm:"primaryKey" json:"id"`
 	PaymentID string `gorm:"uniqueIndex;not null" json:"payment_id"`
+	Provider  string `gorm:"size:32;default:yookassa" json:"provider"` // yookassa, fake
 	FamilyID  uint   `gorm:"not null" json:"family_id"`
 	UserID    uint   `json:"user_id"`
 
-	Amount string `json:"amount"` // "199.00"
-	Status string `json:"status"` // pending, succeeded, canceled...
+	PlanID   *uint  `json:"plan_id"`
+	Amount   string `json:"amount"` // "199.00"
+	Currency string `gorm:"size:3;default:RUB" json:"currency"`
+	Status   string `json:"status"` // pending, succeeded, canceled, refunded (возвращён полностью)
+
+	Recurring bool `gorm:"default:false" json:"recurring"` // автоплатёж по сохранённому способу оплаты
+
+	PromoCodeID *uint  `json:"promo_code_id"`
+	Discount    string `json:"discount,omitempty"` // скидка по промокоду, "50.00"
+	GiftCodeID  *uint  `json:"gift_code_id"`       // оплата подарочной подписки, а не своей
+
+	// Чек (54-ФЗ): что передано провайдеру и статус регистрации чека
+	ReceiptEmail  string        `json:"receipt_email,omitempty"`
+	ReceiptItems  []ReceiptItem `gorm:"serializer:json;type:text" json:"receipt_items,omitempty"`
+	ReceiptStatus string        `json:"receipt_status,omitempty"` // pending, succeeded, canceled; пусто — без чека
+
+	Refunds []PaymentRefund `json:"refunds,omitempty"`
 
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
+
+// ReceiptItem — позиция чека в том виде, в каком она передана провайдеру.
+type ReceiptItem struct {
+	Description string `json:"description"`
+	Quantity    string `json:"quantity"`
+	Amount      string `json:"amount"` // цена за единицу, "300.00"
+	VATCode     int    `json:"vat_code"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PaymentEvent — обработанное уведомление о платеже. Уникальный EventKey
+// (событие + id платежа) не даёт применить одно и то же уведомление дважды.
+type PaymentEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	EventKey  string    `gorm:"uniqueIndex;not null" json:"event_key"`
+	PaymentID string    `gorm:"index;not null" json:"payment_id"`
+	Event     string    `json:"event"`  // payment.succeeded, payment.canceled...
+	Status    string    `json:"status"` // статус, полученный из API YooKassa
+	SourceIP  string    `json:"source_ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
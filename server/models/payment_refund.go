@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PaymentRefund — возврат по платежу (запись в журнале платежей). Возвратов по одному
+// платежу может быть несколько: частичные возвраты суммируются.
+type PaymentRefund struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	RefundID    string    `gorm:"uniqueIndex;not null" json:"refund_id"` // id возврата у провайдера
+	PaymentID   uint      `gorm:"index;not null" json:"payment_id"`      // models.Payment.ID
+	FamilyID    uint      `gorm:"index;not null" json:"family_id"`
+	Amount      string    `json:"amount"` // "100.00"
+	Currency    string    `gorm:"size:3;default:RUB" json:"currency"`
+	Status      string    `json:"status"` // pending, succeeded, canceled
+	Description string    `json:"description"`
+	CreatedByID *uint     `json:"created_by_id"` // администратор; nil — возврат оформлен в личном кабинете провайдера
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	ReceiptStatus string `json:"receipt_status,omitempty"` // регистрация чека возврата: pending, succeeded, canceled
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PersonalAccessToken — долгоживущий токен для скриптов и интеграций с ограниченным набором прав (scopes).
+// Хранится только SHA-256 хэш; сам токен показывается пользователю один раз при создании.
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	TokenHash  string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	Prefix     string     `gorm:"size:16" json:"prefix"`  // начало токена, чтобы пользователь узнал его в списке
+	Scopes     string     `gorm:"not null" json:"scopes"` // через пробел, например "calendar:read calendar:write"
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"size:64" json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Plan — тарифный план подписки: цена, срок и доступные возможности.
+type Plan struct {
+	ID             uint     `gorm:"primaryKey" json:"id"`
+	Code           string   `gorm:"uniqueIndex;size:50;not null" json:"code"` // monthly, yearly
+	Name           string   `gorm:"not null" json:"name"`
+	Description    string   `json:"description"`
+	Price          string   `gorm:"not null" json:"price"` // "300.00"
+	Currency       string   `gorm:"size:3;default:RUB" json:"currency"`
+	DurationMonths int      `gorm:"not null" json:"duration_months"`
+	Features       []string `gorm:"serializer:json;type:text" json:"features"` // см. billing.Feature*
+	IsActive       bool     `gorm:"default:true" json:"is_active"`             // неактивный план нельзя купить
+	SortOrder      int      `gorm:"default:0" json:"sort_order"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PromoCode — промокод на скидку при покупке подписки. Скидка задаётся либо процентом
+// от цены плана (DiscountPercent), либо фиксированной суммой (DiscountAmount).
+type PromoCode struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	Code            string     `gorm:"uniqueIndex;size:50;not null" json:"code"` // хранится в верхнем регистре
+	Description     string     `json:"description"`
+	DiscountPercent int        `gorm:"default:0" json:"discount_percent"` // 1–99
+	DiscountAmount  string     `json:"discount_amount,omitempty"`         // "100.00"
+	MaxRedemptions  int        `gorm:"default:0" json:"max_redemptions"`  // 0 — без ограничения
+	Redemptions     int        `gorm:"default:0" json:"redemptions"`      // покупки с промокодом: оплаченные и ожидающие оплаты
+	ValidFrom       *time.Time `json:"valid_from"`
+	ValidUntil      *time.Time `json:"valid_until"`
+	IsActive        bool       `gorm:"default:true" json:"is_active"`
+	CreatedByID     uint       `json:"created_by_id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RateLimitEntry — общий для инстансов счётчик неудачных попыток (см. limiter.DBStore).
+type RateLimitEntry struct {
+	Key          string    `gorm:"primaryKey;size:255" json:"key"` // например "login:email:user@example.com"
+	Failures     int       `json:"failures"`
+	WindowStart  time.Time `json:"window_start"`
+	BlockedUntil time.Time `json:"blocked_until"`
+	LockedUntil  time.Time `json:"locked_until"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Role — роль пользователя. Права роли задаются связями RolePermission.
+// Priority определяет «основную» роль пользователя (User.Role), если ролей несколько.
+type Role struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	Name        string       `gorm:"uniqueIndex;size:50;not null" json:"name"`
+	Description string       `json:"description"`
+	Priority    int          `gorm:"default:0" json:"priority"`
+	BuiltIn     bool         `gorm:"default:false" json:"built_in"` // встроенные роли нельзя удалить или переименовать
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// Permission — отдельное право, например "payments:read".
+type Permission struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Code        string `gorm:"uniqueIndex;size:100;not null" json:"code"`
+	Description string `json:"description"`
+}
+
+// UserRole — выданная пользователю роль. Базовая роль "user" есть у всех и не хранится.
+type UserRole struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	RoleID    uint      `gorm:"primaryKey" json:"role_id"`
+	Role      Role      `json:"role"`
+	GrantedBy uint      `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// SubscriptionPeriod — оплаченный период подписки семьи. Периоды идут друг за другом:
+// продление до окончания текущего начинается с его EndDate. Кроме оплаченных бывают
+// пробный период и подарочный (PaymentID — оплата подарка).
+type SubscriptionPeriod struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	FamilyID       uint      `gorm:"index;not null" json:"family_id"`
+	SubscriptionID uint      `gorm:"index;not null" json:"subscription_id"`
+	PaymentID      *uint     `gorm:"uniqueIndex" json:"payment_id"` // один период на платёж
+	Payment        *Payment  `json:"payment,omitempty"`
+	Source         string    `gorm:"size:16;default:payment" json:"source"` // payment, trial, gift
+	PlanID         *uint     `json:"plan_id"`
+	Plan           *Plan     `json:"plan,omitempty"`
+	StartDate      time.Time `json:"start_date"`
+	EndDate        time.Time `json:"end_date"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -7,11 +7,22 @@ import (
 )
 
 type Token struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	UserID    uint           `gorm:"not null" json:"user_id"`
-	Token     string         `gorm:"unique;not null" json:"token"`
-	ExpiresAt time.Time      `gorm:"not null" json:"expires_at"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-}
\ No newline at end of file
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"not null" json:"user_id"`
+	Token  string `gorm:"unique;not null" json:"token"`
+	// SessionID объединяет цепочку refresh-токенов одного входа (token family):
+	// при каждом обновлении выдаётся новый токен с тем же SessionID.
+	SessionID string `gorm:"size:36;index" json:"session_id"`
+	// RotatedAt — когда токен заменён новым. Повторное предъявление такого токена
+	// означает кражу, и вся цепочка отзывается.
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	// Данные устройства на момент последнего обновления; StartedAt переносится по цепочке
+	UserAgent  string         `gorm:"size:255" json:"user_agent"`
+	IP         string         `gorm:"size:64" json:"ip"`
+	StartedAt  time.Time      `json:"started_at"`             // время входа, с которого началась цепочка
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty"` // последнее обновление токенов этой сессии
+	ExpiresAt  time.Time      `gorm:"not null" json:"expires_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
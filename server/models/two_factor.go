@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// RecoveryCode — одноразовый код восстановления на случай потери устройства с TOTP.
+// Хранится только SHA-256 хэш кода.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginChallenge — второй шаг входа: пароль уже проверен, ждём код 2FA.
+// Пока челлендж не пройден, access и refresh токены не выдаются.
+type LoginChallenge struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	TokenHash string    `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	Attempts  int       `gorm:"default:0" json:"attempts"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -7,15 +7,30 @@ import (
 )
 
 type User struct {
-	ID             uint           `gorm:"primaryKey" json:"id"`
-	Name           string         `gorm:"size:100;not null" json:"name"`
-	Email          string         `gorm:"unique;not null" json:"email"`
-	Password       string         `gorm:"not null" json:"-"` // пароль не возвращается в JSON
-	Role           string         `gorm:"size:50;default:'user'" json:"role"`
-	FamilyID       uint           `json:"family_id"` // Если 0, то семья не создана
-	IsActivated    bool           `gorm:"default:false" json:"isActivated"`
-	ActivationLink string         `json:"activationLink"`
-	CreatedAt      time.Time      `json:"created_at"`
-	UpdatedAt      time.Time      `json:"updated_at"`
-	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
-}
\ No newline at end of file
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	Name           string `gorm:"size:100;not null" json:"name"`
+	Email          string `gorm:"unique;not null" json:"email"`
+	Password       string `gorm:"not null" json:"-"` // пароль не возвращается в JSON
+	Role           string `gorm:"size:50;default:'user'" json:"role"`
+	FamilyID       uint   `json:"family_id"` // Если 0, то семья не создана
+	IsActivated    bool   `gorm:"default:false" json:"isActivated"`
+	ActivationLink string `gorm:"index" json:"activationLink"`
+	// Срок действия ссылки активации; nil у старых записей — тогда срок считается от CreatedAt
+	ActivationExpiresAt *time.Time `json:"-"`
+	AvatarURL           *string    `json:"avatar_url,omitempty"`
+
+	// Смена email: новый адрес применяется только после подтверждения по ссылке из письма
+	PendingEmail         string     `json:"pending_email,omitempty"`
+	EmailChangeToken     string     `gorm:"index" json:"-"`
+	EmailChangeExpiresAt *time.Time `json:"-"`
+
+	// Двухфакторная аутентификация (TOTP)
+	TwoFactorEnabled  bool   `gorm:"default:false" json:"two_factor_enabled"`
+	TwoFactorRequired bool   `gorm:"default:false" json:"two_factor_required"` // выставляет администратор или оператор
+	TOTPSecret        string `gorm:"size:64" json:"-"`
+	TOTPLastStep      int64  `json:"-"` // последний принятый шаг TOTP — защита от повторного использования кода
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
@@ -0,0 +1,47 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrBadAmount = errors.New("некорректная сумма")
+
+// ParseAmount переводит сумму вида "300.00" в копейки.
+func ParseAmount(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	whole, frac, _ := strings.Cut(value, ".")
+	if whole == "" || len(frac) > 2 {
+		return 0, ErrBadAmount
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	rub, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || rub < 0 {
+		return 0, ErrBadAmount
+	}
+	kop, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || kop < 0 {
+		return 0, ErrBadAmount
+	}
+	return rub*100 + kop, nil
+}
+
+// FormatAmount переводит копейки в строку "300.00".
+func FormatAmount(kopecks int64) string {
+	return fmt.Sprintf("%d.%02d", kopecks/100, kopecks%100)
+}
+
+// SameAmount сравнивает суммы по значению: "300", "300.0" и "300.00" равны.
+// Некорректная сумма не равна никакой.
+func SameAmount(a, b string) bool {
+	x, err := ParseAmount(a)
+	if err != nil {
+		return false
+	}
+	y, err := ParseAmount(b)
+	return err == nil && x == y
+}
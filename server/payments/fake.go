@@ -0,0 +1,191 @@
+package payments
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Fake — провайдер для локальной разработки: платежи хранятся в памяти, сеть не нужна.
+// Страница оплаты — CheckoutURL + "/" + id; обработчик этой страницы вызывает Complete
+// и дальше платёж проходит тот же путь, что и уведомление настоящего провайдера.
+// Автоплатёж по сохранённому способу проходит сразу; DeclineRecurring — отклоняется (проверка повторов).
+type Fake struct {
+	CheckoutURL      string
+	DeclineRecurring bool
+
+	mu       sync.Mutex
+	payments map[string]*Payment
+	byKey    map[string]string // idempotence key -> id платежа
+	savePM   map[string]bool   // платежи, для которых запрошено сохранение способа оплаты
+	receipts map[string]bool   // платежи, переданные с чеком
+	methods  map[string]bool   // сохранённые способы оплаты
+	refunded map[string]int64  // сумма возвратов по платежу, в копейках
+	refunds  map[string]*Refund
+	refundBy map[string]string // idempotence key -> id возврата
+}
+
+// NewFake создаёт тестового провайдера. Адрес страницы оплаты — PAYMENT_FAKE_CHECKOUT_URL,
+// по умолчанию http://localhost:8080/api/subscription/fake/checkout.
+// PAYMENT_FAKE_DECLINE_RECURRING=1 — отклонять автоплатежи.
+func NewFake() *Fake {
+	checkout := strings.TrimRight(os.Getenv("PAYMENT_FAKE_CHECKOUT_URL"), "/")
+	if checkout == "" {
+		checkout = "http://localhost:8080/api/subscription/fake/checkout"
+	}
+	return &Fake{
+		CheckoutURL:      checkout,
+		DeclineRecurring: os.Getenv("PAYMENT_FAKE_DECLINE_RECURRING") == "1",
+		payments:         map[string]*Payment{},
+		byKey:            map[string]string{},
+		savePM:           map[string]bool{},
+		receipts:         map[string]bool{},
+		methods:          map[string]bool{},
+		refunded:         map[string]int64{},
+		refunds:          map[string]*Refund{},
+		refundBy:         map[string]string{},
+	}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) CreatePayment(_ context.Context, req CreatePaymentRequest) (*Payment, error) {
+	if _, err := ParseAmount(req.Amount.Value); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.byKey[req.IdempotenceKey]; ok && req.IdempotenceKey != "" {
+		out := *f.payments[id]
+		return &out, nil
+	}
+
+	id := "fake-" + uuid.New().String()
+	p := &Payment{
+		ID:       id,
+		Status:   StatusPending,
+		Amount:   req.Amount,
+		Metadata: req.Metadata,
+	}
+	if req.Receipt != nil {
+		p.ReceiptStatus = StatusPending
+		f.receipts[id] = true
+	}
+	if req.PaymentMethodID != "" {
+		// Автоплатёж: без подтверждения пользователем
+		p.PaymentMethodID = req.PaymentMethodID
+		p.PaymentMethodTitle = "Тестовая карта *4242"
+		if f.methods[req.PaymentMethodID] && !f.DeclineRecurring {
+			p.Status, p.Paid = StatusSucceeded, true
+			if f.receipts[id] {
+				p.ReceiptStatus = StatusSucceeded
+			}
+		} else {
+			p.Status, p.CancellationReason = StatusCanceled, "insufficient_funds"
+		}
+	} else {
+		p.ConfirmationURL = f.CheckoutURL + "/" + id
+		f.savePM[id] = req.SavePaymentMethod
+	}
+	f.payments[id] = p
+	if req.IdempotenceKey != "" {
+		f.byKey[req.IdempotenceKey] = id
+	}
+
+	out := *p
+	return &out, nil
+}
+
+func (f *Fake) GetPayment(_ context.Context, id string) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[id]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	out := *p
+	return &out, nil
+}
+
+// Complete завершает ожидающий платёж: succeeded — оплачен, canceled — отменён.
+func (f *Fake) Complete(id, status string) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[id]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	if p.Status == StatusPending {
+		p.Status = status
+		p.Paid = status == StatusSucceeded
+		if f.receipts[id] {
+			p.ReceiptStatus = status // чек регистрируется только у оплаченного платежа
+		}
+		if p.Paid && f.savePM[id] {
+			p.PaymentMethodID = "fake-pm-" + uuid.New().String()
+			p.PaymentMethodSaved = true
+			p.PaymentMethodTitle = "Тестовая карта *4242"
+			f.methods[p.PaymentMethodID] = true
+		}
+	}
+	out := *p
+	return &out, nil
+}
+
+func (f *Fake) Refund(_ context.Context, req RefundRequest) (*Refund, error) {
+	amount, err := ParseAmount(req.Amount.Value)
+	if err != nil || amount <= 0 {
+		return nil, ErrBadAmount
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.refundBy[req.IdempotenceKey]; ok && req.IdempotenceKey != "" {
+		out := *f.refunds[id]
+		return &out, nil
+	}
+	p, ok := f.payments[req.PaymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	total, _ := ParseAmount(p.Amount.Value)
+	if p.Status != StatusSucceeded || f.refunded[p.ID]+amount > total {
+		return nil, ErrRefundNotAllowed
+	}
+	f.refunded[p.ID] += amount
+	r := &Refund{
+		ID:        "fake-refund-" + uuid.New().String(),
+		PaymentID: p.ID,
+		Status:    StatusSucceeded,
+		Amount:    req.Amount,
+	}
+	if req.Receipt != nil {
+		r.ReceiptStatus = StatusSucceeded
+	}
+	f.refunds[r.ID] = r
+	if req.IdempotenceKey != "" {
+		f.refundBy[req.IdempotenceKey] = r.ID
+	}
+	out := *r
+	return &out, nil
+}
+
+func (f *Fake) GetRefund(_ context.Context, id string) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, ok := f.refunds[id]
+	if !ok {
+		return nil, ErrRefundNotFound
+	}
+	out := *r
+	return &out, nil
+}
+
+// ParseWebhook принимает уведомления в формате YooKassa с любого адреса.
+func (f *Fake) ParseWebhook(body []byte, sourceIP string) (*Notification, error) {
+	return parseNotification(body, sourceIP)
+}
@@ -0,0 +1,154 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Статусы платежа (совпадают со статусами YooKassa)
+const (
+	StatusPending           = "pending"
+	StatusWaitingForCapture = "waiting_for_capture"
+	StatusSucceeded         = "succeeded"
+	StatusCanceled          = "canceled"
+
+	// StatusRefunded — наш статус платежа, возвращённого полностью (у провайдера он остаётся succeeded)
+	StatusRefunded = "refunded"
+)
+
+var (
+	ErrPaymentNotFound  = errors.New("платёж не найден у провайдера")
+	ErrRefundNotFound   = errors.New("возврат не найден у провайдера")
+	ErrUntrustedSource  = errors.New("уведомление пришло с недоверенного адреса")
+	ErrBadNotification  = errors.New("некорректное уведомление")
+	ErrRefundNotAllowed = errors.New("возврат по этому платежу невозможен")
+)
+
+// Amount — сумма в виде строки с двумя знаками после точки ("300.00") и код валюты.
+type Amount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// Payment — состояние платежа у провайдера.
+type Payment struct {
+	ID              string
+	Status          string
+	Paid            bool
+	Amount          Amount
+	ConfirmationURL string // куда отправить пользователя для оплаты
+	Metadata        map[string]string
+
+	// Сохранённый способ оплаты для автоплатежей (если запрошен SavePaymentMethod)
+	PaymentMethodID    string
+	PaymentMethodSaved bool
+	PaymentMethodTitle string // например "Bank card *4444"
+
+	CancellationReason string // для StatusCanceled: insufficient_funds, card_expired...
+
+	ReceiptStatus string // регистрация чека: pending, succeeded, canceled; "" — чек не передавался
+}
+
+// Receipt — чек по 54-ФЗ: контакт покупателя и позиции.
+type Receipt struct {
+	CustomerEmail string
+	CustomerName  string
+	Items         []ReceiptItem
+	TaxSystemCode int // 0 — не передаётся (у магазина одна система налогообложения)
+}
+
+// ReceiptItem — позиция чека. Amount — цена за единицу.
+type ReceiptItem struct {
+	Description    string
+	Quantity       string // "1.00"
+	Amount         Amount
+	VATCode        int    // код ставки НДС в YooKassa: 1 — без НДС, 2 — 0%, 4 — 20%...
+	PaymentSubject string // service
+	PaymentMode    string // full_payment
+}
+
+// CreatePaymentRequest — параметры нового платежа.
+// SavePaymentMethod — сохранить способ оплаты для автоплатежей.
+// PaymentMethodID — списать по сохранённому способу без участия пользователя (ReturnURL не нужен).
+type CreatePaymentRequest struct {
+	Amount            Amount
+	Description       string
+	ReturnURL         string
+	Metadata          map[string]string
+	IdempotenceKey    string
+	SavePaymentMethod bool
+	PaymentMethodID   string
+	Receipt           *Receipt // nil — без чека
+}
+
+// RefundRequest — параметры возврата. Amount может быть меньше суммы платежа (частичный возврат).
+type RefundRequest struct {
+	PaymentID      string
+	Amount         Amount
+	Description    string
+	IdempotenceKey string
+	Receipt        *Receipt // чек возврата; нужен, если чек передавался с платежом
+}
+
+// Refund — возврат у провайдера.
+type Refund struct {
+	ID        string
+	PaymentID string
+	Status    string
+	Amount    Amount
+
+	ReceiptStatus string
+}
+
+// Notification — разобранное уведомление провайдера. Статусу из уведомления не доверяем:
+// актуальное состояние нужно запросить через GetPayment / GetRefund.
+type Notification struct {
+	Event     string // payment.succeeded, payment.canceled, refund.succeeded...
+	PaymentID string
+	RefundID  string // только для уведомлений о возврате
+	SourceIP  string
+}
+
+// Provider — платёжный шлюз.
+type Provider interface {
+	Name() string
+	CreatePayment(ctx context.Context, req CreatePaymentRequest) (*Payment, error)
+	GetPayment(ctx context.Context, id string) (*Payment, error)
+	Refund(ctx context.Context, req RefundRequest) (*Refund, error)
+	GetRefund(ctx context.Context, id string) (*Refund, error)
+	// ParseWebhook проверяет источник уведомления и разбирает тело.
+	ParseWebhook(body []byte, sourceIP string) (*Notification, error)
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultProvider Provider
+)
+
+// Default возвращает провайдера из PAYMENT_PROVIDER: "yookassa" (по умолчанию) или "fake".
+// Создаётся при первом обращении, после загрузки .env.
+func Default() Provider {
+	defaultOnce.Do(func() {
+		switch name := strings.ToLower(strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER"))); name {
+		case "", "yookassa":
+			defaultProvider = NewYooKassa()
+		case "fake":
+			log.Println("Платежи: используется тестовый провайдер fake, реальные деньги не списываются")
+			defaultProvider = NewFake()
+		default:
+			log.Printf("Платежи: неизвестный PAYMENT_PROVIDER=%q, используется yookassa\n", name)
+			defaultProvider = NewYooKassa()
+		}
+	})
+	return defaultProvider
+}
+
+// SetDefault заменяет провайдера, которого возвращает Default (тесты, заглушки).
+func SetDefault(p Provider) {
+	defaultOnce.Do(func() {})
+	defaultProvider = p
+}
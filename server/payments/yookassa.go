@@ -0,0 +1,161 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"diplom/yookassa"
+)
+
+// YooKassa — провайдер поверх API YooKassa.
+type YooKassa struct {
+	client *yookassa.Client
+}
+
+// NewYooKassa создаёт провайдера с настройками из окружения (см. yookassa.NewFromEnv).
+func NewYooKassa() *YooKassa {
+	return &YooKassa{client: yookassa.NewFromEnv()}
+}
+
+func (y *YooKassa) Name() string { return "yookassa" }
+
+func (y *YooKassa) CreatePayment(ctx context.Context, req CreatePaymentRequest) (*Payment, error) {
+	in := yookassa.CreatePaymentRequest{
+		Amount:            yookassa.Amount(req.Amount),
+		Capture:           true,
+		Description:       req.Description,
+		Metadata:          req.Metadata,
+		SavePaymentMethod: req.SavePaymentMethod,
+		PaymentMethodID:   req.PaymentMethodID,
+		Receipt:           toYooKassaReceipt(req.Receipt),
+	}
+	if req.PaymentMethodID == "" {
+		in.Confirmation = &yookassa.Confirmation{Type: "redirect", ReturnURL: req.ReturnURL}
+	}
+
+	p, err := y.client.CreatePayment(ctx, in, req.IdempotenceKey)
+	if err != nil {
+		return nil, err
+	}
+	return fromYooKassa(p), nil
+}
+
+func (y *YooKassa) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	p, err := y.client.GetPayment(ctx, id)
+	if errors.Is(err, yookassa.ErrPaymentNotFound) {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromYooKassa(p), nil
+}
+
+func (y *YooKassa) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	r, err := y.client.CreateRefund(ctx, yookassa.CreateRefundRequest{
+		PaymentID:   req.PaymentID,
+		Amount:      yookassa.Amount(req.Amount),
+		Description: req.Description,
+		Receipt:     toYooKassaReceipt(req.Receipt),
+	}, req.IdempotenceKey)
+	if err != nil {
+		return nil, err
+	}
+	return fromYooKassaRefund(r), nil
+}
+
+func (y *YooKassa) GetRefund(ctx context.Context, id string) (*Refund, error) {
+	r, err := y.client.GetRefund(ctx, id)
+	if errors.Is(err, yookassa.ErrRefundNotFound) {
+		return nil, ErrRefundNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromYooKassaRefund(r), nil
+}
+
+// ParseWebhook принимает уведомления только с адресов YooKassa (см. yookassa.TrustedNotificationIP).
+func (y *YooKassa) ParseWebhook(body []byte, sourceIP string) (*Notification, error) {
+	if !yookassa.TrustedNotificationIP(sourceIP) {
+		return nil, ErrUntrustedSource
+	}
+	return parseNotification(body, sourceIP)
+}
+
+// parseNotification разбирает уведомление в формате YooKassa: для refund.* объект — возврат
+// со ссылкой на платёж (payment_id), для остальных событий — сам платёж.
+func parseNotification(body []byte, sourceIP string) (*Notification, error) {
+	var cb struct {
+		Event  string `json:"event"`
+		Object struct {
+			ID        string `json:"id"`
+			PaymentID string `json:"payment_id"`
+		} `json:"object"`
+	}
+	if err := json.Unmarshal(body, &cb); err != nil || cb.Object.ID == "" {
+		return nil, ErrBadNotification
+	}
+	n := &Notification{Event: cb.Event, PaymentID: cb.Object.ID, SourceIP: sourceIP}
+	if strings.HasPrefix(cb.Event, "refund.") {
+		if cb.Object.PaymentID == "" {
+			return nil, ErrBadNotification
+		}
+		n.RefundID, n.PaymentID = cb.Object.ID, cb.Object.PaymentID
+	}
+	return n, nil
+}
+
+func fromYooKassa(p *yookassa.Payment) *Payment {
+	out := &Payment{
+		ID:              p.ID,
+		Status:          p.Status,
+		Paid:            p.Paid,
+		Amount:          Amount(p.Amount),
+		ConfirmationURL: p.Confirmation.ConfirmationURL,
+		Metadata:        p.Metadata,
+		ReceiptStatus:   p.ReceiptRegistration,
+	}
+	if p.PaymentMethod != nil {
+		out.PaymentMethodID = p.PaymentMethod.ID
+		out.PaymentMethodSaved = p.PaymentMethod.Saved
+		out.PaymentMethodTitle = p.PaymentMethod.Title
+	}
+	if p.CancellationDetails != nil {
+		out.CancellationReason = p.CancellationDetails.Reason
+	}
+	return out
+}
+
+func fromYooKassaRefund(r *yookassa.Refund) *Refund {
+	return &Refund{
+		ID:            r.ID,
+		PaymentID:     r.PaymentID,
+		Status:        r.Status,
+		Amount:        Amount(r.Amount),
+		ReceiptStatus: r.ReceiptRegistration,
+	}
+}
+
+func toYooKassaReceipt(r *Receipt) *yookassa.Receipt {
+	if r == nil {
+		return nil
+	}
+	out := &yookassa.Receipt{
+		Customer:      &yookassa.Customer{Email: r.CustomerEmail, FullName: r.CustomerName},
+		TaxSystemCode: r.TaxSystemCode,
+	}
+	for _, item := range r.Items {
+		out.Items = append(out.Items, yookassa.ReceiptItem{
+			Description:    item.Description,
+			Quantity:       item.Quantity,
+			Amount:         yookassa.Amount(item.Amount),
+			VatCode:        item.VATCode,
+			PaymentSubject: item.PaymentSubject,
+			PaymentMode:    item.PaymentMode,
+		})
+	}
+	return out
+}
@@ -0,0 +1,219 @@
+package rbac
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"diplom/models"
+)
+
+// Права
+const (
+	PaymentsRead   = "payments:read"   // история платежей всех пользователей
+	PaymentsRefund = "payments:refund" // возвраты по платежам
+	PromoManage    = "promo:manage"    // промокоды на скидку
+	SupportOperate = "support:operate" // очередь тикетов, ответы от имени поддержки
+	SupportManage  = "support:manage"  // доступ к любому тикету, независимо от назначенного оператора
+	UsersTwoFactor = "users:2fa"       // требовать от пользователя вход с 2FA
+	RolesManage    = "roles:manage"    // роли, их права и выдача ролей пользователям
+)
+
+// DefaultRole — базовая роль, которая есть у каждого пользователя.
+const DefaultRole = "user"
+
+var (
+	ErrUnknownRole     = errors.New("роль не найдена")
+	ErrLastRoleManager = errors.New("нельзя лишить права roles:manage последнего пользователя с ним")
+)
+
+var builtinPermissions = []models.Permission{
+	{Code: PaymentsRead, Description: "Просмотр истории платежей"},
+	{Code: PaymentsRefund, Description: "Возвраты по платежам"},
+	{Code: PromoManage, Description: "Управление промокодами"},
+	{Code: SupportOperate, Description: "Работа с тикетами поддержки"},
+	{Code: SupportManage, Description: "Доступ к любому тикету поддержки"},
+	{Code: UsersTwoFactor, Description: "Обязательная 2FA для пользователей"},
+	{Code: RolesManage, Description: "Управление ролями и правами"},
+}
+
+var builtinRoles = []struct {
+	Name        string
+	Description string
+	Priority    int
+	Permissions []string
+}{
+	{DefaultRole, "Пользователь", 0, nil},
+	{"operator", "Оператор поддержки", 10, []string{SupportOperate, UsersTwoFactor}},
+	{"admin", "Администратор", 100, nil}, // получает все права, см. Seed
+}
+
+// Seed создаёт недостающие встроенные права и роли. Права уже существующих ролей не трогает —
+// кроме admin, которому всегда выдаются все права, включая добавленные в новых версиях.
+// Затем переносит старые значения users.role в user_roles — так первый администратор
+// назначается прямо в базе (users.role = 'admin') и получает роль при следующем запуске.
+func Seed(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		perms := map[string]models.Permission{}
+		for _, p := range builtinPermissions {
+			perm := p
+			if err := tx.Where(models.Permission{Code: p.Code}).Attrs(models.Permission{Description: p.Description}).
+				FirstOrCreate(&perm).Error; err != nil {
+				return err
+			}
+			perms[perm.Code] = perm
+		}
+
+		for _, r := range builtinRoles {
+			var role models.Role
+			err := tx.Where("name = ?", r.Name).First(&role).Error
+			created := false
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				role = models.Role{Name: r.Name, Description: r.Description, Priority: r.Priority, BuiltIn: true}
+				if err := tx.Create(&role).Error; err != nil {
+					return err
+				}
+				created = true
+			} else if err != nil {
+				return err
+			}
+
+			var codes []string
+			switch {
+			case r.Name == "admin":
+				for code := range perms {
+					codes = append(codes, code)
+				}
+			case created:
+				codes = r.Permissions
+			}
+			for _, code := range codes {
+				if err := tx.Exec(
+					"INSERT INTO role_permissions (role_id, permission_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+					role.ID, perms[code].ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		// Пользователи, которым роль назначили до появления user_roles
+		return tx.Exec(`
+			INSERT INTO user_roles (user_id, role_id, granted_by, created_at)
+			SELECT u.id, r.id, 0, NOW() FROM users u JOIN roles r ON r.name = u.role
+			WHERE r.name <> ? AND u.deleted_at IS NULL
+			ON CONFLICT DO NOTHING`, DefaultRole).Error
+	})
+}
+
+// UserPermissions возвращает коды всех прав пользователя по всем его ролям.
+func UserPermissions(db *gorm.DB, userID uint) ([]string, error) {
+	var codes []string
+	err := db.Table("permissions").
+		Select("DISTINCT permissions.code").
+		Joins("JOIN role_permissions rp ON rp.permission_id = permissions.id").
+		Joins("JOIN user_roles ur ON ur.role_id = rp.role_id").
+		Where("ur.user_id = ?", userID).
+		Pluck("permissions.code", &codes).Error
+	return codes, err
+}
+
+// UserRoleNames возвращает названия ролей пользователя из user_roles.
+func UserRoleNames(db *gorm.DB, userID uint) ([]string, error) {
+	var names []string
+	err := db.Table("roles").
+		Joins("JOIN user_roles ur ON ur.role_id = roles.id").
+		Where("ur.user_id = ?", userID).
+		Pluck("roles.name", &names).Error
+	return names, err
+}
+
+// Has проверяет, есть ли право в списке.
+func Has(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers сообщает, что у actor есть все права target: менять пользователя
+// с правами, которых у тебя нет, нельзя.
+func Covers(actor, target []string) bool {
+	for _, p := range target {
+		if !Has(actor, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Grant выдаёт пользователю роль и обновляет его основную роль.
+func Grant(db *gorm.DB, userID uint, roleName string, grantedBy uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var role models.Role
+		if err := tx.Where("name = ?", roleName).First(&role).Error; err != nil || role.Name == DefaultRole {
+			return ErrUnknownRole
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserRole{
+			UserID:    userID,
+			RoleID:    role.ID,
+			GrantedBy: grantedBy,
+		}).Error; err != nil {
+			return err
+		}
+		return syncPrimaryRole(tx, userID)
+	})
+}
+
+// Revoke отбирает у пользователя роль. Последнего управляющего ролями оставить без права нельзя.
+func Revoke(db *gorm.DB, userID uint, roleName string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var role models.Role
+		if err := tx.Where("name = ?", roleName).First(&role).Error; err != nil {
+			return ErrUnknownRole
+		}
+		if err := tx.Where("user_id = ? AND role_id = ?", userID, role.ID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := EnsureRoleManagerExists(tx); err != nil {
+			return err
+		}
+		return syncPrimaryRole(tx, userID)
+	})
+}
+
+// EnsureRoleManagerExists возвращает ErrLastRoleManager, если ни у кого не осталось права roles:manage.
+// Вызывается внутри транзакции, чтобы изменение откатилось.
+func EnsureRoleManagerExists(tx *gorm.DB) error {
+	var count int64
+	if err := tx.Table("user_roles ur").
+		Joins("JOIN role_permissions rp ON rp.role_id = ur.role_id").
+		Joins("JOIN permissions p ON p.id = rp.permission_id").
+		Joins("JOIN users u ON u.id = ur.user_id AND u.deleted_at IS NULL").
+		Where("p.code = ?", RolesManage).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastRoleManager
+	}
+	return nil
+}
+
+// syncPrimaryRole записывает в users.role роль с наибольшим приоритетом — её видит клиент.
+func syncPrimaryRole(tx *gorm.DB, userID uint) error {
+	primary := DefaultRole
+	var role models.Role
+	err := tx.Joins("JOIN user_roles ur ON ur.role_id = roles.id").
+		Where("ur.user_id = ?", userID).
+		Order("roles.priority DESC").
+		First(&role).Error
+	if err == nil {
+		primary = role.Name
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return tx.Model(&models.User{}).Where("id = ?", userID).Update("role", primary).Error
+}
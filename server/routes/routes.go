@@ -3,28 +3,67 @@ package routes
 import (
 	"diplom/controllers"
 	"diplom/middleware"
+	"diplom/rbac"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
 func Setup(app *fiber.App) {
+	// 0. Открытые ключи для проверки access-токенов другими сервисами
+	app.Get("/.well-known/jwks.json", controllers.GetJWKS)
+
 	api := app.Group("/api")
 
-	// 1. CHAT семейный WebSocket
-	api.Get("/chat/ws", websocket.New(controllers.ChatWebSocket))
+	// 1. CHAT семейный WebSocket (персональный токен — со scope chat:write)
+	api.Get("/chat/ws", middleware.JWTProtected(middleware.ScopeChatWrite), websocket.New(controllers.ChatWebSocket))
 
-	// 2. CHAT HTTP + JWT
-	chat := api.Group("/chat", middleware.JWTProtected())
-	chat.Get("/history", controllers.ChatHistory)
+	// 2. CHAT HTTP + JWT; права проверяются на каждом маршруте, чтобы часть из них была доступна персональным токенам
+	chat := api.Group("/chat")
+	chat.Get("/history",   middleware.JWTProtected(), controllers.ChatHistory)
+	chat.Post("/messages", middleware.JWTProtected(middleware.ScopeChatWrite), controllers.SendChatMessage)
 
 	// 3. AUTH
 	auth := api.Group("/auth")
 	auth.Post("/register", controllers.Register)
 	auth.Post("/login",    controllers.Login)
 	auth.Get("/activate/:link", controllers.Activate)
+	auth.Post("/activate/resend", controllers.ResendActivation)
 	auth.Post("/refresh",  controllers.Refresh)
 	auth.Post("/logout",   controllers.Logout)
+	auth.Get("/email/confirm/:token", controllers.ConfirmEmailChange)
+	auth.Post("/password/forgot", controllers.ForgotPassword)
+	auth.Post("/password/reset",  controllers.ResetPassword)
+	// второй шаг входа с 2FA (по челленджу из /login)
+	auth.Post("/2fa/verify", controllers.VerifyTwoFactorLogin)
+	auth.Post("/2fa/setup",  controllers.SetupTwoFactorLogin)
+	auth.Post("/2fa/enable", controllers.EnableTwoFactorLogin)
+
+	auth.Get("/oidc/providers",          controllers.GetOIDCProviders)
+	auth.Get("/oidc/:provider/login",    controllers.OIDCLogin)
+	auth.Get("/oidc/:provider/callback", controllers.OIDCCallback)
+
+	// 3.1. ПРОФИЛЬ текущего пользователя
+	me := api.Group("/me", middleware.JWTProtected())
+	me.Get("/",            controllers.GetProfile)
+	me.Put("/",            controllers.UpdateProfile)
+	me.Delete("/",         controllers.DeleteAccount)
+	me.Post("/delete/request", controllers.RequestAccountDeletion)
+	me.Get("/export",      controllers.ExportMyData)
+	me.Post("/password",   controllers.ChangePassword)
+	me.Post("/email",      controllers.RequestEmailChange)
+	me.Post("/avatar",     controllers.UploadAvatar)
+	me.Delete("/avatar",   controllers.DeleteAvatar)
+	me.Get("/sessions",             controllers.ListSessions)
+	me.Delete("/sessions/:id",      controllers.RevokeSession)
+	me.Post("/sessions/logout-all", controllers.LogoutEverywhere)
+	me.Get("/tokens",               controllers.ListPersonalTokens)
+	me.Post("/tokens",              controllers.CreatePersonalToken)
+	me.Delete("/tokens/:id",        controllers.RevokePersonalToken)
+	me.Post("/2fa/setup",          controllers.SetupTwoFactor)
+	me.Post("/2fa/enable",         controllers.EnableTwoFactor)
+	me.Post("/2fa/disable",        controllers.DisableTwoFactor)
+	me.Post("/2fa/recovery-codes", controllers.RegenerateRecoveryCodes)
 
 	// 4. FAMILY
 	family := api.Group("/family", middleware.JWTProtected())
@@ -32,28 +71,65 @@ func Setup(app *fiber.App) {
 	family.Post("/invite", controllers.InviteMember)
 	api.Get("/family/accept/:token", controllers.AcceptInvitation)
 	family.Get("/details", controllers.GetFamilyDetails)
+	family.Put("/settings", controllers.UpdateFamily)
+	family.Get("/activity", controllers.GetFamilyActivity)
+	// ссылки-приглашения и QR-коды (только владелец семьи)
+	family.Post("/links",        controllers.CreateJoinLink)
+	family.Get("/links",         controllers.ListJoinLinks)
+	family.Delete("/links/:id",  controllers.RevokeJoinLink)
+	family.Get("/links/:id/qr",  controllers.JoinLinkQR)
+	// заявки на вступление по ссылкам с одобрением
+	family.Get("/join-requests",               controllers.ListJoinRequests)
+	family.Post("/join-requests/:id/approve",  controllers.ApproveJoinRequest)
+	family.Post("/join-requests/:id/reject",   controllers.RejectJoinRequest)
 
 	// 5. CALENDAR
-	calendar := api.Group("/calendar", middleware.JWTProtected())
-	calendar.Post("/events",              controllers.CreateEvent)
-	calendar.Get("/events",               controllers.GetEventsForMonth)
-	calendar.Get("/events/all",           controllers.GetAllEvents)
-	calendar.Post("/events/:id/complete", controllers.CompleteEvent)
-	calendar.Put("/events/:id",           controllers.UpdateEvent)
-	calendar.Get("/list",                 controllers.GetCalendarsList)
-	calendar.Post("/create_extra",        controllers.CreateExtraCalendar)
-	calendar.Get("/:calendar_id/events",  controllers.GetEventsForCalendar)
+	// Сессия или персональный токен со scope calendar:read / calendar:write
+	calendarRead := middleware.JWTProtected(middleware.ScopeCalendarRead)
+	calendarWrite := middleware.JWTProtected(middleware.ScopeCalendarWrite)
+	calendar := api.Group("/calendar")
+	calendar.Post("/events",              calendarWrite, controllers.CreateEvent)
+	calendar.Get("/events",               calendarRead,  controllers.GetEventsForMonth)
+	calendar.Get("/events/all",           calendarRead,  controllers.GetAllEvents)
+	calendar.Get("/events/week",          calendarRead,  controllers.GetEventsForWeek)
+	calendar.Post("/events/:id/complete", calendarWrite, controllers.CompleteEvent)
+	calendar.Put("/events/:id",           calendarWrite, controllers.UpdateEvent)
+	calendar.Get("/list",                 calendarRead,  controllers.GetCalendarsList)
+	calendar.Post("/create_extra",        calendarWrite, controllers.CreateExtraCalendar)
+	calendar.Get("/:calendar_id/events",  calendarRead,  controllers.GetEventsForCalendar)
 
 	// 6. SUBSCRIPTION
 	sub := api.Group("/subscription")
-	sub.Post("/webhook", controllers.YooKassaWebhook)
+	sub.Post("/webhook", controllers.PaymentWebhook)
+	sub.Get("/plans", controllers.ListPlans)
+	// страница оплаты тестового провайдера (только при PAYMENT_PROVIDER=fake)
+	sub.Get("/fake/checkout/:id", controllers.FakeCheckout)
 	subAuth := sub.Group("", middleware.JWTProtected())
 	subAuth.Post("/buy",   controllers.BuySubscription)
 	subAuth.Get("/check",  controllers.CheckSubscription)
+	subAuth.Get("/history", controllers.GetSubscriptionHistory)
+	subAuth.Post("/auto-renew/cancel", controllers.CancelAutoRenew)
+	subAuth.Get("/promo/:code", controllers.CheckPromoCode)
+	subAuth.Post("/trial", controllers.StartTrial)
+	subAuth.Get("/gifts", controllers.ListGifts)
+	subAuth.Post("/gifts", controllers.BuyGift)
+	subAuth.Post("/gifts/redeem", controllers.RedeemGift)
+	subAuth.Get("/payments", controllers.GetMyPayments)
+	subAuth.Get("/payments/:id/receipt", controllers.DownloadReceipt)
 
-	// 7. ADMIN
+	// 7. ADMIN — нужное право указывается на каждом маршруте
 	admin := api.Group("/admin", middleware.JWTProtected())
-	admin.Get("/payments", controllers.GetPaymentHistory)
+	admin.Get("/payments", middleware.RequirePermission(rbac.PaymentsRead), controllers.GetPaymentHistory)
+	admin.Post("/payments/:id/refund", middleware.RequirePermission(rbac.PaymentsRefund), controllers.RefundPayment)
+	admin.Post("/users/:id/2fa-required", middleware.RequirePermission(rbac.UsersTwoFactor), controllers.RequireTwoFactor)
+	admin.Get("/promo-codes", middleware.RequirePermission(rbac.PromoManage), controllers.ListPromoCodes)
+	admin.Post("/promo-codes", middleware.RequirePermission(rbac.PromoManage), controllers.CreatePromoCode)
+	admin.Delete("/promo-codes/:id", middleware.RequirePermission(rbac.PromoManage), controllers.DeactivatePromoCode)
+	// 7.1. Роли и права
+	admin.Get("/roles",                  middleware.RequirePermission(rbac.RolesManage), controllers.ListRoles)
+	admin.Get("/users/:id/roles",        middleware.RequirePermission(rbac.RolesManage), controllers.GetUserRoles)
+	admin.Post("/users/:id/roles",       middleware.RequirePermission(rbac.RolesManage), controllers.GrantRole)
+	admin.Delete("/users/:id/roles/:role", middleware.RequirePermission(rbac.RolesManage), controllers.RevokeRole)
 
 	// 8. SUPPORT (тикеты + чат)
 	support := api.Group("/support", middleware.JWTProtected())
@@ -64,19 +140,19 @@ func Setup(app *fiber.App) {
 	// 8.3. Информация по тикету
 	support.Get("/tickets/:id", controllers.GetTicketInfo)
 	// 8.4. Закрыть тикет
-	support.Post("/tickets/:id/close", controllers.CloseTicket)
+	support.Post("/tickets/:id/close", middleware.RequirePermission(rbac.SupportOperate), controllers.CloseTicket)
 	// 8.5. Получить все сообщения тикета
 	support.Get("/tickets/:id/messages", controllers.GetTicketMessages)
 	// 8.6. Удалить сообщение
 	support.Delete("/tickets/:id/messages/:msgId", controllers.DeleteTicketMessageHTTP)
 
 	// 8.7. Оператор: список тикетов по статусу
-	support.Get("/tickets/operator/list", controllers.ListOperatorTickets)
+	support.Get("/tickets/operator/list", middleware.RequirePermission(rbac.SupportOperate), controllers.ListOperatorTickets)
 	// 8.8. Оператор: взять тикет в работу
-	support.Post("/tickets/:id/assign", controllers.AssignTicket)
+	support.Post("/tickets/:id/assign", middleware.RequirePermission(rbac.SupportOperate), controllers.AssignTicket)
 
-	// 8.9. WebSocket для заметок “новые тикеты” (только операторы)
-	api.Get("/support/new/ws", websocket.New(controllers.SupportNewTicketsWS))
+	// 8.9. WebSocket для заметок “новые тикеты” (только операторы); токен — в ?token=
+	support.Get("/new/ws", middleware.RequirePermission(rbac.SupportOperate), websocket.New(controllers.SupportNewTicketsWS))
 	// 8.10. WebSocket для чата по конкретному тикету
-	api.Get("/support/ws/:id", websocket.New(controllers.SupportTicketChatWS))
+	support.Get("/ws/:id", websocket.New(controllers.SupportTicketChatWS))
 }
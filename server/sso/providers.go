@@ -0,0 +1,149 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Provider — настроенный OIDC-провайдер.
+type Provider struct {
+	Name        string
+	DisplayName string
+	OAuth2      oauth2.Config
+	Verifier    *oidc.IDTokenVerifier
+}
+
+// Claims — поля ID-токена, нужные для входа.
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+}
+
+var ErrUnknownProvider = errors.New("провайдер не настроен")
+
+var (
+	mu        sync.Mutex
+	providers = map[string]*Provider{}
+
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Names возвращает провайдеров из OIDC_PROVIDERS (через запятую), у которых задан client id.
+func Names() []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && env(name, "CLIENT_ID") != "" && env(name, "ISSUER") != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// DisplayName — название кнопки входа; по умолчанию совпадает с именем провайдера.
+func DisplayName(name string) string {
+	if v := env(name, "DISPLAY_NAME"); v != "" {
+		return v
+	}
+	return name
+}
+
+// Get возвращает провайдера по имени. Discovery-документ запрашивается при первом
+// обращении и кэшируется; при ошибке следующая попытка снова пойдёт к провайдеру.
+// Контекст запроса сюда не передаётся: go-oidc использует его и для последующей загрузки ключей (JWKS).
+//
+// Настройки для провайдера NAME:
+//
+//	OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID, OIDC_NAME_CLIENT_SECRET,
+//	OIDC_NAME_SCOPES (по умолчанию "openid email profile"), OIDC_NAME_DISPLAY_NAME.
+//
+// Адрес возврата: OIDC_REDIRECT_BASE_URL + "/api/auth/oidc/<name>/callback".
+func Get(name string) (*Provider, error) {
+	known := false
+	for _, n := range Names() {
+		if n == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, ErrUnknownProvider
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if p, ok := providers[name]; ok {
+		return p, nil
+	}
+
+	ctx := oidc.ClientContext(context.Background(), httpClient)
+	discovered, err := oidc.NewProvider(ctx, env(name, "ISSUER"))
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := strings.Fields(strings.ReplaceAll(env(name, "SCOPES"), ",", " "))
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	clientID := env(name, "CLIENT_ID")
+	p := &Provider{
+		Name:        name,
+		DisplayName: DisplayName(name),
+		OAuth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: env(name, "CLIENT_SECRET"),
+			Endpoint:     discovered.Endpoint(),
+			RedirectURL:  strings.TrimRight(os.Getenv("OIDC_REDIRECT_BASE_URL"), "/") + "/api/auth/oidc/" + name + "/callback",
+			Scopes:       scopes,
+		},
+		Verifier: discovered.Verifier(&oidc.Config{ClientID: clientID}),
+	}
+	providers[name] = p
+	return p, nil
+}
+
+// AuthCodeURL строит ссылку на страницу входа провайдера с PKCE (S256) и nonce.
+func (p *Provider) AuthCodeURL(state, verifier, nonce string) string {
+	return p.OAuth2.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce))
+}
+
+// Exchange обменивает код на токены и проверяет ID-токен: подпись, issuer, audience, срок и nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	var claims Claims
+	ctx = oidc.ClientContext(ctx, httpClient)
+	token, err := p.OAuth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return claims, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return claims, errors.New("провайдер не вернул id_token")
+	}
+	idToken, err := p.Verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return claims, err
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return claims, err
+	}
+	if claims.Nonce != nonce {
+		return claims, errors.New("nonce не совпадает")
+	}
+	return claims, nil
+}
+
+func env(name, key string) string {
+	return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+}
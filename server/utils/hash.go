@@ -1 +1,23 @@
-package utils
\ No newline at end of file
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// GenerateSecureToken возвращает случайный токен из n байт в hex-представлении.
+func GenerateSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken возвращает SHA-256 хэш токена. В БД храним только хэш,
+// чтобы утечка таблицы не давала рабочих ссылок.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
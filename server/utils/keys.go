@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey — ключ подписи access-токенов. У ключей, оставленных только для проверки
+// (после ротации), private == nil.
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+var (
+	keysMu    sync.RWMutex
+	keysByKid = map[string]*signingKey{}
+	activeKey *signingKey
+)
+
+// LoadSigningKeys загружает ключи подписи access-токенов из каталога JWT_KEYS_DIR.
+//
+// Каждый файл <kid>.pem — приватный ключ RSA или Ed25519 (PKCS#8 / PKCS#1), kid берётся из имени файла.
+// Файлы <kid>.pub.pem — открытые ключи выведенных из оборота ключей: ими только проверяют ещё
+// не истёкшие токены. Подписывает ключ JWT_ACTIVE_KID, а если он не задан — последний по имени.
+//
+// Без JWT_KEYS_DIR сервер не запускается: со временным ключом все access-токены становились бы
+// недействительны после каждого перезапуска и на других инстансах. Только при APP_ENV=development
+// создаётся временный ключ Ed25519.
+func LoadSigningKeys() error {
+	keys := map[string]*signingKey{}
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if os.Getenv("APP_ENV") != "development" {
+			return errors.New("JWT_KEYS_DIR не задан; временный ключ подписи допускается только при APP_ENV=development")
+		}
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		key := &signingKey{kid: "dev", method: jwt.SigningMethodEdDSA, private: private, public: private.Public()}
+		keys[key.kid] = key
+		log.Println("JWT_KEYS_DIR не задан: access-токены подписываются временным ключом")
+		return setKeys(keys, key.kid)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	var signers []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		base := filepath.Base(file)
+		if strings.HasSuffix(base, ".pub.pem") {
+			kid := strings.TrimSuffix(base, ".pub.pem")
+			key, err := parsePublicKey(kid, data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", base, err)
+			}
+			keys[kid] = key
+			continue
+		}
+		kid := strings.TrimSuffix(base, ".pem")
+		key, err := parsePrivateKey(kid, data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", base, err)
+		}
+		keys[kid] = key
+		signers = append(signers, kid)
+	}
+	if len(signers) == 0 {
+		return errors.New("в JWT_KEYS_DIR нет приватных ключей")
+	}
+
+	active := os.Getenv("JWT_ACTIVE_KID")
+	if active == "" {
+		sort.Strings(signers)
+		active = signers[len(signers)-1]
+	}
+	return setKeys(keys, active)
+}
+
+func setKeys(keys map[string]*signingKey, active string) error {
+	key, ok := keys[active]
+	if !ok || key.private == nil {
+		return fmt.Errorf("нет приватного ключа для JWT_ACTIVE_KID=%s", active)
+	}
+	keysMu.Lock()
+	keysByKid = keys
+	activeKey = key
+	keysMu.Unlock()
+	return nil
+}
+
+func parsePrivateKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("не PEM")
+	}
+	var parsed interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: k, public: k.Public()}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	}
+	return nil, errors.New("поддерживаются только ключи RSA и Ed25519")
+}
+
+func parsePublicKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("не PEM")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := parsed.(type) {
+	case *rsa.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, public: k}, nil
+	}
+	return nil, errors.New("поддерживаются только ключи RSA и Ed25519")
+}
+
+// signAccessToken подписывает claims активным ключом и проставляет kid в заголовок.
+func signAccessToken(claims jwt.Claims) (string, error) {
+	keysMu.RLock()
+	key := activeKey
+	keysMu.RUnlock()
+	if key == nil {
+		return "", errors.New("ключи подписи не загружены")
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// ParseAccessToken проверяет access-токен: ключ выбирается по kid, а алгоритм обязан
+// совпадать с алгоритмом этого ключа (HS256 и "none" не принимаются).
+func ParseAccessToken(tokenStr string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		keysMu.RLock()
+		key, ok := keysByKid[kid]
+		keysMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("неизвестный kid %q", kid)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("неожиданный алгоритм подписи %s", t.Method.Alg())
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.UserID == 0 {
+		return nil, errors.New("в токене нет user_id")
+	}
+	return claims, nil
+}
+
+// JWK — открытый ключ в формате RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// PublicJWKS возвращает все действующие открытые ключи (активный и оставленные для проверки).
+func PublicJWKS() []JWK {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	kids := make([]string, 0, len(keysByKid))
+	for kid := range keysByKid {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		key := keysByKid[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,21 @@
+package utils
+
+import "testing"
+
+// Без каталога ключей сервер не стартует, кроме режима разработки.
+func TestLoadSigningKeysRequiresKeysDir(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", "")
+
+	t.Setenv("APP_ENV", "")
+	if err := LoadSigningKeys(); err == nil {
+		t.Fatal("временный ключ принят без APP_ENV=development")
+	}
+
+	t.Setenv("APP_ENV", "development")
+	if err := LoadSigningKeys(); err != nil {
+		t.Fatalf("режим разработки: %v", err)
+	}
+	if _, err := GenerateAccessToken(1, "user@example.com", "user", ""); err != nil {
+		t.Fatalf("подпись временным ключом: %v", err)
+	}
+}
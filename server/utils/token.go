@@ -1,30 +1,71 @@
 package utils
 
 import (
+	"errors"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// AccessClaims — содержимое access-токена.
+type AccessClaims struct {
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// RefreshClaims — содержимое refresh-токена. ID (jti) нужен, чтобы токены, выданные в одну секунду, не совпадали.
+type RefreshClaims struct {
+	UserID uint `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
 // GenerateAccessToken создает access токен для пользователя с коротким сроком действия.
-func GenerateAccessToken(userID uint, email, role string) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = userID
-	claims["email"] = email
-	claims["role"] = role
-	claims["exp"] = time.Now().Add(15 * time.Minute).Unix() // Access-токен действует 15 минут
-	secret := os.Getenv("JWT_SECRET")
-	return token.SignedString([]byte(secret))
+// sessionID связывает токен с сессией (цепочкой refresh-токенов), чтобы её можно было отозвать.
+// Подписывается асимметричным ключом (см. LoadSigningKeys), поэтому проверить токен можно по JWKS без общего секрета.
+func GenerateAccessToken(userID uint, email, role, sessionID string) (string, error) {
+	claims := AccessClaims{
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)), // Access-токен действует 15 минут
+		},
+	}
+	return signAccessToken(claims)
 }
 
 // GenerateRefreshToken создает refresh токен для пользователя с более длительным сроком действия.
 func GenerateRefreshToken(userID uint) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = userID
-	claims["exp"] = time.Now().Add(7 * 24 * time.Hour).Unix() // Refresh-токен действует 7 дней
+	claims := RefreshClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)), // Refresh-токен действует 7 дней
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	refreshSecret := os.Getenv("JWT_REFRESH_SECRET")
 	return token.SignedString([]byte(refreshSecret))
 }
+
+// ParseRefreshToken проверяет refresh-токен. Он нужен только этому сервису, поэтому остаётся на HS256
+// с JWT_REFRESH_SECRET, но другой алгоритм не принимается.
+func ParseRefreshToken(tokenStr string) (*RefreshClaims, error) {
+	claims := &RefreshClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_REFRESH_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.UserID == 0 {
+		return nil, errors.New("в токене нет user_id")
+	}
+	return claims, nil
+}
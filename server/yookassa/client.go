@@ -0,0 +1,247 @@
+package yookassa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultBaseURL = "https://api.yookassa.ru/v3"
+
+var (
+	ErrPaymentNotFound = errors.New("платёж не найден в YooKassa")
+	ErrRefundNotFound  = errors.New("возврат не найден в YooKassa")
+)
+
+// Amount — сумма платежа в формате YooKassa ("300.00", "RUB").
+type Amount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// Payment — объект платежа из API YooKassa (нужные нам поля).
+type Payment struct {
+	ID          string            `json:"id"`
+	Status      string            `json:"status"` // pending, waiting_for_capture, succeeded, canceled
+	Paid        bool              `json:"paid"`
+	Amount      Amount            `json:"amount"`
+	Description string            `json:"description"`
+	Metadata    map[string]string `json:"metadata"`
+	CreatedAt   string            `json:"created_at"`
+
+	// Регистрация чека (54-ФЗ), если он передан при создании: pending, succeeded, canceled
+	ReceiptRegistration string `json:"receipt_registration,omitempty"`
+
+	Confirmation struct {
+		Type            string `json:"type"`
+		ConfirmationURL string `json:"confirmation_url"`
+	} `json:"confirmation"`
+
+	// Способ оплаты; Saved — сохранён для автоплатежей (запрошено save_payment_method)
+	PaymentMethod *struct {
+		Type  string `json:"type"`
+		ID    string `json:"id"`
+		Saved bool   `json:"saved"`
+		Title string `json:"title"`
+	} `json:"payment_method,omitempty"`
+
+	// Причина отмены платежа (для status = canceled)
+	CancellationDetails *struct {
+		Party  string `json:"party"`
+		Reason string `json:"reason"`
+	} `json:"cancellation_details,omitempty"`
+}
+
+// Confirmation — сценарий подтверждения платежа пользователем.
+type Confirmation struct {
+	Type      string `json:"type"`
+	ReturnURL string `json:"return_url"`
+}
+
+// Receipt — данные для чека (54-ФЗ): покупатель и позиции.
+type Receipt struct {
+	Customer      *Customer     `json:"customer,omitempty"`
+	Items         []ReceiptItem `json:"items"`
+	TaxSystemCode int           `json:"tax_system_code,omitempty"` // система налогообложения, 1–6
+}
+
+// Customer — контакт покупателя, на который придёт чек.
+type Customer struct {
+	FullName string `json:"full_name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+}
+
+// ReceiptItem — позиция чека.
+type ReceiptItem struct {
+	Description    string `json:"description"` // до 128 символов
+	Quantity       string `json:"quantity"`    // "1.00"
+	Amount         Amount `json:"amount"`      // цена за единицу
+	VatCode        int    `json:"vat_code"`    // ставка НДС, 1 — без НДС
+	PaymentSubject string `json:"payment_subject,omitempty"`
+	PaymentMode    string `json:"payment_mode,omitempty"`
+}
+
+// CreatePaymentRequest — тело POST /payments. Для автоплатежа по сохранённому способу
+// указывается PaymentMethodID, а Confirmation не передаётся.
+type CreatePaymentRequest struct {
+	Amount            Amount            `json:"amount"`
+	Confirmation      *Confirmation     `json:"confirmation,omitempty"`
+	Capture           bool              `json:"capture"`
+	Description       string            `json:"description"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	SavePaymentMethod bool              `json:"save_payment_method,omitempty"`
+	PaymentMethodID   string            `json:"payment_method_id,omitempty"`
+	Receipt           *Receipt          `json:"receipt,omitempty"`
+}
+
+// CreateRefundRequest — тело POST /refunds.
+type CreateRefundRequest struct {
+	PaymentID   string   `json:"payment_id"`
+	Amount      Amount   `json:"amount"`
+	Description string   `json:"description,omitempty"`
+	Receipt     *Receipt `json:"receipt,omitempty"` // чек возврата, если чек был у платежа
+}
+
+// Refund — объект возврата из API YooKassa.
+type Refund struct {
+	ID        string `json:"id"`
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"` // pending, succeeded, canceled
+	Amount    Amount `json:"amount"`
+	CreatedAt string `json:"created_at"`
+
+	ReceiptRegistration string `json:"receipt_registration,omitempty"`
+}
+
+// APIError — ответ YooKassa с кодом, отличным от 2xx.
+type APIError struct {
+	StatusCode  int
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("YooKassa %d: %s %s", e.StatusCode, e.Code, e.Description)
+}
+
+// Client — клиент API YooKassa. Адрес API можно переопределить (YOO_KASSA_API_URL),
+// чтобы проверять обработку платежей на локальной заглушке.
+type Client struct {
+	BaseURL   string
+	ShopID    string
+	SecretKey string
+	HTTP      *http.Client
+}
+
+// NewFromEnv создаёт клиента по YOO_KASSA_SHOP_ID, YOO_KASSA_SECRET_KEY и YOO_KASSA_API_URL.
+func NewFromEnv() *Client {
+	base := strings.TrimRight(os.Getenv("YOO_KASSA_API_URL"), "/")
+	if base == "" {
+		base = defaultBaseURL
+	}
+	return &Client{
+		BaseURL:   base,
+		ShopID:    os.Getenv("YOO_KASSA_SHOP_ID"),
+		SecretKey: os.Getenv("YOO_KASSA_SECRET_KEY"),
+		HTTP:      &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// GetPayment запрашивает актуальное состояние платежа: GET /payments/{id}.
+func (c *Client) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	if id == "" {
+		return nil, ErrPaymentNotFound
+	}
+	var p Payment
+	if err := c.do(ctx, http.MethodGet, "/payments/"+url.PathEscape(id), nil, "", &p); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, err
+	}
+	if p.ID != id {
+		return nil, fmt.Errorf("YooKassa вернула платёж %q вместо %q", p.ID, id)
+	}
+	return &p, nil
+}
+
+// CreatePayment создаёт платёж: POST /payments. Повтор с тем же idempotenceKey вернёт тот же платёж.
+func (c *Client) CreatePayment(ctx context.Context, in CreatePaymentRequest, idempotenceKey string) (*Payment, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	var p Payment
+	if err := c.do(ctx, http.MethodPost, "/payments", bytes.NewReader(body), idempotenceKey, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreateRefund создаёт возврат по платежу: POST /refunds.
+func (c *Client) CreateRefund(ctx context.Context, in CreateRefundRequest, idempotenceKey string) (*Refund, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	var r Refund
+	if err := c.do(ctx, http.MethodPost, "/refunds", bytes.NewReader(body), idempotenceKey, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetRefund запрашивает возврат: GET /refunds/{id}.
+func (c *Client) GetRefund(ctx context.Context, id string) (*Refund, error) {
+	if id == "" {
+		return nil, ErrRefundNotFound
+	}
+	var r Refund
+	if err := c.do(ctx, http.MethodGet, "/refunds/"+url.PathEscape(id), nil, "", &r); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, ErrRefundNotFound
+		}
+		return nil, err
+	}
+	if r.ID != id {
+		return nil, fmt.Errorf("YooKassa вернула возврат %q вместо %q", r.ID, id)
+	}
+	return &r, nil
+}
+
+// do выполняет запрос к API. idempotenceKey передаётся для POST-запросов.
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, idempotenceKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.ShopID, c.SecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	if idempotenceKey != "" {
+		req.Header.Set("Idempotence-Key", idempotenceKey)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(apiErr)
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,60 @@
+package yookassa
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// Адреса, с которых YooKassa отправляет уведомления (https://yookassa.ru/developers/using-api/webhooks).
+var defaultNotificationNetworks = []string{
+	"185.71.76.0/27",
+	"185.71.77.0/27",
+	"77.75.153.0/25",
+	"77.75.156.11/32",
+	"77.75.156.35/32",
+	"77.75.154.128/25",
+	"2a02:5180::/32",
+}
+
+// TrustedNotificationIP проверяет, что уведомление пришло с адреса YooKassa.
+// Список сетей можно заменить через YOO_KASSA_WEBHOOK_IPS (через запятую, адреса или CIDR) —
+// например, "127.0.0.1" для локальной заглушки.
+func TrustedNotificationIP(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range notificationNetworks() {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func notificationNetworks() []*net.IPNet {
+	list := defaultNotificationNetworks
+	if v := os.Getenv("YOO_KASSA_WEBHOOK_IPS"); strings.TrimSpace(v) != "" {
+		list = strings.Split(v, ",")
+	}
+
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(s); err == nil {
+			nets = append(nets, network)
+		}
+	}
+	return nets
+}